@@ -7,6 +7,7 @@ import (
 	"github.com/sentrycloud/sentry/pkg/agent/script"
 	"github.com/sentrycloud/sentry/pkg/agent/system"
 	"github.com/sentrycloud/sentry/pkg/cmdflags"
+	"github.com/sentrycloud/sentry/pkg/graceful"
 	"github.com/sentrycloud/sentry/pkg/newlog"
 	"github.com/sentrycloud/sentry/pkg/profile"
 	"time"
@@ -31,12 +32,24 @@ func main() {
 
 	httpcollector.Start(agentReporter, agentConfig.HttpPort)
 
+	var schedulers []*script.Scheduler
 	for _, s := range agentConfig.Scripts {
-		script.StartScriptScheduler(s.ScriptPath, s.ScriptType, agentReporter)
+		schedulers = append(schedulers, script.StartScriptScheduler(s.ScriptPath, s.ScriptType, agentReporter)...)
 	}
 
 	go system.CollectSystemMetric(agentReporter)
 
+	profileServer := profile.StartProfile(agentConfig.ProfilePort)
 	newlog.Info("start complete in %d ms", time.Now().UnixMilli()-startTime)
-	profile.StartProfileInBlockMode(agentConfig.ProfilePort)
+
+	graceful.WaitForSignal()
+
+	// stop collecting metrics first, then send all cached metrics to sentry server
+	httpcollector.Stop()
+	for _, scheduler := range schedulers {
+		scheduler.Stop()
+	}
+	agentReporter.Stop(graceful.ShutdownTimeout)
+	graceful.ShutdownHttpServer(profileServer, "profile")
+	newlog.Info("shutdown complete")
 }
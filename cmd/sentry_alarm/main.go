@@ -9,6 +9,7 @@ import (
 	"github.com/sentrycloud/sentry/pkg/alarm/sender"
 	"github.com/sentrycloud/sentry/pkg/cmdflags"
 	"github.com/sentrycloud/sentry/pkg/dbmodel"
+	"github.com/sentrycloud/sentry/pkg/graceful"
 	"github.com/sentrycloud/sentry/pkg/newlog"
 	"github.com/sentrycloud/sentry/pkg/profile"
 	"time"
@@ -45,6 +46,12 @@ func main() {
 	ruleManager := rule.NewManager()
 	ruleManager.Start()
 
+	profileServer := profile.StartProfile(alarmConfig.ProfilePort)
 	newlog.Info("sentry alarm server start complete in %d ms", time.Now().UnixMilli()-startTime)
-	profile.StartProfileInBlockMode(alarmConfig.ProfilePort)
+
+	graceful.WaitForSignal()
+
+	ruleManager.Stop()
+	graceful.ShutdownHttpServer(profileServer, "profile")
+	newlog.Info("sentry alarm server shutdown complete")
 }
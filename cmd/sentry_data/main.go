@@ -0,0 +1,163 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/buger/jsonparser"
+	"github.com/sentrycloud/sentry/pkg"
+	"github.com/sentrycloud/sentry/pkg/protocol"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sentry_data is a command line tool to export data points from sentry_server to a file,
+// and import the exported file back to sentry_server (maybe another environment), usage:
+//
+//	sentry_data export -s 127.0.0.1:51001 -metric sentry_sys_cpu_usage -tags '{"sentryIP":"10.0.0.1"}' -start 1693884000 -end 1693970400 -format csv -o cpu.csv
+//	sentry_data import -s 127.0.0.1:51001 -f cpu.csv
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(1)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "export":
+		err = exportData(os.Args[2:])
+	case "import":
+		err = importData(os.Args[2:])
+	case "-v":
+		fmt.Printf("version: %s\n", pkg.Version)
+	default:
+		usage()
+	}
+
+	if err != nil {
+		fmt.Printf("%s failed: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Println("usage: ")
+	fmt.Println("  sentry_data export -s serverAddr -metric metric [-tags tagsJson] [-start start -end end | -last last] [-format json|csv] [-o outputFile]")
+	fmt.Println("  sentry_data import -s serverAddr -f inputFile [-format json|csv]")
+	fmt.Println("  sentry_data -v :show version")
+}
+
+func serverURL(addr string, path string) string {
+	if !strings.HasPrefix(addr, "http://") && !strings.HasPrefix(addr, "https://") {
+		addr = "http://" + addr
+	}
+	return addr + path
+}
+
+func exportData(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	serverAddr := flags.String("s", "127.0.0.1:51001", "sentry_server http address")
+	metric := flags.String("metric", "", "metric to export")
+	tags := flags.String("tags", "{}", "tag filter in json format")
+	start := flags.Int64("start", 0, "start timestamp in seconds")
+	end := flags.Int64("end", 0, "end timestamp in seconds")
+	last := flags.Int64("last", 0, "export the last seconds, used when start and end are not set")
+	format := flags.String("format", protocol.ExportFormatJson, "export format: json or csv")
+	output := flags.String("o", "", "output file path, default is metric.format")
+	_ = flags.Parse(args)
+
+	req := protocol.ExportRequest{
+		Start:  *start,
+		End:    *end,
+		Last:   *last,
+		Format: *format,
+		Metric: *metric,
+	}
+
+	err := protocol.Json.UnmarshalFromString(*tags, &req.Tags)
+	if err != nil {
+		return fmt.Errorf("invalid tags: %v", err)
+	}
+
+	body, err := protocol.Json.Marshal(&req)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.Post(serverURL(*serverAddr, protocol.ExportUrl), "application/json", strings.NewReader(string(body)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// a failed export is responded with a json QueryResp instead of a file
+	if resp.StatusCode != http.StatusOK || len(resp.Header.Get("Content-Disposition")) == 0 {
+		data, _ := io.ReadAll(resp.Body)
+		msg, _ := jsonparser.GetString(data, "msg")
+		return fmt.Errorf("http status=%d, msg=%s", resp.StatusCode, msg)
+	}
+
+	if len(*output) == 0 {
+		*output = *metric + "." + *format
+	}
+
+	file, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	n, err := io.Copy(file, resp.Body)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("export %d bytes to %s\n", n, *output)
+	return nil
+}
+
+func importData(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	serverAddr := flags.String("s", "127.0.0.1:51001", "sentry_server http address")
+	input := flags.String("f", "", "input file path")
+	format := flags.String("format", "", "import format: json or csv, default is decided by the file extension")
+	_ = flags.Parse(args)
+
+	if len(*format) == 0 {
+		*format = protocol.ExportFormatJson
+		if strings.ToLower(filepath.Ext(*input)) == ".csv" {
+			*format = protocol.ExportFormatCsv
+		}
+	}
+
+	file, err := os.Open(*input)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	url := serverURL(*serverAddr, protocol.ImportUrl) + "?format=" + *format
+	resp, err := http.Post(url, "application/octet-stream", file)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	code, _ := jsonparser.GetInt(data, "code")
+	total, _ := jsonparser.GetInt(data, "data", "total")
+	invalid, _ := jsonparser.GetInt(data, "data", "invalid")
+	if code != protocol.CodeOK {
+		msg, _ := jsonparser.GetString(data, "msg")
+		return fmt.Errorf("msg=%s, total=%d, invalid=%d", msg, total, invalid)
+	}
+
+	fmt.Printf("import %s complete, total=%d, invalid=%d\n", *input, total, invalid)
+	return nil
+}
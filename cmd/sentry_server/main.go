@@ -3,14 +3,17 @@ package main
 import (
 	"github.com/sentrycloud/sentry/pkg/cmdflags"
 	"github.com/sentrycloud/sentry/pkg/dbmodel"
+	"github.com/sentrycloud/sentry/pkg/graceful"
 	"github.com/sentrycloud/sentry/pkg/newlog"
 	"github.com/sentrycloud/sentry/pkg/profile"
 	"github.com/sentrycloud/sentry/pkg/server/collector"
 	"github.com/sentrycloud/sentry/pkg/server/config"
 	"github.com/sentrycloud/sentry/pkg/server/merge"
+	"github.com/sentrycloud/sentry/pkg/server/metadata"
 	"github.com/sentrycloud/sentry/pkg/server/monitor"
 	"github.com/sentrycloud/sentry/pkg/server/taos"
 	"github.com/sentrycloud/sentry/pkg/server/web"
+	"github.com/sentrycloud/sentry/pkg/server/web/tsdb"
 	"time"
 )
 
@@ -31,6 +34,7 @@ func main() {
 	dbmodel.NewMySQL(&serverConfig.MySQLServer)
 
 	monitor.InitMonitor()
+	metadata.Start()
 
 	// create time series database connection pool
 	var connPool = taos.CreateConnPool(serverConfig.TaosServer)
@@ -42,6 +46,10 @@ func main() {
 		go taos.StartScanTables(connPool)
 	}
 
+	if serverConfig.Rollup {
+		go taos.StartRollup(connPool)
+	}
+
 	// start the tcp collector server
 	var server = collector.Collector{}
 	server.Start(serverConfig, merger)
@@ -49,14 +57,28 @@ func main() {
 	// start the http collector and query server
 	web.Start(&serverConfig, &server)
 
+	if serverConfig.Cardinality {
+		go tsdb.StartCardinalityScan() // after web.Start, the query connection pool is created there
+	}
+
 	go func() {
 		for {
 			server.CollectMetrics()
 			merger.CollectMetrics()
+			tsdb.CollectMetrics()
 			time.Sleep(10 * time.Second)
 		}
 	}()
 
+	profileServer := profile.StartProfile(serverConfig.ProfilePort)
 	newlog.Info("sentry server start complete in %d ms", time.Now().UnixMilli()-startTime)
-	profile.StartProfileInBlockMode(serverConfig.ProfilePort)
+
+	graceful.WaitForSignal()
+
+	// stop receiving data points first, then flush all buffered data points to TDengine
+	web.Stop()
+	server.Stop()
+	merger.Stop(graceful.ShutdownTimeout)
+	graceful.ShutdownHttpServer(profileServer, "profile")
+	newlog.Info("sentry server shutdown complete")
 }
@@ -48,6 +48,21 @@ CREATE TABLE `metric_white_list` (
     KEY `idx_metric` (`metric`)
 ) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8;
 
+CREATE TABLE `metric_meta` (
+    `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT,
+    `created` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `updated` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    `is_deleted` tinyint(4) unsigned NOT NULL DEFAULT '0',
+    `metric` varchar(255) NOT NULL,
+    `unit` varchar(64) NOT NULL DEFAULT '',
+    `type` varchar(32) NOT NULL DEFAULT '',
+    `description` varchar(1024) NOT NULL DEFAULT '',
+    `app_name` varchar(255) NOT NULL DEFAULT '',
+    PRIMARY KEY (`id`),
+    KEY `idx_deleted` (`is_deleted`),
+    UNIQUE KEY `uk_metric` (`metric`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8;
+
 CREATE TABLE `dashboard` (
     `id` int(10) UNSIGNED NOT NULL AUTO_INCREMENT,
     `created` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
@@ -89,7 +104,8 @@ CREATE TABLE `line` (
     `name` varchar(128) NOT NULL DEFAULT '',
     `metric` varchar(255) NOT NULL DEFAULT '',
     `tags` varchar(4096) NOT NULL DEFAULT '',
-    `offset` int NOT NULL DEFAULT '0',
+    `offset` varchar(128) NOT NULL DEFAULT '',
+    `expression` varchar(1024) NOT NULL DEFAULT '',
     PRIMARY KEY (`id`),
     KEY `idx_deleted` (`is_deleted`),
     KEY `idx_deleted_chart_id` (`is_deleted`,`chart_id`),
@@ -128,42 +144,42 @@ INSERT INTO `dashboard` (`name`, `creator`, `app_name`, `chart_layout`, `tag_fil
 INSERT INTO `chart` (`dashboard_id`, `name`, `type`, `aggregation`, `down_sample`, `topn_limit`)
     VALUES (1, 'http QPS', 'line', 'sum', '10s', 10);
 INSERT INTO `line` (`chart_id`, `name`, `metric`, `tags`, `offset`)
-    VALUES (1, 'qps', 'sentry_server_http_qps', '{"api":"*"}', 0);
+    VALUES (1, 'qps', 'sentry_server_http_qps', '{"api":"*"}', '');
 
 INSERT INTO `chart` (`dashboard_id`, `name`, `type`, `aggregation`, `down_sample`, `topn_limit`)
     VALUES (1, 'http RT', 'line', 'avg', '10s', 10);
 INSERT INTO `line` (`chart_id`, `name`, `metric`, `tags`, `offset`)
-    VALUES (2, 'rt', 'sentry_server_http_rt', '{"api":"*"}', 0);
+    VALUES (2, 'rt', 'sentry_server_http_rt', '{"api":"*"}', '');
 
 INSERT INTO `chart` (`dashboard_id`, `name`, `type`, `aggregation`, `down_sample`, `topn_limit`)
     VALUES (1, 'goroutine number', 'line', 'avg', '10s', 10);
 INSERT INTO `line` (`chart_id`, `name`, `metric`, `tags`, `offset`)
-    VALUES (3, 'number', 'sentry_go_num', '{}', 0);
+    VALUES (3, 'number', 'sentry_go_num', '{}', '');
 
 INSERT INTO `chart` (`dashboard_id`, `name`, `type`, `aggregation`, `down_sample`, `topn_limit`)
     VALUES (1, 'gc number', 'line', 'avg', '10s', 10);
 INSERT INTO `line` (`chart_id`, `name`, `metric`, `tags`, `offset`)
-    VALUES (4, 'number', 'sentry_gc_num', '{}', 0);
+    VALUES (4, 'number', 'sentry_gc_num', '{}', '');
 
 INSERT INTO `chart` (`dashboard_id`, `name`, `type`, `aggregation`, `down_sample`, `topn_limit`)
     VALUES (1, 'gc pause time(ms)', 'line', 'avg', '10s', 10);
 INSERT INTO `line` (`chart_id`, `name`, `metric`, `tags`, `offset`)
-    VALUES (5, 'pause', 'sentry_gc_pause', '{}', 0);
+    VALUES (5, 'pause', 'sentry_gc_pause', '{}', '');
 
 INSERT INTO `chart` (`dashboard_id`, `name`, `type`, `aggregation`, `down_sample`, `topn_limit`)
     VALUES (1, 'agent number', 'line', 'sum', '10s', 10);
 INSERT INTO `line` (`chart_id`, `name`, `metric`, `tags`, `offset`)
-    VALUES (6, 'agent number', 'sentry_server_agent_count', '{}', 0);
+    VALUES (6, 'agent number', 'sentry_server_agent_count', '{}', '');
 
 INSERT INTO `chart` (`dashboard_id`, `name`, `type`, `aggregation`, `down_sample`, `topn_limit`)
     VALUES (1, 'data points QPS', 'line', 'sum', '10s', 10);
 INSERT INTO `line` (`chart_id`, `name`, `metric`, `tags`, `offset`)
-    VALUES (7, 'qps', 'sentry_server_data_point', '{}', 0);
+    VALUES (7, 'qps', 'sentry_server_data_point', '{}', '');
 
 INSERT INTO `chart` (`dashboard_id`, `name`, `type`, `aggregation`, `down_sample`, `topn_limit`)
     VALUES (1, 'merge chan size', 'line', 'max', '10s', 10);
 INSERT INTO `line` (`chart_id`, `name`, `metric`, `tags`, `offset`)
-    VALUES (8, 'chan size', 'sentry_server_chan_size', '{"chan":"*"}', 0);
+    VALUES (8, 'chan size', 'sentry_server_chan_size', '{"chan":"*"}', '');
 
 /* create dashboard for machine monitor (cpu, memory, disk, network ...) */
 INSERT INTO `dashboard` (`name`, `creator`, `app_name`, `chart_layout`, `tag_filter`)
@@ -174,60 +190,60 @@ INSERT INTO `dashboard` (`name`, `creator`, `app_name`, `chart_layout`, `tag_fil
 INSERT INTO `chart` (`dashboard_id`, `name`, `type`, `aggregation`, `down_sample`, `topn_limit`)
     VALUES (2, 'cpu usage', 'line', 'max', '10s', 10);
 INSERT INTO `line` (`chart_id`, `name`, `metric`, `tags`, `offset`)
-    VALUES (9, 'cpu', 'sentry_sys_cpu_usage', '{}', 0);
+    VALUES (9, 'cpu', 'sentry_sys_cpu_usage', '{}', '');
 
 INSERT INTO `chart` (`dashboard_id`, `name`, `type`, `aggregation`, `down_sample`, `topn_limit`)
     VALUES (2, 'load average', 'line', 'max', '10s', 10);
 INSERT INTO `line` (`chart_id`, `name`, `metric`, `tags`, `offset`)
-    VALUES (10, 'load', 'sentry_sys_load_average', '{}', 0);
+    VALUES (10, 'load', 'sentry_sys_load_average', '{}', '');
 
 INSERT INTO `chart` (`dashboard_id`, `name`, `type`, `aggregation`, `down_sample`, `topn_limit`)
     VALUES (2, 'memory usage', 'line', 'max', '10s', 10);
 INSERT INTO `line` (`chart_id`, `name`, `metric`, `tags`, `offset`)
-    VALUES (11, 'memory', 'sentry_sys_mem_usage', '{}', 0);
+    VALUES (11, 'memory', 'sentry_sys_mem_usage', '{}', '');
 
 INSERT INTO `chart` (`dashboard_id`, `name`, `type`, `aggregation`, `down_sample`, `topn_limit`)
     VALUES (2, 'disk usage', 'line', 'max', '10s', 10);
 INSERT INTO `line` (`chart_id`, `name`, `metric`, `tags`, `offset`)
-    VALUES (12, 'disk usage', 'sentry_sys_disk_usage', '{"device":"*"}', 0);
+    VALUES (12, 'disk usage', 'sentry_sys_disk_usage', '{"device":"*"}', '');
 
 INSERT INTO `chart` (`dashboard_id`, `name`, `type`, `aggregation`, `down_sample`, `topn_limit`)
     VALUES (2, 'disk io wait', 'line', 'max', '10s', 10);
 INSERT INTO `line` (`chart_id`, `name`, `metric`, `tags`, `offset`)
-    VALUES (13, 'io wait', 'sentry_sys_io_wait', '{}', 0);
+    VALUES (13, 'io wait', 'sentry_sys_io_wait', '{}', '');
 
 INSERT INTO `chart` (`dashboard_id`, `name`, `type`, `aggregation`, `down_sample`, `topn_limit`)
     VALUES (2, 'disk io util', 'line', 'max', '10s', 10);
 INSERT INTO `line` (`chart_id`, `name`, `metric`, `tags`, `offset`)
-    VALUES (14, 'io util', 'sentry_sys_io_util', '{}', 0);
+    VALUES (14, 'io util', 'sentry_sys_io_util', '{}', '');
 
 INSERT INTO `chart` (`dashboard_id`, `name`, `type`, `aggregation`, `down_sample`, `topn_limit`)
     VALUES (2, 'disk io stats', 'line', 'max', '10s', 10);
 INSERT INTO `line` (`chart_id`, `name`, `metric`, `tags`, `offset`)
-    VALUES (15, 'read bytes/s', 'sentry_sys_io_read', '{}', 0);
+    VALUES (15, 'read bytes/s', 'sentry_sys_io_read', '{}', '');
 INSERT INTO `line` (`chart_id`, `name`, `metric`, `tags`, `offset`)
-    VALUES (15, 'write bytes/s', 'sentry_sys_io_write', '{}', 0);
+    VALUES (15, 'write bytes/s', 'sentry_sys_io_write', '{}', '');
 
 INSERT INTO `chart` (`dashboard_id`, `name`, `type`, `aggregation`, `down_sample`, `topn_limit`)
     VALUES (2, 'net stats in bytes', 'line', 'max', '10s', 10);
 INSERT INTO `line` (`chart_id`, `name`, `metric`, `tags`, `offset`)
-    VALUES (16, 'bytes sent/s', 'sentry_sys_net_bytes_sent', '{}', 0);
+    VALUES (16, 'bytes sent/s', 'sentry_sys_net_bytes_sent', '{}', '');
 INSERT INTO `line` (`chart_id`, `name`, `metric`, `tags`, `offset`)
-    VALUES (16, 'bytes recv/s', 'sentry_sys_net_bytes_recv', '{}', 0);
+    VALUES (16, 'bytes recv/s', 'sentry_sys_net_bytes_recv', '{}', '');
 
 INSERT INTO `chart` (`dashboard_id`, `name`, `type`, `aggregation`, `down_sample`, `topn_limit`)
     VALUES (2, 'net stats in packets', 'line', 'max', '10s', 10);
 INSERT INTO `line` (`chart_id`, `name`, `metric`, `tags`, `offset`)
-    VALUES (17, 'packets sent/s', 'sentry_sys_net_packets_sent', '{}', 0);
+    VALUES (17, 'packets sent/s', 'sentry_sys_net_packets_sent', '{}', '');
 INSERT INTO `line` (`chart_id`, `name`, `metric`, `tags`, `offset`)
-    VALUES (17, 'packets recv/s', 'sentry_sys_net_packets_recv', '{}', 0);
+    VALUES (17, 'packets recv/s', 'sentry_sys_net_packets_recv', '{}', '');
 
 INSERT INTO `chart` (`dashboard_id`, `name`, `type`, `aggregation`, `down_sample`, `topn_limit`)
     VALUES (2, 'tcp status', 'line', 'max', '10s', 10);
 INSERT INTO `line` (`chart_id`, `name`, `metric`, `tags`, `offset`)
-    VALUES (18, 'status', 'sentry_sys_tcp_status', '{"status":"*"}', 0);
+    VALUES (18, 'status', 'sentry_sys_tcp_status', '{"status":"*"}', '');
 
 INSERT INTO `chart` (`dashboard_id`, `name`, `type`, `aggregation`, `down_sample`, `topn_limit`)
     VALUES (2, 'process number', 'line', 'max', '10s', 10);
 INSERT INTO `line` (`chart_id`, `name`, `metric`, `tags`, `offset`)
-    VALUES (19, 'number', 'sentry_sys_process_number', '{}', 0);
+    VALUES (19, 'number', 'sentry_sys_process_number', '{}', '');
@@ -0,0 +1,5 @@
+-- offset of line was an int of days, it's a comma separated list of time shifts like '-1d,-1w' now.
+-- run it once on MySQL of an existing install, create_tables.sql already has the new column
+ALTER TABLE `line` MODIFY `offset` varchar(128) NOT NULL DEFAULT '';
+UPDATE `line` SET `offset` = '' WHERE `offset` = '0';
+UPDATE `line` SET `offset` = CONCAT(`offset`, 'd') WHERE `offset` REGEXP '^-?[0-9]+$';
@@ -1,14 +1,19 @@
 package httpcollector
 
 import (
+	"errors"
 	"fmt"
 	"github.com/sentrycloud/sentry/pkg/agent/reporter"
+	"github.com/sentrycloud/sentry/pkg/graceful"
 	"github.com/sentrycloud/sentry/pkg/newlog"
 	"github.com/sentrycloud/sentry/pkg/protocol"
 	"net/http"
 )
 
-var agentReporter *reporter.Reporter
+var (
+	agentReporter *reporter.Reporter
+	httpServer    *http.Server
+)
 
 func putMetricsHandler(w http.ResponseWriter, req *http.Request) {
 	metrics, err := protocol.CollectHttpMetrics(w, req)
@@ -24,10 +29,16 @@ func Start(report *reporter.Reporter, httpPort int) {
 	mux.HandleFunc("/agent/api/putMetrics", putMetricsHandler)
 
 	newlog.Info("Listen on http port %d", httpPort)
+	httpServer = &http.Server{Addr: fmt.Sprintf("0.0.0.0:%d", httpPort), Handler: mux}
 	go func() {
-		err := http.ListenAndServe(fmt.Sprintf("0.0.0.0:%d", httpPort), mux)
-		if err != nil {
+		err := httpServer.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			newlog.Fatal("Listen on http port %d failed: %v", httpPort, err)
 		}
 	}()
 }
+
+// Stop the http server, so no more metrics will be put to the reporter
+func Stop() {
+	graceful.ShutdownHttpServer(httpServer, "sentry_agent")
+}
@@ -21,6 +21,8 @@ type Reporter struct {
 
 	metricsChan chan []protocol.MetricValue
 	ticker      *time.Ticker
+	stopChan    chan struct{}
+	doneChan    chan struct{}
 }
 
 func (r *Reporter) Start(serverAddr string) {
@@ -39,10 +41,23 @@ func (r *Reporter) Start(serverAddr string) {
 
 	r.metricsChan = make(chan []protocol.MetricValue, MetricsChanSize)
 	r.ticker = time.NewTicker(1 * time.Second)
+	r.stopChan = make(chan struct{})
+	r.doneChan = make(chan struct{})
 
 	go r.listenChanEvents()
 }
 
+// Stop send all cached metrics to sentry server, wait until complete or timeout
+func (r *Reporter) Stop(timeout time.Duration) {
+	close(r.stopChan)
+	select {
+	case <-r.doneChan:
+		newlog.Info("reporter stopped")
+	case <-time.After(timeout):
+		newlog.Error("reporter stop timeout after %v, some cached metrics may be lost", timeout)
+	}
+}
+
 func (r *Reporter) Report(metrics []protocol.MetricValue) {
 	r.metricsChan <- metrics
 }
@@ -73,10 +88,31 @@ func (r *Reporter) listenChanEvents() {
 			r.sendMetrics(false)
 		case <-r.ticker.C:
 			r.sendMetrics(true)
+		case <-r.stopChan:
+			r.flush()
+			close(r.doneChan)
+			return
 		}
 	}
 }
 
+func (r *Reporter) flush() {
+	r.ticker.Stop()
+	for len(r.metricsChan) > 0 {
+		r.processMetrics(<-r.metricsChan)
+	}
+
+	r.sendMetrics(true)
+	if len(r.metricList) > 0 {
+		newlog.Error("send metrics failed when stop, %d metrics are lost", len(r.metricList))
+	}
+
+	if r.conn != nil {
+		_ = r.conn.Close()
+		r.conn = nil
+	}
+}
+
 func (r *Reporter) processMetrics(metrics []protocol.MetricValue) {
 	for _, metric := range metrics {
 		if !metric.IsValid() {
@@ -19,7 +19,9 @@ type FileScanner struct {
 	fileList []string
 }
 
-func StartScriptScheduler(scriptDirs string, scriptType string, report *reporter.Reporter) {
+// StartScriptScheduler return all started schedulers, so they can be stopped when the agent exit
+func StartScriptScheduler(scriptDirs string, scriptType string, report *reporter.Reporter) []*Scheduler {
+	var schedulers []*Scheduler
 	var fileScanner = FileScanner{}
 	scripts := fileScanner.GetAllFiles(scriptDirs)
 	for _, script := range scripts {
@@ -31,7 +33,9 @@ func StartScriptScheduler(scriptDirs string, scriptType string, report *reporter
 		s := &Script{path: script, scriptType: scriptType, interval: interval}
 		scheduler := NewScheduler(s, report)
 		scheduler.Start()
+		schedulers = append(schedulers, scheduler)
 	}
+	return schedulers
 }
 
 func getScheduleInterval(scriptType string, script string) (int, error) {
@@ -70,6 +70,11 @@ func (r *CompareRule) Run() {
 		Aggregator: r.alarmDataSource.Aggregation,
 		DownSample: r.alarmDataSource.DownSample,
 		Metrics:    r.curves,
+		Function:   r.alarmDataSource.Function,
+		Fill:       r.alarmDataSource.Fill,
+
+		Smooth:       r.alarmDataSource.Smooth,
+		SmoothWindow: r.alarmDataSource.SmoothWindow,
 	}
 
 	curveDataList, err := query.Range(rangeReq)
@@ -6,6 +6,7 @@ import (
 	"github.com/sentrycloud/sentry/pkg/alarm/schedule"
 	"github.com/sentrycloud/sentry/pkg/dbmodel"
 	"github.com/sentrycloud/sentry/pkg/newlog"
+	"sync"
 	"time"
 )
 
@@ -15,7 +16,8 @@ const (
 )
 
 type Manager struct {
-	alarmRules       map[uint32]BaseRule // only schedule and init goroutine will change this, so it's safe to not use sync.Map
+	mu               sync.Mutex // only schedule, init and stop goroutine will change alarmRules
+	alarmRules       map[uint32]BaseRule
 	latestUpdateTime time.Time
 	updateRuleTimer  *timingwheel.Timer
 }
@@ -32,6 +34,9 @@ func (m *Manager) Start() error {
 		return err
 	}
 
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	m.alarmRules = make(map[uint32]BaseRule)
 	for _, rule := range rules {
 		m.updateLatestTime(rule.Updated)
@@ -43,6 +48,22 @@ func (m *Manager) Start() error {
 	return nil
 }
 
+// Stop the update rule timer and all alarm rule timers
+func (m *Manager) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.updateRuleTimer != nil {
+		m.updateRuleTimer.Stop()
+	}
+
+	for _, rule := range m.alarmRules {
+		rule.Stop()
+	}
+	newlog.Info("stop total rules=%d", len(m.alarmRules))
+	m.alarmRules = nil
+}
+
 func (m *Manager) updateLatestTime(ruleUpdateTime time.Time) {
 	if m.latestUpdateTime.Before(ruleUpdateTime) {
 		m.latestUpdateTime = ruleUpdateTime
@@ -63,6 +84,13 @@ func (m *Manager) updateRules() {
 		return
 	}
 
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.alarmRules == nil {
+		return // manager is stopped
+	}
+
 	for _, rule := range rules {
 		m.updateLatestTime(rule.Updated)
 
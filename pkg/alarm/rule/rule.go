@@ -44,6 +44,10 @@ type AlarmDataSource struct {
 	Tags           map[string]string `json:"tags"`
 	DownSample     int64             `json:"down_sample"`
 	Aggregation    string            `json:"aggregation"`
+	Function       string            `json:"function"`         // rate, irate, increase or derivative for counters
+	Fill           string            `json:"fill"`             // none, zero, previous or linear for missing intervals
+	Smooth         string            `json:"smooth"`           // moving_avg, ewma, moving_median or cumsum
+	SmoothWindow   string            `json:"smooth_window"`    // number of data points like "10", or duration like "5m"
 	Sort           string            `json:"sort"`             // use for topN rule only
 	Limit          int               `json:"limit"`            // use for topN rule only
 	CompareType    int               `json:"compare_type"`     // use for compare rule only
@@ -119,6 +123,33 @@ func (r *AlarmRule) Parse() error {
 		return err
 	}
 
+	r.alarmDataSource.Function, err = protocol.CheckFunction(r.alarmDataSource.Function)
+	if err != nil {
+		newlog.Error("no such function=%s for ruleId=%d", r.alarmDataSource.Function, r.ID)
+		return err
+	}
+
+	if err = protocol.CheckFunctionAggregator(r.alarmDataSource.Function, r.alarmDataSource.Aggregation); err != nil {
+		newlog.Error("invalid function for ruleId=%d: %v", r.ID, err)
+		return err
+	}
+
+	r.alarmDataSource.Fill, err = protocol.CheckFill(r.alarmDataSource.Fill)
+	if err == nil && r.alarmDataSource.Fill == protocol.FillNull {
+		err = errors.New("null fill is for display only")
+	}
+
+	if err != nil {
+		newlog.Error("fill=%s is not valid for ruleId=%d: %v", r.alarmDataSource.Fill, r.ID, err)
+		return err
+	}
+
+	r.alarmDataSource.Smooth, _, err = protocol.CheckSmooth(r.alarmDataSource.Smooth, r.alarmDataSource.SmoothWindow)
+	if err != nil {
+		newlog.Error("smooth=%s is not valid for ruleId=%d: %v", r.alarmDataSource.Smooth, r.ID, err)
+		return err
+	}
+
 	if r.alarmDataSource.DownSample < 1 {
 		newlog.Error("down sample is 0 for ruleId=%d", r.ID)
 		return errors.New("down sample is 0")
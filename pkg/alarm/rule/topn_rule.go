@@ -1,7 +1,7 @@
 package rule
 
 import (
-	"errors"
+	"fmt"
 	"github.com/sentrycloud/sentry/pkg/alarm/query"
 	"github.com/sentrycloud/sentry/pkg/alarm/schedule"
 	"github.com/sentrycloud/sentry/pkg/alarm/sender"
@@ -40,9 +40,9 @@ func (r *TopNRule) Parse() error {
 		}
 	}
 
-	if starCount != 1 {
-		newlog.Error("TopN alarm rule must has one and only one * tag for ruleId=%d", r.ID)
-		return errors.New("TopN alarm rule must has one and only one * tag")
+	if starCount == 0 || starCount > protocol.MaxTopNGroupKeys {
+		newlog.Error("TopN alarm rule must has 1 to %d * tags for ruleId=%d", protocol.MaxTopNGroupKeys, r.ID)
+		return fmt.Errorf("TopN alarm rule must has 1 to %d * tags", protocol.MaxTopNGroupKeys)
 	}
 
 	return nil
@@ -1,5 +1,22 @@
 package dbmodel
 
+import (
+	jsoniter "github.com/json-iterator/go"
+	"strconv"
+	"unsafe"
+)
+
+func init() {
+	// offset of line was a number of days, accept numbers from old clients
+	jsoniter.RegisterFieldDecoderFunc("dbmodel.Line", "Offset", func(ptr unsafe.Pointer, iter *jsoniter.Iterator) {
+		if iter.WhatIsNext() == jsoniter.NumberValue {
+			*(*string)(ptr) = strconv.FormatInt(iter.ReadInt64(), 10)
+			return
+		}
+		*(*string)(ptr) = iter.ReadString()
+	})
+}
+
 type Dashboard struct {
 	Entity
 	Name        string `json:"name"`
@@ -30,11 +47,12 @@ func (Chart) TableName() string {
 
 type Line struct {
 	Entity
-	ChartId uint32 `json:"chart_id"`
-	Name    string `json:"name"`
-	Metric  string `json:"metric"`
-	Tags    string `json:"tags"`
-	Offset  int    `json:"offset"`
+	ChartId    uint32 `json:"chart_id"`
+	Name       string `json:"name"`
+	Metric     string `json:"metric"`
+	Tags       string `json:"tags"`
+	Offset     string `json:"offset"`     // comma separated time shifts like "-1d,-1w", see protocol.ParseOffsets
+	Expression string `json:"expression"` // line with expression use it instead of metric and tags
 }
 
 func (Line) TableName() string {
@@ -53,6 +71,13 @@ func QueryChatLines(chartId uint32) ([]Line, error) {
 	return lines, result.Error
 }
 
+// QueryChartsLines query lines of all charts in one query, so charts of a dashboard can be queried together
+func QueryChartsLines(chartIds []uint32) ([]Line, error) {
+	var lines []Line
+	result := db.Where("is_deleted=? AND chart_id in ?", 0, chartIds).Find(&lines)
+	return lines, result.Error
+}
+
 func DeleteChartAndLines(chartId uint32) error {
 	// soft delete
 	result := db.Table("chart").Where("id=?", chartId).Update("is_deleted", 1)
@@ -1,5 +1,7 @@
 package dbmodel
 
+import "gorm.io/gorm/clause"
+
 type MetricWhiteList struct {
 	Entity
 	Metric  string `json:"metric"`
@@ -10,3 +12,60 @@ type MetricWhiteList struct {
 func (MetricWhiteList) TableName() string {
 	return "metric_white_list"
 }
+
+// MetricMeta is the metadata of a metric, set by API or by clients at ingest time
+type MetricMeta struct {
+	Entity
+	Metric      string `json:"metric"`
+	Unit        string `json:"unit"`
+	Type        string `json:"type"` // gauge, counter or histogram
+	Description string `json:"description"`
+	AppName     string `json:"app_name"`
+}
+
+func (MetricMeta) TableName() string {
+	return "metric_meta"
+}
+
+func QueryMetricMetas(metas *[]MetricMeta) error {
+	result := db.Where("is_deleted=?", 0).Find(metas)
+	return result.Error
+}
+
+// SaveMetricMeta add the metadata, or update it if the metric already has one, metric is a unique key,
+// so saves by API and at ingest time do not add duplicate rows, a deleted row is restored by the update
+func SaveMetricMeta(meta *MetricMeta) error {
+	result := db.Select(getJsonTags(meta)).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "metric"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"unit":        meta.Unit,
+			"type":        meta.Type,
+			"description": meta.Description,
+			"app_name":    meta.AppName,
+			"is_deleted":  0,
+		}),
+	}).Create(meta)
+	return result.Error
+}
+
+// MergeMetricMeta add the metadata, or update the columns that are not empty in meta if the metric already has one,
+// it's used for metadata set at ingest time, which often has some fields only
+func MergeMetricMeta(meta *MetricMeta) error {
+	updates := map[string]interface{}{"is_deleted": 0}
+	for column, value := range map[string]string{
+		"unit":        meta.Unit,
+		"type":        meta.Type,
+		"description": meta.Description,
+		"app_name":    meta.AppName,
+	} {
+		if len(value) > 0 {
+			updates[column] = value
+		}
+	}
+
+	result := db.Select(getJsonTags(meta)).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "metric"}},
+		DoUpdates: clause.Assignments(updates),
+	}).Create(meta)
+	return result.Error
+}
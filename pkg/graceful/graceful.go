@@ -0,0 +1,39 @@
+package graceful
+
+import (
+	"context"
+	"errors"
+	"github.com/sentrycloud/sentry/pkg/newlog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// ShutdownTimeout is the deadline for each step of shutdown, such as flushing buffered data points
+const ShutdownTimeout = 10 * time.Second
+
+// WaitForSignal block until SIGINT or SIGTERM is received, it's called in the end of a main function
+func WaitForSignal() {
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-quit
+	signal.Stop(quit)
+	newlog.Info("receive signal %v, start to shutdown", sig)
+}
+
+// ShutdownHttpServer stop accepting new requests and wait active requests to complete until timeout
+func ShutdownHttpServer(server *http.Server, name string) {
+	if server == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+
+	err := server.Shutdown(ctx)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		newlog.Error("shutdown %s http server failed: %v", name, err)
+	}
+}
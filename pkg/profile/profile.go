@@ -1,23 +1,22 @@
 package profile
 
 import (
+	"errors"
 	"fmt"
 	"github.com/sentrycloud/sentry/pkg/newlog"
 	"net/http"
 	_ "net/http/pprof"
-	"time"
 )
 
-// StartProfileInBlockMode
+// StartProfile start the profile http server in a goroutine, the returned server is used for shutdown
 // see profile information in http://ip:profilePort/debug/pprof/
-// this function must be called in the last part of a main function, or in a goroutine
-// cause this call will block the caller
-func StartProfileInBlockMode(profilePort int) {
-	err := http.ListenAndServe(fmt.Sprintf("0.0.0.0:%d", profilePort), nil)
-	if err != nil {
-		newlog.Error("profile listen failed: %v\n", err)
-		for {
-			time.Sleep(1 * time.Second)
+func StartProfile(profilePort int) *http.Server {
+	server := &http.Server{Addr: fmt.Sprintf("0.0.0.0:%d", profilePort)} // nil handler use DefaultServeMux registered by pprof
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			newlog.Error("profile listen failed: %v\n", err)
 		}
-	}
+	}()
+	return server
 }
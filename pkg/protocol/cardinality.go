@@ -0,0 +1,33 @@
+package protocol
+
+type TagCardinality struct {
+	Key    string `json:"key"`
+	Values int64  `json:"values"` // distinct values of the tag key
+}
+
+// MetricCardinality is the cardinality of a metric, each series is a child table of the super table
+type MetricCardinality struct {
+	Metric    string           `json:"metric"`
+	Tables    int64            `json:"tables"`
+	NewTables int64            `json:"new_tables"` // tables created in the last 24 hours
+	Tags      []TagCardinality `json:"tags"`       // sorted by values in descending order
+}
+
+// CardinalityReport is computed by the background cardinality scan, metrics are sorted by tables in descending order
+type CardinalityReport struct {
+	Updated  int64               `json:"updated"`  // unix time when the scan completed, 0 if no scan completed
+	Duration int64               `json:"duration"` // seconds the scan takes
+	Metrics  []MetricCardinality `json:"metrics"`
+}
+
+type TagKeyCardinality struct {
+	Metric string `json:"metric"`
+	Key    string `json:"key"`
+	Values int64  `json:"values"`
+}
+
+type NewSeries struct {
+	Table   string            `json:"table"`
+	Created int64             `json:"created"` // unix time in milliseconds
+	Tags    map[string]string `json:"tags"`
+}
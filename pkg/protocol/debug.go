@@ -0,0 +1,32 @@
+package protocol
+
+// QueryDebug is returned with data of range, topN and chartData requests that set debug,
+// so an empty chart can be told from wrong tags, no curves or failed queries
+type QueryDebug struct {
+	Start          int64           `json:"start"` // effective time range in milliseconds after aligned with down sample
+	End            int64           `json:"end"`
+	DownSample     int64           `json:"down_sample"`
+	Queries        []DebugQuery    `json:"queries"`
+	Curves         []DebugCurve    `json:"curves"`
+	DroppedFilters []DroppedFilter `json:"dropped_filters"` // chart filters that the metric does not have tag key of
+	Errors         []string        `json:"errors"`          // errors of chart lines, which are skipped in the result
+}
+
+type DebugQuery struct {
+	SQL     string `json:"sql"`
+	Latency int64  `json:"latency"` // milliseconds
+	Rows    int    `json:"rows"`
+	Error   string `json:"error,omitempty"`
+}
+
+type DebugCurve struct {
+	Metric string            `json:"metric"`
+	Tags   map[string]string `json:"tags"`
+	Points int               `json:"points"`
+}
+
+type DroppedFilter struct {
+	Metric string `json:"metric"`
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+}
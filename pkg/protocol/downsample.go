@@ -0,0 +1,74 @@
+package protocol
+
+import (
+	"errors"
+	jsoniter "github.com/json-iterator/go"
+	"strings"
+	"unsafe"
+)
+
+const (
+	DownSampleAuto   = -1 // down_sample: "auto", server choose down sample by time range and max points
+	DefaultMaxPoints = 1000
+	MaxPointsLimit   = 10000
+)
+
+// down sample candidates in seconds for auto down sample
+var downSampleLadder = []int64{1, 5, 10, 30, 60, 120, 300, 600, 1800, 3600, 3 * 3600, 6 * 3600, 12 * 3600, 24 * 3600}
+
+func init() {
+	// down_sample in TimeSeriesDataRequest can be a number of seconds, "auto" or a string like "1m"
+	jsoniter.RegisterFieldDecoderFunc("protocol.TimeSeriesDataRequest", "DownSample", func(ptr unsafe.Pointer, iter *jsoniter.Iterator) {
+		if iter.WhatIsNext() != jsoniter.StringValue {
+			*(*int64)(ptr) = iter.ReadInt64()
+			return
+		}
+
+		downSample, err := ParseDownSample(iter.ReadString())
+		if err != nil {
+			iter.ReportError("decode down_sample", err.Error())
+			return
+		}
+		*(*int64)(ptr) = downSample
+	})
+}
+
+func IsAutoDownSample(downSample string) bool {
+	return strings.EqualFold(strings.TrimSpace(downSample), "auto")
+}
+
+// ParseDownSample parse down sample string, "auto" returns DownSampleAuto
+func ParseDownSample(downSample string) (int64, error) {
+	if IsAutoDownSample(downSample) {
+		return DownSampleAuto, nil
+	}
+
+	result, err := TransferDownSample(downSample)
+	if err == nil && result <= 0 {
+		err = errors.New("down sample must be positive")
+	}
+	return result, err
+}
+
+// ChooseDownSample choose the smallest down sample in the ladder that the range has at most maxPoints intervals,
+// the result is not less than downSample unless it's DownSampleAuto, and it's limited to one day
+func ChooseDownSample(rangeSeconds int64, maxPoints int, downSample int64) int64 {
+	if maxPoints <= 0 {
+		maxPoints = DefaultMaxPoints
+	} else if maxPoints > MaxPointsLimit {
+		maxPoints = MaxPointsLimit
+	}
+
+	chosen := downSampleLadder[len(downSampleLadder)-1]
+	for _, candidate := range downSampleLadder {
+		if rangeSeconds/candidate <= int64(maxPoints) {
+			chosen = candidate
+			break
+		}
+	}
+
+	if downSample > chosen {
+		return downSample
+	}
+	return chosen
+}
@@ -0,0 +1,222 @@
+package protocol
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	ExportFormatJson = "json" // newline delimited json, one MetricValue per line
+	ExportFormatCsv  = "csv"  // header is metric,timestamp,value,tagKey1,tagKey2...
+
+	MaxImportLineSize = 64 * 1024
+)
+
+var (
+	csvFixedHeader       = []string{"metric", "timestamp", "value"}
+	ErrInvalidImportLine = errors.New("invalid import line")
+)
+
+// ExportRequest time range is in seconds like other query requests,
+// but the exported timestamp is in milliseconds, so no precision is lost when import back
+type ExportRequest struct {
+	Token  string            `json:"token"`
+	Start  int64             `json:"start"`
+	End    int64             `json:"end"`
+	Last   int64             `json:"last"`
+	Format string            `json:"format"` // json/csv
+	Metric string            `json:"metric"`
+	Tags   map[string]string `json:"tags"`
+}
+
+type ImportResult struct {
+	Total   int `json:"total"`
+	Invalid int `json:"invalid"`
+}
+
+func CheckExportFormat(format string) (string, error) {
+	if len(format) == 0 {
+		return ExportFormatJson, nil // default format is newline delimited json
+	}
+
+	format = strings.ToLower(format)
+	if format == ExportFormatJson || format == ExportFormatCsv {
+		return format, nil
+	}
+
+	return "", errors.New("no such export format: " + format)
+}
+
+// ExportWriter write data points of one metric in json or csv format
+type ExportWriter struct {
+	format    string
+	metric    string
+	tagKeys   []string
+	writer    *bufio.Writer
+	csvWriter *csv.Writer
+	csvRecord []string
+}
+
+func NewExportWriter(w io.Writer, format string, metric string, tagKeys []string) *ExportWriter {
+	ew := &ExportWriter{
+		format:  format,
+		metric:  metric,
+		tagKeys: tagKeys,
+		writer:  bufio.NewWriter(w),
+	}
+
+	if format == ExportFormatCsv {
+		ew.csvWriter = csv.NewWriter(ew.writer)
+		ew.csvRecord = make([]string, len(csvFixedHeader)+len(tagKeys))
+	}
+	return ew
+}
+
+func (ew *ExportWriter) WriteHeader() error {
+	if ew.format != ExportFormatCsv {
+		return nil
+	}
+
+	header := append(append([]string{}, csvFixedHeader...), ew.tagKeys...)
+	return ew.csvWriter.Write(header)
+}
+
+// Write tagValues must be in the same order as tagKeys, empty tag value means the tag is absent
+func (ew *ExportWriter) Write(timestamp int64, value float64, tagValues []string) error {
+	if ew.format == ExportFormatCsv {
+		ew.csvRecord[0] = ew.metric
+		ew.csvRecord[1] = strconv.FormatInt(timestamp, 10)
+		ew.csvRecord[2] = strconv.FormatFloat(value, 'f', -1, 64)
+		copy(ew.csvRecord[len(csvFixedHeader):], tagValues)
+		return ew.csvWriter.Write(ew.csvRecord)
+	}
+
+	tags := make(map[string]string)
+	for i, v := range tagValues {
+		if len(v) > 0 {
+			tags[ew.tagKeys[i]] = v
+		}
+	}
+
+	metricValue := MetricValue{
+		Metric:    ew.metric,
+		Tags:      tags,
+		Timestamp: uint64(timestamp),
+		Value:     value,
+	}
+	data, err := Json.Marshal(&metricValue)
+	if err != nil {
+		return err
+	}
+
+	_, err = ew.writer.Write(append(data, '\n'))
+	return err
+}
+
+func (ew *ExportWriter) Flush() error {
+	if ew.csvWriter != nil {
+		ew.csvWriter.Flush()
+		if err := ew.csvWriter.Error(); err != nil {
+			return err
+		}
+	}
+	return ew.writer.Flush()
+}
+
+// ImportReader read data points exported by ExportWriter, one by one
+type ImportReader struct {
+	format    string
+	scanner   *bufio.Scanner
+	csvReader *csv.Reader
+	tagKeys   []string
+}
+
+func NewImportReader(r io.Reader, format string) (*ImportReader, error) {
+	ir := &ImportReader{format: format}
+	if format != ExportFormatCsv {
+		ir.scanner = bufio.NewScanner(r)
+		ir.scanner.Buffer(make([]byte, 4096), MaxImportLineSize)
+		return ir, nil
+	}
+
+	ir.csvReader = csv.NewReader(r)
+	ir.csvReader.FieldsPerRecord = -1
+	header, err := ir.csvReader.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(header) < len(csvFixedHeader) {
+		return nil, errors.New("csv header is too short")
+	}
+
+	for i, column := range csvFixedHeader {
+		if strings.TrimSpace(header[i]) != column {
+			return nil, errors.New("csv header must start with metric,timestamp,value")
+		}
+	}
+
+	ir.tagKeys = header[len(csvFixedHeader):]
+	return ir, nil
+}
+
+// Read return io.EOF when there is no more data, if the error is ErrInvalidImportLine,
+// the caller can skip the current line and continue to read the next line
+func (ir *ImportReader) Read() (*MetricValue, error) {
+	if ir.format != ExportFormatCsv {
+		for ir.scanner.Scan() {
+			line := ir.scanner.Bytes()
+			if len(strings.TrimSpace(string(line))) == 0 {
+				continue // skip empty line
+			}
+
+			var metricValue MetricValue
+			err := Json.Unmarshal(line, &metricValue)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidImportLine, err)
+			}
+			return &metricValue, nil
+		}
+
+		if err := ir.scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+
+	record, err := ir.csvReader.Read()
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidImportLine, err)
+		}
+		return nil, err
+	}
+
+	if len(record) != len(csvFixedHeader)+len(ir.tagKeys) {
+		return nil, fmt.Errorf("%w: csv record length not match the header", ErrInvalidImportLine)
+	}
+
+	metricValue := &MetricValue{Metric: record[0], Tags: make(map[string]string)}
+	metricValue.Timestamp, err = strconv.ParseUint(record[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImportLine, err)
+	}
+
+	metricValue.Value, err = strconv.ParseFloat(record[2], 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImportLine, err)
+	}
+
+	for i, v := range record[len(csvFixedHeader):] {
+		if len(v) > 0 {
+			metricValue.Tags[ir.tagKeys[i]] = v
+		}
+	}
+	return metricValue, nil
+}
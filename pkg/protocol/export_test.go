@@ -0,0 +1,133 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestExportImport(t *testing.T) {
+	tagKeys := []string{"sentryIP", "device"}
+	rows := []struct {
+		ts        int64
+		value     float64
+		tagValues []string
+	}{
+		{1693884000000, 1.5, []string{"10.0.0.1", "sda"}},
+		{1693884010000, -2, []string{"10.0.0.2", ""}},
+		{1693884020000, 3.25, []string{"10.0.0.3", "disk,\"quoted\""}},
+	}
+
+	for _, format := range []string{ExportFormatJson, ExportFormatCsv} {
+		var buf bytes.Buffer
+		writer := NewExportWriter(&buf, format, "sentry_test_metric", tagKeys)
+		if err := writer.WriteHeader(); err != nil {
+			t.Fatalf("write header failed: %v", err)
+		}
+
+		for _, row := range rows {
+			if err := writer.Write(row.ts, row.value, row.tagValues); err != nil {
+				t.Fatalf("write row failed: %v", err)
+			}
+		}
+
+		if err := writer.Flush(); err != nil {
+			t.Fatalf("flush failed: %v", err)
+		}
+
+		reader, err := NewImportReader(&buf, format)
+		if err != nil {
+			t.Fatalf("create import reader failed: %v", err)
+		}
+
+		for _, row := range rows {
+			m, err := reader.Read()
+			if err != nil {
+				t.Fatalf("format=%s read failed: %v", format, err)
+			}
+
+			if m.Metric != "sentry_test_metric" || int64(m.Timestamp) != row.ts || m.Value != row.value {
+				t.Errorf("format=%s not match: %v", format, m)
+			}
+
+			for i, k := range tagKeys {
+				if m.Tags[k] != row.tagValues[i] {
+					t.Errorf("format=%s tag %s not match: %v", format, k, m.Tags)
+				}
+			}
+		}
+
+		if _, err = reader.Read(); err != io.EOF {
+			t.Errorf("format=%s expect EOF, got: %v", format, err)
+		}
+	}
+}
+
+func TestImportInvalidLine(t *testing.T) {
+	data := "metric,timestamp,value,ip\nm1,abc,1,127.0.0.1\nm1,1693884000,1,127.0.0.1\n"
+	reader, err := NewImportReader(bytes.NewBufferString(data), ExportFormatCsv)
+	if err != nil {
+		t.Fatalf("create import reader failed: %v", err)
+	}
+
+	if _, err = reader.Read(); !errors.Is(err, ErrInvalidImportLine) {
+		t.Errorf("expect invalid line error, got: %v", err)
+	}
+
+	m, err := reader.Read()
+	if err != nil || m.Tags["ip"] != "127.0.0.1" {
+		t.Errorf("read valid line after invalid line failed: %v", err)
+	}
+
+	_, err = NewImportReader(bytes.NewBufferString("ts,value\n"), ExportFormatCsv)
+	if err == nil {
+		t.Errorf("expect header error")
+	}
+}
+
+func TestSeriesExport(t *testing.T) {
+	curves := []*CurveData{
+		{Metric: "m1", Tags: map[string]string{"ip": "10.0.0.1"}, DPS: []TimeValuePoint{{10, 1}, {20, math.NaN()}, {30, 3}}},
+		{Metric: "m2", DPS: []TimeValuePoint{{20, 2.5}, {40, 4}}},
+	}
+	names := []string{"m1,ip", "m2"}
+
+	var buf bytes.Buffer
+	flushCount := 0
+	writer := NewSeriesExportWriter(&buf, ExportFormatCsv, 2, func() { flushCount++ })
+	if err := writer.Write(names, curves); err != nil {
+		t.Fatalf("write csv failed: %v", err)
+	}
+
+	expect := "timestamp,\"m1,ip\",m2\n10,1,\n20,,2.5\n30,3,\n40,,4\n"
+	if buf.String() != expect {
+		t.Errorf("csv not match: %q", buf.String())
+	}
+
+	if flushCount != 3 {
+		t.Errorf("expect flush 3 times, got %d", flushCount)
+	}
+
+	buf.Reset()
+	writer = NewSeriesExportWriter(&buf, ExportFormatJson, 0, nil)
+	if err := writer.Write(names, curves); err != nil {
+		t.Fatalf("write json failed: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("expect 5 lines, got %d", len(lines))
+	}
+
+	var point SeriesPoint
+	if err := Json.UnmarshalFromString(lines[1], &point); err != nil || point.Value != nil || point.TimeStamp != 20 || point.Tags["ip"] != "10.0.0.1" {
+		t.Errorf("NaN should be null: %s", lines[1])
+	}
+
+	if err := Json.UnmarshalFromString(lines[3], &point); err != nil || point.Value == nil || *point.Value != 2.5 || point.Name != "m2" {
+		t.Errorf("point not match: %s", lines[3])
+	}
+}
@@ -0,0 +1,93 @@
+package protocol
+
+import (
+	"errors"
+	jsoniter "github.com/json-iterator/go"
+	"math"
+	"strings"
+	"unsafe"
+)
+
+const (
+	FillNone     = "none"     // keep missing intervals missing
+	FillNull     = "null"     // missing intervals are data points with null value, for display only
+	FillZero     = "zero"     // missing intervals are 0
+	FillPrevious = "previous" // missing intervals use the previous value
+	FillLinear   = "linear"   // missing intervals use linear interpolation of the previous and next value
+)
+
+func init() {
+	// null filled data point is NaN in memory, and it's encoded as null, other values are encoded as ConfigFastest does
+	jsoniter.RegisterTypeEncoderFunc("protocol.TimeValuePoint", func(ptr unsafe.Pointer, stream *jsoniter.Stream) {
+		dp := (*TimeValuePoint)(ptr)
+		stream.WriteObjectStart()
+		stream.WriteObjectField("ts")
+		stream.WriteInt64(dp.TimeStamp)
+		stream.WriteMore()
+		stream.WriteObjectField("v")
+		if math.IsNaN(dp.Value) {
+			stream.WriteNil()
+		} else {
+			stream.WriteFloat64Lossy(dp.Value)
+		}
+		stream.WriteObjectEnd()
+	}, func(ptr unsafe.Pointer) bool {
+		return false
+	})
+}
+
+// CheckFill check fill policy, empty fill means none
+func CheckFill(fill string) (string, error) {
+	fill = strings.ToLower(fill)
+	switch fill {
+	case "":
+		return FillNone, nil
+	case FillNone, FillNull, FillZero, FillPrevious, FillLinear:
+		return fill, nil
+	}
+	return fill, errors.New("no such fill policy: " + fill)
+}
+
+// FillDataPoints align data points to downSample, and fill missing intervals in [start, end) with the fill policy,
+// timestamps of data points and downSample are in seconds, start and end are in milliseconds as the query does.
+// data points must be sorted by timestamp, previous and linear do not fill intervals before the first data point,
+// previous repeats the last data point in intervals after it, and linear does not fill intervals after the last data point
+func FillDataPoints(dps []TimeValuePoint, start int64, end int64, downSample int64, fill string) []TimeValuePoint {
+	if fill == FillNone || downSample <= 0 {
+		return dps
+	}
+
+	var result []TimeValuePoint
+	i := 0
+	for ts := start / 1000 / downSample * downSample; ts*1000 < end; ts += downSample {
+		// skip data points before the interval, each interval has one data point at most
+		for i < len(dps) && dps[i].TimeStamp/downSample*downSample < ts {
+			i++
+		}
+
+		if i < len(dps) && dps[i].TimeStamp/downSample*downSample == ts {
+			result = append(result, TimeValuePoint{TimeStamp: ts, Value: dps[i].Value})
+			continue
+		}
+
+		switch fill {
+		case FillNull:
+			result = append(result, TimeValuePoint{TimeStamp: ts, Value: math.NaN()})
+		case FillZero:
+			result = append(result, TimeValuePoint{TimeStamp: ts, Value: 0})
+		case FillPrevious:
+			if i > 0 {
+				result = append(result, TimeValuePoint{TimeStamp: ts, Value: dps[i-1].Value})
+			}
+		case FillLinear:
+			if i > 0 && i < len(dps) {
+				prev, next := dps[i-1], dps[i]
+				prevTs := prev.TimeStamp / downSample * downSample
+				nextTs := next.TimeStamp / downSample * downSample
+				v := prev.Value + (next.Value-prev.Value)*float64(ts-prevTs)/float64(nextTs-prevTs)
+				result = append(result, TimeValuePoint{TimeStamp: ts, Value: v})
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,42 @@
+package protocol
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFillDataPoints(t *testing.T) {
+	// intervals 0, 10, 20, 30, 40, 50 with data points at 12, 31, 45 (not aligned)
+	dps := []TimeValuePoint{{TimeStamp: 12, Value: 2}, {TimeStamp: 31, Value: 6}, {TimeStamp: 45, Value: 8}}
+
+	nan := math.NaN()
+	expects := map[string][]TimeValuePoint{
+		FillNull:     {{0, nan}, {10, 2}, {20, nan}, {30, 6}, {40, 8}, {50, nan}},
+		FillZero:     {{0, 0}, {10, 2}, {20, 0}, {30, 6}, {40, 8}, {50, 0}},
+		FillPrevious: {{10, 2}, {20, 2}, {30, 6}, {40, 8}, {50, 8}},
+		FillLinear:   {{10, 2}, {20, 4}, {30, 6}, {40, 8}},
+	}
+
+	for fill, expect := range expects {
+		result := FillDataPoints(dps, 1000, 60000, 10, fill)
+		if len(result) != len(expect) {
+			t.Fatalf("fill %s expect %v, got %v", fill, expect, result)
+		}
+
+		for i := range expect {
+			if result[i].TimeStamp != expect[i].TimeStamp ||
+				(result[i].Value != expect[i].Value && !(math.IsNaN(result[i].Value) && math.IsNaN(expect[i].Value))) {
+				t.Errorf("fill %s expect %v, got %v", fill, expect, result)
+			}
+		}
+	}
+
+	if result := FillDataPoints(dps, 1000, 60000, 10, FillNone); len(result) != len(dps) {
+		t.Errorf("fill none should not change data points")
+	}
+
+	data, err := Json.Marshal([]TimeValuePoint{{0, nan}, {10, 1.5}})
+	if err != nil || string(data) != `[{"ts":0,"v":null},{"ts":10,"v":1.5}]` {
+		t.Errorf("marshal null filled data points failed: %s, %v", data, err)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/sentrycloud/sentry/pkg/newlog"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
@@ -18,70 +19,136 @@ const (
 	RangeUrl     = "/server/api/range"
 	TopNUrl      = "/server/api/topn"
 	ChartDataUrl = "/server/api/chartData"
+	ExportUrl    = "/server/api/export"
+
+	TagValueSearchUrl  = "/server/api/tagValues/search" // search tag values with prefix or substring by pages
+	RangeExportUrl     = "/server/api/range/export"     // export range data as csv or json, ?format=csv
+	ChartDataExportUrl = "/server/api/chartData/export" // export chart data as csv or json, ?format=csv
+	DashboardDataUrl   = "/server/api/dashboardData"    // data of all charts in a dashboard, keyed by chart id
+	TailUrl            = "/server/api/tail"             // live tail data points of a metric as server-sent events
+
+	CardinalityUrl          = "/server/api/cardinality"           // cardinality of all metrics by the background scan
+	CardinalityTagKeysUrl   = "/server/api/cardinality/tagKeys"   // tag keys with the most distinct values
+	CardinalityNewSeriesUrl = "/server/api/cardinality/newSeries" // series of a metric created in the last hours
+
+	// OpenTSDBQueryUrl OpenTSDB compatible API, so OpenTSDB clients like Grafana datasource can query sentry directly
+	OpenTSDBQueryUrl       = "/api/query"
+	OpenTSDBSuggestUrl     = "/api/suggest"
+	OpenTSDBLookupUrl      = "/api/search/lookup"
+	OpenTSDBAggregatorsUrl = "/api/aggregators"
+	OpenTSDBFiltersUrl     = "/api/config/filters"
+
+	// PromQueryUrl Prometheus compatible API, so the Prometheus datasource of Grafana can query sentry with PromQL
+	PromQueryUrl       = "/api/v1/query"
+	PromQueryRangeUrl  = "/api/v1/query_range"
+	PromLabelsUrl      = "/api/v1/labels"
+	PromLabelValuesUrl = "/api/v1/label/" // /api/v1/label/<name>/values
 
 	// AlarmRuleUrl API for MySQL
 	AlarmRuleUrl       = "/server/api/alarmRule"
 	ContactUrl         = "/server/api/contact"
 	MetricWhiteListUrl = "/server/api/metricWhiteList"
+	MetricMetaUrl      = "/server/api/metricMeta"
 	DashboardUrl       = "/server/api/dashboard"
 	ChartUrl           = "/server/api/chart"
 	ChartListUrl       = "/server/api/chartList"
 
 	PutMetricsUrl = "/server/api/putMetrics"
+	ImportUrl     = "/server/api/import"
 )
 
 const (
-	CodeOK                 = 0
-	CodeApiNotFound        = 1
-	CodeMethodNotFound     = 2
-	CodeJsonDecodeError    = 3
-	CodeInvalidParamError  = 4
-	CodeGetConnPoolError   = 5
-	CodeExecTSDBSqlError   = 6
-	CodeSplitTagsError     = 7
-	CodeStarKeysError      = 8
-	CodeMaxQueryRangeError = 9
-	CodeAggregatorError    = 10
-	CodeDownSampleError    = 11
-	CodeMetricError        = 12
-	CodeTagCountError      = 13
-	CodeOrderError         = 14
-	CodeExecMySQLError     = 15
+	CodeOK                  = 0
+	CodeApiNotFound         = 1
+	CodeMethodNotFound      = 2
+	CodeJsonDecodeError     = 3
+	CodeInvalidParamError   = 4
+	CodeGetConnPoolError    = 5
+	CodeExecTSDBSqlError    = 6
+	CodeSplitTagsError      = 7
+	CodeStarKeysError       = 8
+	CodeMaxQueryRangeError  = 9
+	CodeAggregatorError     = 10
+	CodeDownSampleError     = 11
+	CodeMetricError         = 12
+	CodeTagCountError       = 13
+	CodeOrderError          = 14
+	CodeExecMySQLError      = 15
+	CodeExportFormatError   = 16
+	CodeImportDataError     = 17
+	CodeExpressionError     = 18
+	CodeFunctionError       = 19
+	CodeFillError           = 20
+	CodeTagMatcherError     = 21
+	CodeSqlParamError       = 22
+	CodeQueryTimeoutError   = 23
+	CodeQueryCanceledError  = 24
+	CodeTooManyQueriesError = 25
+	CodeSmoothError         = 26
+	CodeTooManyTailsError   = 27
 )
 
 var CodeMsg = map[int]string{
-	CodeOK:                 "ok",
-	CodeApiNotFound:        "api not found",
-	CodeMethodNotFound:     "http method not found",
-	CodeJsonDecodeError:    "json decode error",
-	CodeInvalidParamError:  "invalid parameter error",
-	CodeGetConnPoolError:   "get conn pool error",
-	CodeExecTSDBSqlError:   "TSDB SQL execution error",
-	CodeSplitTagsError:     "split tags error",
-	CodeStarKeysError:      "star keys error",
-	CodeMaxQueryRangeError: "max query range error",
-	CodeAggregatorError:    "aggregator error",
-	CodeDownSampleError:    "down sample error",
-	CodeMetricError:        "metric error",
-	CodeTagCountError:      "too many tag count error",
-	CodeOrderError:         "no such order for topN query",
-	CodeExecMySQLError:     "MySQL execution error",
+	CodeOK:                  "ok",
+	CodeApiNotFound:         "api not found",
+	CodeMethodNotFound:      "http method not found",
+	CodeJsonDecodeError:     "json decode error",
+	CodeInvalidParamError:   "invalid parameter error",
+	CodeGetConnPoolError:    "get conn pool error",
+	CodeExecTSDBSqlError:    "TSDB SQL execution error",
+	CodeSplitTagsError:      "split tags error",
+	CodeStarKeysError:       "star keys error",
+	CodeMaxQueryRangeError:  "max query range error",
+	CodeAggregatorError:     "aggregator error",
+	CodeDownSampleError:     "down sample error",
+	CodeMetricError:         "metric error",
+	CodeTagCountError:       "too many tag count error",
+	CodeOrderError:          "no such order for topN query",
+	CodeExecMySQLError:      "MySQL execution error",
+	CodeExportFormatError:   "no such export format",
+	CodeImportDataError:     "import data error",
+	CodeExpressionError:     "expression error",
+	CodeFunctionError:       "no such function",
+	CodeFillError:           "no such fill policy",
+	CodeTagMatcherError:     "tag matcher error",
+	CodeSqlParamError:       "invalid metric, tag key or tag value",
+	CodeQueryTimeoutError:   "query timeout",
+	CodeQueryCanceledError:  "query canceled",
+	CodeTooManyQueriesError: "too many concurrent queries, try again later",
+	CodeSmoothError:         "no such smooth function or invalid window",
+	CodeTooManyTailsError:   "too many live tail subscribers, try again later",
 }
 
+const (
+	FunctionRate       = "rate"
+	FunctionIRate      = "irate"
+	FunctionIncrease   = "increase"
+	FunctionDerivative = "derivative"
+)
+
 type MetricReq struct {
-	Metric  string              `json:"metric"`
-	Tags    map[string]string   `json:"tags"`
-	Filters map[string][]string `json:"filters"` // tag values with ||
+	Metric   string              `json:"metric"`
+	Tags     map[string]string   `json:"tags"`
+	Filters  map[string][]string `json:"filters"`  // tag values with ||
+	Matchers []TagMatcher        `json:"matchers"` // regex, contains and absent conditions besides tags
 }
 
 type TimeSeriesDataRequest struct {
-	Token      string      `json:"token"`
-	Start      int64       `json:"start"`
-	End        int64       `json:"end"`
-	Last       int64       `json:"last"`
-	Aggregator string      `json:"aggregator"`
-	DownSample int64       `json:"down_sample"`
-	Metrics    []MetricReq `json:"metrics"`
+	Token       string      `json:"token"`
+	Start       int64       `json:"start"`
+	End         int64       `json:"end"`
+	Last        int64       `json:"last"`
+	Aggregator  string      `json:"aggregator"`
+	DownSample  int64       `json:"down_sample"` // seconds, or "auto" to choose by time range and max_points
+	MaxPoints   int         `json:"max_points"`  // max data points of a curve, down sample is increased if needed
+	Metrics     []MetricReq `json:"metrics"`
+	Expressions []string    `json:"expressions"` // such as: errors{app=web} / requests{app=web} * 100
+	Function    string      `json:"function"`    // rate, irate, increase or derivative applied to all metrics
+	Fill        string      `json:"fill"`        // none, null, zero, previous or linear for missing intervals
+
+	Smooth       string `json:"smooth"`        // moving_avg, ewma, moving_median or cumsum applied to all curves
+	SmoothWindow string `json:"smooth_window"` // number of data points like "10", or duration like "5m"
+	Debug        bool   `json:"debug"`         // return sql, latency and curves of the queries with the data
 }
 
 type TimeValuePoint struct {
@@ -90,15 +157,18 @@ type TimeValuePoint struct {
 }
 
 type CurveData struct {
-	Metric string            `json:"metric"`
-	Tags   map[string]string `json:"tags"`
-	DPS    []TimeValuePoint  `json:"dps"`
+	Metric     string            `json:"metric"`
+	Tags       map[string]string `json:"tags"`
+	DPS        []TimeValuePoint  `json:"dps"`
+	DownSample int64             `json:"down_sample,omitempty"` // the actual down sample used by the query
+	Meta       *MetricMeta       `json:"meta,omitempty"`        // metadata of the metric if it's registered
 }
 
 type QueryResp struct {
-	Code int         `json:"code"`
-	Msg  string      `json:"msg"`
-	Data interface{} `json:"data"`
+	Code  int         `json:"code"`
+	Msg   string      `json:"msg"`
+	Data  interface{} `json:"data"`
+	Debug *QueryDebug `json:"debug,omitempty"`
 }
 
 type TopNRequest struct {
@@ -112,25 +182,102 @@ type TopNRequest struct {
 	Order      string              `json:"order"` // desc/asc
 	Metric     string              `json:"metric"`
 	Tags       map[string]string   `json:"tags"`
+	GroupBy    []string            `json:"group_by"` // order of group keys in the composite name, default is sorted star keys
+	Others     bool                `json:"others"`   // add the remainder beyond limit as "others", sum and count only
+	Debug      bool                `json:"debug"`    // return sql and latency of the queries with the data
 	Filters    map[string][]string // extracted from tags with "||" in the value
-	Field      string              // extracted from tags with value of "*"
+	Fields     []string            // group keys, extracted from group_by and tags with value of "*"
 }
 
+const (
+	SearchPrefix   = "prefix"
+	SearchContains = "contains"
+)
+
+// TagValueSearchRequest search values of Key in series of the metric that match tags and matchers,
+// tags can have other star keys to narrow the series like tag values request, the key itself is skipped in tags
+type TagValueSearchRequest struct {
+	MetricReq
+	Key    string `json:"key"`
+	Search string `json:"search"` // empty search returns all values by pages
+	Match  string `json:"match"`  // prefix or contains, default is prefix
+	Limit  int    `json:"limit"`  // values of a page
+	Cursor string `json:"cursor"` // next_cursor of the previous page, empty for the first page
+}
+
+type TagValueSearchResp struct {
+	Values     []string `json:"values"`
+	NextCursor string   `json:"next_cursor"` // empty if it's the last page
+}
+
+const (
+	MaxTopNGroupKeys = 4
+	TopNOthers       = "others"
+)
+
+// TopNData name is tag values of group keys joined by "-", tags have the fixed tags and the group keys
 type TopNData struct {
 	Metric string            `json:"metric"`
 	Name   string            `json:"name"`
 	Tags   map[string]string `json:"tags"`
 	Value  float64           `json:"value"`
+	Others bool              `json:"others,omitempty"` // the remainder of groups beyond limit, group keys are not in tags
 }
 
+// CheckAggregator check and normalize aggregator: sum, avg, max, min, count, first, last, stddev, spread or percentile(N)
 func CheckAggregator(aggregator string) (string, error) {
-	aggregator = strings.ToLower(aggregator)
-	if aggregator == "sum" || aggregator == "avg" || aggregator == "max" || aggregator == "min" {
+	aggregator = strings.ToLower(strings.ReplaceAll(aggregator, " ", ""))
+	switch aggregator {
+	case "sum", "avg", "max", "min", "count", "first", "last", "stddev", "spread":
 		return aggregator, nil
 	}
+
+	if strings.HasPrefix(aggregator, "percentile(") && strings.HasSuffix(aggregator, ")") {
+		p, err := strconv.ParseFloat(aggregator[len("percentile("):len(aggregator)-1], 64)
+		if err == nil && p >= 0 && p <= 100 {
+			return "percentile(" + strconv.FormatFloat(p, 'f', -1, 64) + ")", nil
+		}
+	}
 	return aggregator, errors.New("no such aggregator: " + aggregator)
 }
 
+// AggregatorFunction map a checked aggregator to TDengine function on column, result is always a double value
+func AggregatorFunction(aggregator string, column string) string {
+	if aggregator == "count" {
+		return "CAST(COUNT(" + column + ") as DOUBLE)"
+	}
+
+	if strings.HasPrefix(aggregator, "percentile(") {
+		// PERCENTILE does not support super table, so use approximate percentile instead
+		return "APERCENTILE(" + column + "," + aggregator[len("percentile("):]
+	}
+	return strings.ToUpper(aggregator) + "(" + column + ")"
+}
+
+// CheckFunction check counter function, empty function means no function
+func CheckFunction(function string) (string, error) {
+	function = strings.ToLower(function)
+	if function == "" || function == FunctionRate || function == FunctionIRate ||
+		function == FunctionIncrease || function == FunctionDerivative {
+		return function, nil
+	}
+	return function, errors.New("no such function: " + function)
+}
+
+// CheckFunctionAggregator check the aggregator of a counter function, the function is applied to each series of the metric
+// before they are aggregated by the server, so only sum, avg, max, min and count are supported
+func CheckFunctionAggregator(function string, aggregator string) error {
+	if function == "" {
+		return nil
+	}
+
+	switch aggregator {
+	case "sum", "avg", "max", "min", "count":
+		return nil
+	}
+	return errors.New("aggregator " + aggregator + " is not supported with function " + function)
+}
+
 func CheckOrder(order string) (string, error) {
 	if len(order) == 0 {
 		return "desc", nil // default order is descendent
@@ -174,6 +321,11 @@ func DecodeRequest(r *http.Request, entity interface{}) error {
 }
 
 func WriteQueryResp(w http.ResponseWriter, code int, data interface{}) {
+	WriteDebugQueryResp(w, code, data, nil)
+}
+
+// WriteDebugQueryResp write query response with debug info of the queries, debug is omitted if it's nil
+func WriteDebugQueryResp(w http.ResponseWriter, code int, data interface{}, debug *QueryDebug) {
 	httpStatus := http.StatusOK
 	msg, exist := CodeMsg[code]
 	if !exist {
@@ -183,6 +335,7 @@ func WriteQueryResp(w http.ResponseWriter, code int, data interface{}) {
 	resp.Code = code
 	resp.Msg = msg
 	resp.Data = data
+	resp.Debug = debug
 	jsonData, err := Json.Marshal(resp)
 	if err != nil {
 		newlog.Error("marsh query response failed: %v", err)
@@ -0,0 +1,64 @@
+package protocol
+
+import "testing"
+
+func TestCheckAggregator(t *testing.T) {
+	cases := map[string]string{
+		"SUM":              "SUM(_value)",
+		"last":             "LAST(_value)",
+		"stddev":           "STDDEV(_value)",
+		"count":            "CAST(COUNT(_value) as DOUBLE)",
+		"percentile(95)":   "APERCENTILE(_value,95)",
+		"Percentile(99.9)": "APERCENTILE(_value,99.9)",
+	}
+
+	for aggregator, expect := range cases {
+		checked, err := CheckAggregator(aggregator)
+		if err != nil {
+			t.Errorf("check %s failed: %v", aggregator, err)
+			continue
+		}
+
+		if f := AggregatorFunction(checked, "_value"); f != expect {
+			t.Errorf("aggregator %s expect %s, got %s", aggregator, expect, f)
+		}
+	}
+
+	for _, aggregator := range []string{"", "median", "percentile(101)", "percentile(1);drop", "sum(_value)"} {
+		if _, err := CheckAggregator(aggregator); err == nil {
+			t.Errorf("aggregator %s expect error", aggregator)
+		}
+	}
+}
+
+func TestAutoDownSample(t *testing.T) {
+	var req TimeSeriesDataRequest
+	for body, expect := range map[string]int64{`{"down_sample":10}`: 10, `{"down_sample":"auto"}`: DownSampleAuto, `{"down_sample":"1m"}`: 60} {
+		if err := Json.UnmarshalFromString(body, &req); err != nil || req.DownSample != expect {
+			t.Errorf("decode %s expect %d, got %d, %v", body, expect, req.DownSample, err)
+		}
+	}
+
+	if err := Json.UnmarshalFromString(`{"down_sample":"abc"}`, &req); err == nil {
+		t.Errorf("decode invalid down sample expect error")
+	}
+
+	cases := []struct {
+		rangeSeconds int64
+		maxPoints    int
+		downSample   int64
+		expect       int64
+	}{
+		{3600, 0, DownSampleAuto, 5},            // 720 points
+		{5 * 24 * 3600, 0, DownSampleAuto, 600}, // 720 points
+		{5 * 24 * 3600, 100, 10, 3 * 3600},
+		{3600, 1000, 60, 60}, // larger down sample is kept
+		{400 * 24 * 3600, 10, DownSampleAuto, 24 * 3600},
+	}
+
+	for _, c := range cases {
+		if result := ChooseDownSample(c.rangeSeconds, c.maxPoints, c.downSample); result != c.expect {
+			t.Errorf("choose down sample for %v, got %d", c, result)
+		}
+	}
+}
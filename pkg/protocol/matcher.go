@@ -0,0 +1,48 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	MatchEqual    = "="
+	MatchNotEqual = "!="
+	MatchRegex    = "=~" // POSIX extended regular expression, the same as TDengine MATCH
+	MatchNotRegex = "!~"
+	MatchContains = "contains"
+	MatchAbsent   = "absent" // the tag key is not set for the series, value is not used
+)
+
+// TagMatcher is a structured tag condition, all matchers and tags of a MetricReq are combined with AND
+type TagMatcher struct {
+	Key   string `json:"key"`
+	Op    string `json:"op"`
+	Value string `json:"value"`
+}
+
+// CheckMatchers check tag key, op and value of matchers, regex must be valid POSIX regular expression
+func CheckMatchers(matchers []TagMatcher) error {
+	for _, m := range matchers {
+		if len(m.Key) == 0 || strings.ContainsAny(m.Key, "`\\") {
+			return fmt.Errorf("invalid tag key: %q", m.Key)
+		}
+
+		switch m.Op {
+		case MatchEqual, MatchNotEqual, MatchContains:
+			if len(m.Value) == 0 {
+				return fmt.Errorf("empty value for tag key %s", m.Key)
+			}
+		case MatchRegex, MatchNotRegex:
+			if _, err := regexp.CompilePOSIX(m.Value); err != nil || len(m.Value) == 0 {
+				return fmt.Errorf("invalid regex for tag key %s: %q", m.Key, m.Value)
+			}
+		case MatchAbsent:
+		default:
+			return errors.New("no such matcher op: " + m.Op)
+		}
+	}
+	return nil
+}
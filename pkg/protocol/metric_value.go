@@ -1,23 +1,80 @@
 package protocol
 
 import (
+	"errors"
 	"github.com/sentrycloud/sentry/pkg/newlog"
 	"regexp"
+	"strings"
 	"time"
 )
 
 const MaxExpireTime = 3600
 
+const (
+	MetricTypeGauge     = "gauge"
+	MetricTypeCounter   = "counter"
+	MetricTypeHistogram = "histogram"
+)
+
+// MetricMeta is the metadata of a metric, like bytes/s for unit and counter for type
+type MetricMeta struct {
+	Unit        string `json:"unit,omitempty"`
+	Type        string `json:"type,omitempty"` // gauge, counter or histogram
+	Description string `json:"description,omitempty"`
+	AppName     string `json:"app_name,omitempty"`
+}
+
+// CheckMetricType check and normalize metric type, empty type is unknown
+func CheckMetricType(metricType string) (string, error) {
+	metricType = strings.ToLower(strings.TrimSpace(metricType))
+	switch metricType {
+	case "", MetricTypeGauge, MetricTypeCounter, MetricTypeHistogram:
+		return metricType, nil
+	}
+	return metricType, errors.New("no such metric type: " + metricType)
+}
+
 type MetricValue struct {
 	Metric    string            `json:"metric"`
 	Tags      map[string]string `json:"tags"`
 	Timestamp uint64            `json:"timestamp"`
 	Value     float64           `json:"value"`
+	Meta      *MetricMeta       `json:"meta,omitempty"` // optional metadata set at ingest time, it's not written to TDengine
+}
+
+// MetricInfo is a metric with its metadata
+type MetricInfo struct {
+	Metric string      `json:"metric"`
+	Meta   *MetricMeta `json:"meta,omitempty"`
 }
 
 var validNameRegExp = regexp.MustCompile("[a-zA-Z_][a-zA-Z_0-9]*")
 
 func (m *MetricValue) IsValid() bool {
+	if !m.isValidNameAndTags() {
+		return false
+	}
+
+	if time.Now().Unix()-int64(m.Timestamp) > MaxExpireTime {
+		newlog.Error("metric=%s, timestamp=%ld is too old", m.Metric, m.Timestamp)
+		return false
+	}
+
+	return true
+}
+
+// IsValidBackfill used for imported data points, they can be older than MaxExpireTime,
+// and the timestamp may be in milliseconds
+func (m *MetricValue) IsValidBackfill() bool {
+	if m.Timestamp == 0 {
+		newlog.Error("metric=%s has no timestamp", m.Metric)
+		return false
+	}
+
+	return m.isValidNameAndTags()
+}
+
+func (m *MetricValue) isValidNameAndTags() bool {
 	if len(m.Metric) == 0 {
 		newlog.Error("metric is empty")
 		return false
@@ -40,10 +97,5 @@ func (m *MetricValue) IsValid() bool {
 		}
 	}
 
-	if time.Now().Unix()-int64(m.Timestamp) > MaxExpireTime {
-		newlog.Error("metric=%s, timestamp=%ld is too old", m.Metric, m.Timestamp)
-		return false
-	}
-
 	return true
 }
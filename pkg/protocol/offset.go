@@ -0,0 +1,103 @@
+package protocol
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+const (
+	OneDaySeconds  = 24 * 3600
+	MaxLineOffsets = 10 // max offsets of a chart line
+)
+
+// ParseOffset parse a time shift like "-1w", "-1h" or "30m" to milliseconds, negative offset shift to the past.
+// units are the same as down sample with w for week, a number without unit is days, like the old day offsets
+func ParseOffset(offset string) (int64, error) {
+	offset = strings.ToLower(strings.TrimSpace(offset))
+	sign := int64(1)
+	if strings.HasPrefix(offset, "-") || strings.HasPrefix(offset, "+") {
+		if offset[0] == '-' {
+			sign = -1
+		}
+		offset = strings.TrimSpace(offset[1:])
+	}
+
+	if len(offset) == 0 {
+		return 0, errors.New("empty offset")
+	}
+
+	var seconds int64
+	var err error
+	switch unit := offset[len(offset)-1]; {
+	case unit >= '0' && unit <= '9':
+		seconds, err = strconv.ParseInt(offset, 10, 64)
+		seconds *= OneDaySeconds
+	case unit == 'w':
+		seconds, err = strconv.ParseInt(offset[:len(offset)-1], 10, 64)
+		seconds *= 7 * OneDaySeconds
+	default:
+		seconds, err = TransferDownSample(offset)
+	}
+
+	if err != nil {
+		return 0, errors.New("invalid offset: " + offset)
+	}
+
+	if seconds < 0 {
+		return 0, errors.New("sign of offset must be the first character")
+	}
+	return sign * seconds * 1000, nil
+}
+
+// ParseOffsets parse comma separated offsets of a chart line, empty offsets is no offset,
+// the offset strings are returned to label the series of each offset
+func ParseOffsets(offsets string) ([]string, []int64, error) {
+	if len(strings.TrimSpace(offsets)) == 0 {
+		return []string{"0"}, []int64{0}, nil
+	}
+
+	var names []string
+	var values []int64
+	for _, offset := range strings.Split(offsets, ",") {
+		value, err := ParseOffset(offset)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, v := range values {
+			if v == value {
+				return nil, nil, errors.New("duplicated offset: " + offset)
+			}
+		}
+
+		names = append(names, strings.TrimSpace(offset))
+		values = append(values, value)
+	}
+
+	if len(values) > MaxLineOffsets {
+		return nil, nil, errors.New("too many offsets")
+	}
+	return names, values, nil
+}
+
+// PercentChange calculate the percent change of current to previous at the same timestamp,
+// timestamps that previous is missing or zero are skipped, both data points are sorted by timestamp
+func PercentChange(current []TimeValuePoint, previous []TimeValuePoint) []TimeValuePoint {
+	var result []TimeValuePoint
+	j := 0
+	for _, dp := range current {
+		for j < len(previous) && previous[j].TimeStamp < dp.TimeStamp {
+			j++
+		}
+
+		if j < len(previous) && previous[j].TimeStamp == dp.TimeStamp && previous[j].Value != 0 {
+			prev := previous[j].Value
+			if prev < 0 {
+				prev = -prev
+			}
+			result = append(result, TimeValuePoint{TimeStamp: dp.TimeStamp, Value: (dp.Value - previous[j].Value) / prev * 100})
+		}
+	}
+	return result
+}
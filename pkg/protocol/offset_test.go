@@ -0,0 +1,38 @@
+package protocol
+
+import "testing"
+
+func TestParseOffsets(t *testing.T) {
+	names, values, err := ParseOffsets(" 0, -1w,-1h, 30m, -1 ")
+	expect := []int64{0, -7 * OneDaySeconds * 1000, -3600 * 1000, 1800 * 1000, -OneDaySeconds * 1000}
+	if err != nil || len(values) != len(expect) || names[1] != "-1w" || names[4] != "-1" {
+		t.Fatalf("parse offsets failed: %v, %v, %v", names, values, err)
+	}
+
+	for i := range expect {
+		if values[i] != expect[i] {
+			t.Errorf("offset %s expect %d, got %d", names[i], expect[i], values[i])
+		}
+	}
+
+	if _, values, err = ParseOffsets(""); err != nil || len(values) != 1 || values[0] != 0 {
+		t.Errorf("empty offsets should be no offset: %v, %v", values, err)
+	}
+
+	for _, offsets := range []string{"1x", "-", "1h,,2h", "1h,60m", "--1d", "0,1,2,3,4,5,6,7,8,9,10"} {
+		if _, _, err = ParseOffsets(offsets); err == nil {
+			t.Errorf("offsets %s should be invalid", offsets)
+		}
+	}
+}
+
+func TestPercentChange(t *testing.T) {
+	current := []TimeValuePoint{{10, 3}, {20, 1}, {30, 5}, {40, 2}}
+	previous := []TimeValuePoint{{0, 1}, {10, 2}, {30, 0}, {40, -4}}
+	expect := []TimeValuePoint{{10, 50}, {40, 150}}
+
+	result := PercentChange(current, previous)
+	if len(result) != len(expect) || result[0] != expect[0] || result[1] != expect[1] {
+		t.Errorf("percent change expect %v, got %v", expect, result)
+	}
+}
@@ -0,0 +1,418 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	OpenTSDBFilterLiteralOr    = "literal_or"
+	OpenTSDBFilterNotLiteralOr = "not_literal_or"
+	OpenTSDBFilterWildcard     = "wildcard"
+	OpenTSDBFilterRegexp       = "regexp"
+
+	OpenTSDBAggregatorNone = "none" // no aggregation, every series is returned
+)
+
+// OpenTSDBAggregators supported aggregators of OpenTSDB api, mimmin, mimmax and zimsum are the same as min, max and sum
+var OpenTSDBAggregators = []string{"avg", "count", "dev", "first", "last", "max", "mimmax", "mimmin", "min", "none",
+	"p50", "p75", "p90", "p95", "p99", "p999", "sum", "zimsum"}
+
+// OpenTSDBFilterTypes supported filter types of OpenTSDB api, case-insensitive filters are not supported by TDengine
+var OpenTSDBFilterTypes = map[string]map[string]string{
+	OpenTSDBFilterLiteralOr:    {"examples": "host=literal_or(web01|web02)", "description": "match any of the values"},
+	OpenTSDBFilterNotLiteralOr: {"examples": "host=not_literal_or(web01|web02)", "description": "match none of the values"},
+	OpenTSDBFilterWildcard:     {"examples": "host=wildcard(web*)", "description": "match the value with * as wildcard"},
+	OpenTSDBFilterRegexp:       {"examples": "host=regexp(web[0-9]+)", "description": "match the POSIX regular expression"},
+}
+
+// milliseconds of OpenTSDB duration units, n is a month of 30 days
+var openTSDBUnits = map[string]int64{"ms": 1, "s": 1000, "m": 60 * 1000, "h": 3600 * 1000, "d": 24 * 3600 * 1000,
+	"w": 7 * 24 * 3600 * 1000, "n": 30 * 24 * 3600 * 1000, "y": 365 * 24 * 3600 * 1000}
+
+var openTSDBTimeLayouts = []string{"2006/01/02-15:04:05", "2006/01/02 15:04:05", "2006/01/02-15:04", "2006/01/02 15:04", "2006/01/02"}
+
+type OpenTSDBFilter struct {
+	Type    string `json:"type"`
+	TagK    string `json:"tagk"`
+	Filter  string `json:"filter"`
+	GroupBy bool   `json:"groupBy"`
+}
+
+type OpenTSDBRateOptions struct {
+	Counter bool `json:"counter"`
+}
+
+type OpenTSDBSubQuery struct {
+	Aggregator  string               `json:"aggregator"`
+	Metric      string               `json:"metric"`
+	Rate        bool                 `json:"rate"`
+	RateOptions *OpenTSDBRateOptions `json:"rateOptions"`
+	DownSample  string               `json:"downsample"` // such as: 1m-avg, or 1m-avg-zero with fill policy
+	Tags        map[string]string    `json:"tags"`       // tags are filters with group by, like OpenTSDB 2.2
+	Filters     []OpenTSDBFilter     `json:"filters"`
+}
+
+// OpenTSDBQueryRequest start and end can be timestamp in seconds or milliseconds, relative time like 1h-ago or date
+type OpenTSDBQueryRequest struct {
+	Start        interface{}        `json:"start"`
+	End          interface{}        `json:"end"`
+	Queries      []OpenTSDBSubQuery `json:"queries"`
+	MsResolution bool               `json:"msResolution"`
+}
+
+type OpenTSDBQueryResult struct {
+	Metric        string             `json:"metric"`
+	Tags          map[string]string  `json:"tags"`
+	AggregateTags []string           `json:"aggregateTags"`
+	DPS           OpenTSDBDataPoints `json:"dps"`
+}
+
+// OpenTSDBDataPoints is encoded as a json object of timestamp to value in order, like {"1693884000":1.5}
+type OpenTSDBDataPoints struct {
+	Points       []TimeValuePoint
+	Milliseconds bool
+}
+
+type OpenTSDBSuggestRequest struct {
+	Type string `json:"type"` // metrics, tagk or tagv
+	Q    string `json:"q"`
+	Max  int    `json:"max"`
+}
+
+type OpenTSDBLookupTag struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type OpenTSDBLookupRequest struct {
+	Metric string              `json:"metric"`
+	Tags   []OpenTSDBLookupTag `json:"tags"`
+	Limit  int                 `json:"limit"`
+}
+
+type OpenTSDBLookupSeries struct {
+	Tsuid  string            `json:"tsuid"`
+	Metric string            `json:"metric"`
+	Tags   map[string]string `json:"tags"`
+}
+
+type OpenTSDBLookupResult struct {
+	Type         string                 `json:"type"`
+	Metric       string                 `json:"metric"`
+	Tags         []OpenTSDBLookupTag    `json:"tags"`
+	Limit        int                    `json:"limit"`
+	Time         int64                  `json:"time"`
+	Results      []OpenTSDBLookupSeries `json:"results"`
+	StartIndex   int                    `json:"startIndex"`
+	TotalResults int                    `json:"totalResults"`
+}
+
+type OpenTSDBError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (dps OpenTSDBDataPoints) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, dp := range dps.Points {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		ts := dp.TimeStamp
+		if dps.Milliseconds {
+			ts *= 1000
+		}
+
+		buf.WriteString(`"` + strconv.FormatInt(ts, 10) + `":`)
+		if math.IsNaN(dp.Value) || math.IsInf(dp.Value, 0) {
+			buf.WriteString("null")
+		} else {
+			buf.WriteString(strconv.FormatFloat(dp.Value, 'f', -1, 64))
+		}
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// ParseOpenTSDBDuration parse duration like 500ms, 10s, 1m, 2h, 7d, 1w, 1n or 1y, result is in milliseconds
+func ParseOpenTSDBDuration(duration string) (int64, error) {
+	i := 0
+	for i < len(duration) && duration[i] >= '0' && duration[i] <= '9' {
+		i++
+	}
+
+	unit, ok := openTSDBUnits[duration[i:]]
+	if i == 0 || !ok {
+		return 0, errors.New("invalid duration: " + duration)
+	}
+
+	n, err := strconv.ParseInt(duration[:i], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return n * unit, nil
+}
+
+// ParseOpenTSDBTime parse absolute timestamp, relative time like 1h-ago or date like 2023/09/05-12:00:00 in local time,
+// timestamp with more than 10 digits is in milliseconds, result is in seconds
+func ParseOpenTSDBTime(t interface{}, now time.Time) (int64, error) {
+	var s string
+	switch v := t.(type) {
+	case float64:
+		s = strconv.FormatInt(int64(v), 10)
+	case string:
+		s = strings.TrimSpace(v)
+	default:
+		return 0, fmt.Errorf("invalid time: %v", t)
+	}
+
+	if s == "now" {
+		return now.Unix(), nil
+	}
+
+	if ts, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if len(s) > 10 {
+			ts /= 1000
+		}
+		return ts, nil
+	}
+
+	if strings.HasSuffix(s, "-ago") {
+		ms, err := ParseOpenTSDBDuration(strings.TrimSuffix(s, "-ago"))
+		if err != nil {
+			return 0, err
+		}
+		return now.Add(-time.Duration(ms) * time.Millisecond).Unix(), nil
+	}
+
+	for _, layout := range openTSDBTimeLayouts {
+		if date, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return date.Unix(), nil
+		}
+	}
+	return 0, errors.New("invalid time: " + s)
+}
+
+// OpenTSDBAggregator map OpenTSDB aggregator to sentry aggregator, none is not mapped
+func OpenTSDBAggregator(aggregator string) (string, error) {
+	aggregator = strings.ToLower(aggregator)
+	switch aggregator {
+	case "zimsum":
+		aggregator = "sum"
+	case "mimmin":
+		aggregator = "min"
+	case "mimmax":
+		aggregator = "max"
+	case "dev":
+		aggregator = "stddev"
+	default:
+		// p50, p95, p99 or p999
+		if p, err := strconv.Atoi(strings.TrimPrefix(aggregator, "p")); err == nil && strings.HasPrefix(aggregator, "p") {
+			if len(aggregator) > 3 {
+				return CheckAggregator(fmt.Sprintf("percentile(%g)", float64(p)/10))
+			}
+			return CheckAggregator(fmt.Sprintf("percentile(%d)", p))
+		}
+	}
+	return CheckAggregator(aggregator)
+}
+
+// ParseOpenTSDBDownSample parse down sample like 1m-avg or 1m-avg-zero, return interval in seconds, aggregator and fill policy
+func ParseOpenTSDBDownSample(downSample string) (int64, string, string, error) {
+	parts := strings.Split(downSample, "-")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, "", "", errors.New("invalid down sample: " + downSample)
+	}
+
+	ms, err := ParseOpenTSDBDuration(parts[0])
+	if err != nil || ms < 1000 {
+		return 0, "", "", errors.New("invalid down sample interval: " + downSample)
+	}
+
+	aggregator, err := OpenTSDBAggregator(parts[1])
+	if err != nil {
+		return 0, "", "", err
+	}
+
+	fill := FillNone
+	if len(parts) == 3 {
+		switch parts[2] {
+		case "none":
+		case "nan", "null":
+			fill = FillNull
+		case "zero":
+			fill = FillZero
+		default:
+			return 0, "", "", errors.New("invalid fill policy: " + parts[2])
+		}
+	}
+	return ms / 1000, aggregator, fill, nil
+}
+
+// OpenTSDBTagsToFilters convert tags to filters like OpenTSDB 2.2, value can be a filter like literal_or(a|b),
+// value with * is a wildcard filter, and other value is a literal_or filter
+func OpenTSDBTagsToFilters(tags map[string]string, groupBy bool) []OpenTSDBFilter {
+	var keys []string
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var filters []OpenTSDBFilter
+	for _, k := range keys {
+		v := tags[k]
+		filter := OpenTSDBFilter{Type: OpenTSDBFilterLiteralOr, TagK: k, Filter: v, GroupBy: groupBy}
+		if i := strings.Index(v, "("); i > 0 && strings.HasSuffix(v, ")") {
+			filter.Type = strings.ToLower(v[:i])
+			filter.Filter = v[i+1 : len(v)-1]
+		} else if strings.Contains(v, "*") {
+			filter.Type = OpenTSDBFilterWildcard
+		}
+		filters = append(filters, filter)
+	}
+	return filters
+}
+
+// ParseOpenTSDBMetric parse metric with tags and filters like: metric{host=*}{dc=literal_or(a|b)},
+// tags in the first braces are grouped by, and filters in the second braces are not
+func ParseOpenTSDBMetric(s string) (string, []OpenTSDBFilter, error) {
+	i := strings.Index(s, "{")
+	if i < 0 {
+		return s, nil, nil
+	}
+
+	metric := s[:i]
+	var filters []OpenTSDBFilter
+	for braces, rest := 0, s[i:]; len(rest) > 0; braces++ {
+		end := strings.Index(rest, "}")
+		if rest[0] != '{' || end < 0 || braces >= 2 {
+			return "", nil, errors.New("invalid metric tags: " + s)
+		}
+
+		tags := make(map[string]string)
+		for _, pair := range splitOutside(rest[1:end], ',', '(', ')') {
+			if len(pair) == 0 {
+				continue
+			}
+
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 {
+				return "", nil, errors.New("invalid tag: " + pair)
+			}
+			tags[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+
+		filters = append(filters, OpenTSDBTagsToFilters(tags, braces == 0)...)
+		rest = rest[end+1:]
+	}
+	return metric, filters, nil
+}
+
+// ParseOpenTSDBSubQuery parse m parameter of GET request, like: sum:1m-avg:rate{counter}:metric{host=*}
+func ParseOpenTSDBSubQuery(m string) (*OpenTSDBSubQuery, error) {
+	parts := splitOutside(m, ':', '{', '}')
+	if len(parts) < 2 {
+		return nil, errors.New("invalid metric query: " + m)
+	}
+
+	q := &OpenTSDBSubQuery{Aggregator: parts[0]}
+	for _, part := range parts[1 : len(parts)-1] {
+		if part == "rate" || strings.HasPrefix(part, "rate{") {
+			q.Rate = true
+			q.RateOptions = &OpenTSDBRateOptions{Counter: strings.HasPrefix(part, "rate{counter")}
+		} else {
+			q.DownSample = part
+		}
+	}
+
+	var err error
+	q.Metric, q.Filters, err = ParseOpenTSDBMetric(parts[len(parts)-1])
+	return q, err
+}
+
+// OpenTSDBMetricReq convert tags and filters of a sub query to MetricReq, group by filter is a star tag,
+// single literal without group by is an equal tag, and other filters are matchers
+func OpenTSDBMetricReq(q *OpenTSDBSubQuery) (*MetricReq, error) {
+	req := &MetricReq{Metric: q.Metric, Tags: make(map[string]string)}
+	for _, f := range append(OpenTSDBTagsToFilters(q.Tags, true), q.Filters...) {
+		if len(f.TagK) == 0 {
+			return nil, errors.New("empty tag key in filter")
+		}
+
+		if _, exist := req.Tags[f.TagK]; f.GroupBy && !exist {
+			req.Tags[f.TagK] = "*"
+		}
+
+		values := strings.Split(f.Filter, "|")
+		switch f.Type {
+		case OpenTSDBFilterLiteralOr:
+			plain := !strings.Contains(f.Filter, "||") && !strings.HasSuffix(f.Filter, "*")
+			if _, exist := req.Tags[f.TagK]; len(values) == 1 && plain && !exist {
+				req.Tags[f.TagK] = f.Filter
+			} else if len(values) == 1 {
+				req.Matchers = append(req.Matchers, TagMatcher{Key: f.TagK, Op: MatchEqual, Value: f.Filter})
+			} else {
+				req.Matchers = append(req.Matchers, TagMatcher{Key: f.TagK, Op: MatchRegex, Value: literalRegex(values)})
+			}
+		case OpenTSDBFilterNotLiteralOr:
+			req.Matchers = append(req.Matchers, TagMatcher{Key: f.TagK, Op: MatchNotRegex, Value: literalRegex(values)})
+		case OpenTSDBFilterWildcard:
+			prefix := strings.TrimSuffix(f.Filter, "*")
+			if f.GroupBy && req.Tags[f.TagK] == "*" && !strings.Contains(prefix, "*") {
+				req.Tags[f.TagK] = f.Filter // prefix search of star tag
+			} else if !f.GroupBy || f.Filter != "*" {
+				req.Matchers = append(req.Matchers, TagMatcher{Key: f.TagK, Op: MatchRegex, Value: wildcardRegex(f.Filter)})
+			}
+		case OpenTSDBFilterRegexp:
+			req.Matchers = append(req.Matchers, TagMatcher{Key: f.TagK, Op: MatchRegex, Value: f.Filter})
+		default:
+			return nil, errors.New("no such filter type: " + f.Type)
+		}
+	}
+	return req, CheckMatchers(req.Matchers)
+}
+
+func literalRegex(values []string) string {
+	for i := range values {
+		values[i] = regexp.QuoteMeta(values[i])
+	}
+	return "^(" + strings.Join(values, "|") + ")$"
+}
+
+func wildcardRegex(pattern string) string {
+	parts := strings.Split(pattern, "*")
+	for i := range parts {
+		parts[i] = regexp.QuoteMeta(parts[i])
+	}
+	return "^" + strings.Join(parts, ".*") + "$"
+}
+
+// splitOutside split s by sep that is not enclosed in open and close
+func splitOutside(s string, sep byte, open byte, close byte) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case open:
+			depth++
+		case close:
+			depth--
+		case sep:
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
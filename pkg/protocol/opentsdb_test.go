@@ -0,0 +1,104 @@
+package protocol
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParseOpenTSDBTime(t *testing.T) {
+	now := time.Unix(1693890000, 0)
+	cases := map[interface{}]int64{
+		float64(1693884000):    1693884000,
+		float64(1693884000123): 1693884000,
+		"1693884000":           1693884000,
+		"1h-ago":               1693886400,
+		"2d-ago":               1693717200,
+		"now":                  1693890000,
+	}
+
+	for input, expect := range cases {
+		if ts, err := ParseOpenTSDBTime(input, now); err != nil || ts != expect {
+			t.Errorf("parse %v expect %d, got %d, %v", input, expect, ts, err)
+		}
+	}
+
+	for _, input := range []interface{}{nil, "1x-ago", "yesterday", true} {
+		if _, err := ParseOpenTSDBTime(input, now); err == nil {
+			t.Errorf("parse %v expect error", input)
+		}
+	}
+}
+
+func TestParseOpenTSDBDownSample(t *testing.T) {
+	downSample, aggregator, fill, err := ParseOpenTSDBDownSample("5m-p99-zero")
+	if err != nil || downSample != 300 || aggregator != "percentile(99)" || fill != FillZero {
+		t.Errorf("parse down sample failed: %d %s %s %v", downSample, aggregator, fill, err)
+	}
+
+	if aggregator, err = OpenTSDBAggregator("p999"); err != nil || aggregator != "percentile(99.9)" {
+		t.Errorf("p999 expect percentile(99.9), got %s %v", aggregator, err)
+	}
+
+	for _, ds := range []string{"1m", "500ms-avg", "0all-sum", "1m-median", "1m-avg-previous"} {
+		if _, _, _, err = ParseOpenTSDBDownSample(ds); err == nil {
+			t.Errorf("parse %s expect error", ds)
+		}
+	}
+}
+
+func TestOpenTSDBMetricReq(t *testing.T) {
+	q, err := ParseOpenTSDBSubQuery("sum:1m-avg:rate{counter}:sys.cpu{host=web*,dc=a|b}{app=wildcard(*api*),env=prod}")
+	if err != nil {
+		t.Fatalf("parse sub query failed: %v", err)
+	}
+
+	if q.Aggregator != "sum" || q.DownSample != "1m-avg" || !q.Rate || !q.RateOptions.Counter || q.Metric != "sys.cpu" || len(q.Filters) != 4 {
+		t.Fatalf("sub query not match: %+v", q)
+	}
+
+	req, err := OpenTSDBMetricReq(q)
+	if err != nil {
+		t.Fatalf("convert to metric req failed: %v", err)
+	}
+
+	expectTags := map[string]string{"host": "web*", "dc": "*", "env": "prod"}
+	if len(req.Tags) != len(expectTags) {
+		t.Errorf("tags not match: %v", req.Tags)
+	}
+
+	for k, v := range expectTags {
+		if req.Tags[k] != v {
+			t.Errorf("tag %s expect %s, got %s", k, v, req.Tags[k])
+		}
+	}
+
+	expectMatchers := []TagMatcher{{"dc", MatchRegex, "^(a|b)$"}, {"app", MatchRegex, "^.*api.*$"}}
+	if len(req.Matchers) != len(expectMatchers) {
+		t.Fatalf("matchers not match: %v", req.Matchers)
+	}
+
+	for i, m := range expectMatchers {
+		if req.Matchers[i] != m {
+			t.Errorf("matcher %d expect %v, got %v", i, m, req.Matchers[i])
+		}
+	}
+
+	q = &OpenTSDBSubQuery{Metric: "m", Filters: []OpenTSDBFilter{{Type: "iliteral_or", TagK: "host", Filter: "a"}}}
+	if _, err = OpenTSDBMetricReq(q); err == nil {
+		t.Errorf("case-insensitive filter expect error")
+	}
+}
+
+func TestOpenTSDBDataPoints(t *testing.T) {
+	result := OpenTSDBQueryResult{
+		Metric: "m",
+		DPS:    OpenTSDBDataPoints{Points: []TimeValuePoint{{20, 2}, {10, 1.5}, {30, math.NaN()}}, Milliseconds: true},
+	}
+
+	data, err := Json.MarshalToString(&result)
+	expect := `{"metric":"m","tags":null,"aggregateTags":null,"dps":{"20000":2,"10000":1.5,"30000":null}}`
+	if err != nil || data != expect {
+		t.Errorf("expect %s, got %s, %v", expect, data, err)
+	}
+}
@@ -0,0 +1,53 @@
+package protocol
+
+import (
+	"math"
+	"strconv"
+)
+
+const (
+	PromStatusSuccess = "success"
+	PromStatusError   = "error"
+
+	PromResultMatrix = "matrix"
+	PromResultVector = "vector"
+	PromResultScalar = "scalar"
+)
+
+// PromResponse is the response format of Prometheus HTTP API
+type PromResponse struct {
+	Status    string      `json:"status"`
+	Data      interface{} `json:"data,omitempty"`
+	ErrorType string      `json:"errorType,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+type PromQueryData struct {
+	ResultType string      `json:"resultType"`
+	Result     interface{} `json:"result"`
+}
+
+// PromSeries is a series of matrix result with Values, or a sample of vector result with Value
+type PromSeries struct {
+	Metric map[string]string `json:"metric"`
+	Values []PromSample      `json:"values,omitempty"`
+	Value  *PromSample       `json:"value,omitempty"`
+}
+
+// PromSample is encoded as [timestamp, "value"] like Prometheus, timestamp is in seconds
+type PromSample TimeValuePoint
+
+func (s PromSample) MarshalJSON() ([]byte, error) {
+	var value string
+	switch {
+	case math.IsNaN(s.Value):
+		value = "NaN"
+	case math.IsInf(s.Value, 1):
+		value = "+Inf"
+	case math.IsInf(s.Value, -1):
+		value = "-Inf"
+	default:
+		value = strconv.FormatFloat(s.Value, 'f', -1, 64)
+	}
+	return []byte("[" + strconv.FormatInt(s.TimeStamp, 10) + `,"` + value + `"]`), nil
+}
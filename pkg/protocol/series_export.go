@@ -0,0 +1,154 @@
+package protocol
+
+import (
+	"bufio"
+	"encoding/csv"
+	"io"
+	"math"
+	"strconv"
+)
+
+// SeriesPoint is a line of json series export, timestamp is in seconds like query results, null value is missing
+type SeriesPoint struct {
+	Name      string            `json:"name"`
+	Metric    string            `json:"metric"`
+	Tags      map[string]string `json:"tags"`
+	TimeStamp int64             `json:"ts"`
+	Value     *float64          `json:"v"`
+}
+
+// SeriesExportWriter write query results of range or chart data, csv has a timestamp column and one column per curve,
+// and newline delimited json has one data point per line
+type SeriesExportWriter struct {
+	format    string
+	writer    *bufio.Writer
+	csvWriter *csv.Writer
+	flushRows int
+	onFlush   func()
+	rowCount  int
+}
+
+// NewSeriesExportWriter onFlush is called after every flushRows rows are flushed, so the client can receive data in streaming mode
+func NewSeriesExportWriter(w io.Writer, format string, flushRows int, onFlush func()) *SeriesExportWriter {
+	sw := &SeriesExportWriter{
+		format:    format,
+		writer:    bufio.NewWriter(w),
+		flushRows: flushRows,
+		onFlush:   onFlush,
+	}
+
+	if format == ExportFormatCsv {
+		sw.csvWriter = csv.NewWriter(sw.writer)
+	}
+	return sw
+}
+
+// Write names are column names of curves in csv, and names of data points in json
+func (sw *SeriesExportWriter) Write(names []string, curves []*CurveData) error {
+	var err error
+	if sw.format == ExportFormatCsv {
+		err = sw.writeCsv(names, curves)
+	} else {
+		err = sw.writeJson(names, curves)
+	}
+
+	if err != nil {
+		return err
+	}
+	return sw.Flush()
+}
+
+// writeCsv merge data points of all curves by timestamp, the value is empty if a curve has no data point at the timestamp
+func (sw *SeriesExportWriter) writeCsv(names []string, curves []*CurveData) error {
+	record := append([]string{"timestamp"}, names...)
+	if err := sw.csvWriter.Write(record); err != nil {
+		return err
+	}
+
+	indexes := make([]int, len(curves))
+	for {
+		var ts int64 = math.MaxInt64
+		for i, curve := range curves {
+			if indexes[i] < len(curve.DPS) && curve.DPS[indexes[i]].TimeStamp < ts {
+				ts = curve.DPS[indexes[i]].TimeStamp
+			}
+		}
+
+		if ts == math.MaxInt64 {
+			return nil
+		}
+
+		record[0] = strconv.FormatInt(ts, 10)
+		for i, curve := range curves {
+			record[i+1] = ""
+			if indexes[i] < len(curve.DPS) && curve.DPS[indexes[i]].TimeStamp == ts {
+				if v := curve.DPS[indexes[i]].Value; !math.IsNaN(v) {
+					record[i+1] = strconv.FormatFloat(v, 'f', -1, 64)
+				}
+				indexes[i]++
+			}
+		}
+
+		if err := sw.csvWriter.Write(record); err != nil {
+			return err
+		}
+
+		if err := sw.rowWritten(); err != nil {
+			return err
+		}
+	}
+}
+
+func (sw *SeriesExportWriter) writeJson(names []string, curves []*CurveData) error {
+	for i, curve := range curves {
+		point := SeriesPoint{Name: names[i], Metric: curve.Metric, Tags: curve.Tags}
+		for _, dp := range curve.DPS {
+			point.TimeStamp = dp.TimeStamp
+			point.Value = nil
+			if !math.IsNaN(dp.Value) {
+				value := dp.Value
+				point.Value = &value
+			}
+
+			data, err := Json.Marshal(&point)
+			if err != nil {
+				return err
+			}
+
+			if _, err = sw.writer.Write(append(data, '\n')); err != nil {
+				return err
+			}
+
+			if err = sw.rowWritten(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (sw *SeriesExportWriter) rowWritten() error {
+	sw.rowCount++
+	if sw.flushRows <= 0 || sw.rowCount%sw.flushRows != 0 {
+		return nil
+	}
+	return sw.Flush()
+}
+
+func (sw *SeriesExportWriter) Flush() error {
+	if sw.csvWriter != nil {
+		sw.csvWriter.Flush()
+		if err := sw.csvWriter.Error(); err != nil {
+			return err
+		}
+	}
+
+	if err := sw.writer.Flush(); err != nil {
+		return err
+	}
+
+	if sw.onFlush != nil {
+		sw.onFlush()
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package protocol
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+const (
+	SmoothMovingAvg    = "moving_avg"
+	SmoothEWMA         = "ewma"
+	SmoothMovingMedian = "moving_median"
+	SmoothCumSum       = "cumsum"
+)
+
+// SmoothWindow is the window of a smoothing function, either a number of data points or a duration in seconds
+type SmoothWindow struct {
+	Points  int
+	Seconds int64
+}
+
+// ParseSmoothWindow parse window like "10" for 10 data points, or "5m" for the data points in the last 5 minutes
+func ParseSmoothWindow(window string) (SmoothWindow, error) {
+	window = strings.TrimSpace(window)
+	if points, err := strconv.Atoi(window); err == nil {
+		if points < 1 || points > MaxPointsLimit {
+			return SmoothWindow{}, errors.New("window points must be in [1, " + strconv.Itoa(MaxPointsLimit) + "]")
+		}
+		return SmoothWindow{Points: points}, nil
+	}
+
+	seconds, err := TransferDownSample(window)
+	if err != nil || seconds <= 0 {
+		return SmoothWindow{}, errors.New("invalid smooth window: " + window)
+	}
+	return SmoothWindow{Seconds: seconds}, nil
+}
+
+// CheckSmooth check smoothing function and its window, empty function means no smoothing, cumsum has no window
+func CheckSmooth(function string, window string) (string, SmoothWindow, error) {
+	function = strings.ToLower(strings.TrimSpace(function))
+	switch function {
+	case "", SmoothCumSum:
+		return function, SmoothWindow{}, nil
+	case SmoothMovingAvg, SmoothEWMA, SmoothMovingMedian:
+		w, err := ParseSmoothWindow(window)
+		return function, w, err
+	}
+	return function, SmoothWindow{}, errors.New("no such smooth function: " + function)
+}
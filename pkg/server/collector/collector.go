@@ -1,15 +1,19 @@
 package collector
 
 import (
+	"errors"
 	"fmt"
 	"github.com/sentrycloud/sentry/pkg/newlog"
 	"github.com/sentrycloud/sentry/pkg/protocol"
 	"github.com/sentrycloud/sentry/pkg/server/config"
 	"github.com/sentrycloud/sentry/pkg/server/merge"
+	"github.com/sentrycloud/sentry/pkg/server/metadata"
 	"github.com/sentrycloud/sentry/pkg/server/monitor"
+	"github.com/sentrycloud/sentry/pkg/server/tail"
 	"io"
 	"net"
 	"strings"
+	"sync"
 	"sync/atomic"
 )
 
@@ -26,6 +30,10 @@ type Collector struct {
 	currentConnCount int32
 	listener         net.Listener
 	merge            *merge.Merge
+
+	listenDone    chan struct{}
+	connMap       sync.Map // all active connections, closed when stop
+	connWaitGroup sync.WaitGroup
 }
 
 func (c *Collector) Start(config config.ServerConfig, merger *merge.Merge) {
@@ -40,6 +48,7 @@ func (c *Collector) Start(config config.ServerConfig, merger *merge.Merge) {
 
 	newlog.Info("listen on tcp port %d", c.port)
 	c.listener = listener
+	c.listenDone = make(chan struct{})
 	go c.listen()
 }
 
@@ -47,10 +56,32 @@ func (c *Collector) CollectMetrics() {
 	monitor.AgentCountCollector.Put(float64(c.currentConnCount))
 }
 
+// Stop accepting new connections and close all active connections,
+// wait until data points already read from connections are sent to merge
+func (c *Collector) Stop() {
+	if c.listener == nil {
+		return
+	}
+
+	_ = c.listener.Close()
+	<-c.listenDone // no more connections will be added to connMap after listen goroutine exit
+
+	c.connMap.Range(func(key, value interface{}) bool {
+		_ = key.(net.Conn).Close()
+		return true
+	})
+	c.connWaitGroup.Wait()
+	newlog.Info("tcp collector stopped")
+}
+
 func (c *Collector) listen() {
+	defer close(c.listenDone)
 	for {
 		conn, err := c.listener.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return // listener is closed by Stop
+			}
 			newlog.Error("accept conn failed: %v", err)
 			continue
 		}
@@ -62,11 +93,15 @@ func (c *Collector) listen() {
 
 		newlog.Info("connect from %v", conn.RemoteAddr())
 		atomic.AddInt32(&c.currentConnCount, 1)
+		c.connMap.Store(conn, struct{}{})
+		c.connWaitGroup.Add(1)
 		go c.handleConn(conn)
 	}
 }
 
 func (c *Collector) handleConn(conn net.Conn) {
+	defer c.connWaitGroup.Done()
+	defer c.connMap.Delete(conn)
 	defer conn.Close()
 	defer atomic.AddInt32(&c.currentConnCount, -1)
 
@@ -122,14 +157,45 @@ func (c *Collector) HandleMetrics(metrics []protocol.MetricValue, clientIP strin
 		}
 
 		c.transferMetric(&metric)
+		c.updateMeta(&metric)
 
 		filterMetrics = append(filterMetrics, metric)
 	}
 
-	if len(filterMetrics) > 0 {
-		monitor.DataPointsCollector.Put(float64(len(filterMetrics)))
+	tail.Publish(filterMetrics)
+	c.mergeMetrics(filterMetrics)
+}
 
-		payload, err := protocol.Json.Marshal(filterMetrics)
+// BackfillMetrics write history data points with its original timestamp and tags through the merge path,
+// return the count of invalid data points
+func (c *Collector) BackfillMetrics(metrics []protocol.MetricValue) int {
+	invalidCount := 0
+	var filterMetrics []protocol.MetricValue
+	for _, metric := range metrics {
+		if !metric.IsValidBackfill() {
+			invalidCount++
+			continue
+		}
+
+		if metric.Tags == nil {
+			metric.Tags = make(map[string]string)
+		}
+
+		c.transferMetric(&metric)
+		c.updateMeta(&metric)
+
+		filterMetrics = append(filterMetrics, metric)
+	}
+
+	c.mergeMetrics(filterMetrics)
+	return invalidCount
+}
+
+func (c *Collector) mergeMetrics(metrics []protocol.MetricValue) {
+	if len(metrics) > 0 {
+		monitor.DataPointsCollector.Put(float64(len(metrics)))
+
+		payload, err := protocol.Json.Marshal(metrics)
 		if err != nil {
 			newlog.Error("marshal json failed: %v", err)
 		} else {
@@ -138,6 +204,14 @@ func (c *Collector) HandleMetrics(metrics []protocol.MetricValue, clientIP strin
 	}
 }
 
+// updateMeta register metadata set by the client, and remove it from the data point, so it's not written to TDengine
+func (c *Collector) updateMeta(metric *protocol.MetricValue) {
+	if metric.Meta != nil {
+		metadata.Update(metric.Metric, *metric.Meta)
+		metric.Meta = nil
+	}
+}
+
 func (c *Collector) transferMetric(metric *protocol.MetricValue) {
 	if len(metric.Metric) > MetricLenLimit {
 		metric.Metric = metric.Metric[:MetricLenLimit]
@@ -22,11 +22,15 @@ type ServerConfig struct {
 	ProfilePort  int                 `json:"profile_port"`
 	MaxConnCount int                 `json:"max_conn_count"`
 	ScanTable    bool                `json:"scan_table"`
+	Rollup       bool                `json:"rollup"`
+	Cardinality  bool                `json:"cardinality"` // scan cardinality of all metrics in the background
 	FrontEndPath string              `json:"front_end_path"`
 	Log          newlog.LogConfig    `json:"log"`
 	TaosServer   TaosConfig          `json:"taos_server"`
 	MySQLServer  dbmodel.MySQLConfig `json:"mysql_server"`
 	Merge        MergeConfig         `json:"merge"`
+	QueryCache   QueryCacheConfig    `json:"query_cache"`
+	Query        QueryConfig         `json:"query"`
 }
 
 type MergeConfig struct {
@@ -36,12 +40,27 @@ type MergeConfig struct {
 	TickInterval     int `json:"tick_interval"`
 }
 
+type QueryCacheConfig struct {
+	Enable        bool `json:"enable"`
+	TTL           int  `json:"ttl"`            // seconds, cached intervals expire after ttl, so late data points can be seen
+	MaxMemory     int  `json:"max_memory"`     // MB, least recently used queries are evicted when exceeded
+	CompleteDelay int  `json:"complete_delay"` // seconds, intervals end before now - complete_delay are cached
+}
+
+type QueryConfig struct {
+	Timeout          int `json:"timeout"`           // seconds, a query request is canceled after timeout
+	MaxConcurrent    int `json:"max_concurrent"`    // max in-flight queries on TDengine, more queries are rejected
+	DashboardWorkers int `json:"dashboard_workers"` // charts of a dashboard data request that are queried concurrently
+}
+
 func (c *ServerConfig) setDefault() {
 	c.TcpPort = 51000
 	c.HttpPort = 51001
 	c.ProfilePort = 51002
 	c.MaxConnCount = 1000
 	c.ScanTable = false
+	c.Rollup = false
+	c.Cardinality = false
 	c.FrontEndPath = "./frontend"
 
 	c.Log.Path = "logs/sentryServer.log"
@@ -59,6 +78,15 @@ func (c *ServerConfig) setDefault() {
 	c.Merge.PayloadMaxSize = 600 * 1024 * 1024 // 600 MB
 	c.Merge.PayloadBatchSize = 600 * 1024      // 600 KB
 	c.Merge.TickInterval = 5
+
+	c.QueryCache.Enable = true
+	c.QueryCache.TTL = 300
+	c.QueryCache.MaxMemory = 256
+	c.QueryCache.CompleteDelay = 60
+
+	c.Query.Timeout = 30
+	c.Query.MaxConcurrent = 64
+	c.Query.DashboardWorkers = 8
 }
 
 func (c *ServerConfig) Parse(configPath string) {
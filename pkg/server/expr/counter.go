@@ -0,0 +1,94 @@
+package expr
+
+import (
+	"github.com/sentrycloud/sentry/pkg/protocol"
+)
+
+// Increase calculate the increase between adjacent data points of a monotonically increasing counter,
+// if a value is less than the previous one, the counter is reset, and the value itself is the increase
+func Increase(dps []protocol.TimeValuePoint) []protocol.TimeValuePoint {
+	var result []protocol.TimeValuePoint
+	for i := 1; i < len(dps); i++ {
+		delta := dps[i].Value - dps[i-1].Value
+		if delta < 0 {
+			delta = dps[i].Value
+		}
+		result = append(result, protocol.TimeValuePoint{TimeStamp: dps[i].TimeStamp, Value: delta})
+	}
+	return result
+}
+
+// Rate calculate the per second increase of a counter, counter reset is handled as Increase
+func Rate(dps []protocol.TimeValuePoint) []protocol.TimeValuePoint {
+	var result []protocol.TimeValuePoint
+	for i, dp := range Increase(dps) {
+		seconds := dps[i+1].TimeStamp - dps[i].TimeStamp
+		if seconds > 0 {
+			result = append(result, protocol.TimeValuePoint{TimeStamp: dp.TimeStamp, Value: dp.Value / float64(seconds)})
+		}
+	}
+	return result
+}
+
+// Derivative calculate the per second change of a gauge, the result can be negative
+func Derivative(dps []protocol.TimeValuePoint) []protocol.TimeValuePoint {
+	var result []protocol.TimeValuePoint
+	for i := 1; i < len(dps); i++ {
+		seconds := dps[i].TimeStamp - dps[i-1].TimeStamp
+		if seconds > 0 {
+			v := (dps[i].Value - dps[i-1].Value) / float64(seconds)
+			result = append(result, protocol.TimeValuePoint{TimeStamp: dps[i].TimeStamp, Value: v})
+		}
+	}
+	return result
+}
+
+// ApplyCounterFunction apply rate, increase or derivative to the data points of each curve, curves must be a single series,
+// irate is calculated by TDengine IRATE aggregator in query, so nothing to do here
+func ApplyCounterFunction(function string, curveDataList []*protocol.CurveData) {
+	var f func([]protocol.TimeValuePoint) []protocol.TimeValuePoint
+	switch function {
+	case protocol.FunctionRate:
+		f = Rate
+	case protocol.FunctionIncrease:
+		f = Increase
+	case protocol.FunctionDerivative:
+		f = Derivative
+	default:
+		return
+	}
+
+	for _, curveData := range curveDataList {
+		curveData.DPS = f(curveData.DPS)
+	}
+}
+
+func counterFunction(function string) *Function {
+	return &Function{
+		MinArgs: 1,
+		MaxArgs: 1,
+		Call: func(args []Value) (Value, error) {
+			series, err := SeriesArg(args, 0)
+			if err != nil {
+				return Value{}, err
+			}
+
+			var result []*protocol.CurveData
+			for _, s := range series {
+				result = append(result, &protocol.CurveData{Metric: s.Metric, Tags: s.Tags, DPS: s.DPS})
+			}
+			ApplyCounterFunction(function, result)
+			return seriesValue(result), nil
+		},
+	}
+}
+
+// irate only accept a selector as argument, the selector is queried with IRATE aggregator
+var irateFunction = &Function{
+	MinArgs:    1,
+	MaxArgs:    1,
+	Aggregator: protocol.FunctionIRate,
+	Call: func(args []Value) (Value, error) {
+		return args[0], nil
+	},
+}
@@ -0,0 +1,244 @@
+package expr
+
+import (
+	"fmt"
+	"github.com/sentrycloud/sentry/pkg/protocol"
+	"math"
+	"sort"
+	"strings"
+)
+
+const (
+	ValueScalar = iota
+	ValueString
+	ValueSeries
+)
+
+// Value is the result of evaluating a node, one of scalar, string or a list of series
+type Value struct {
+	Kind   int
+	Scalar float64
+	Str    string
+	Series []*protocol.CurveData
+}
+
+// Fetcher query the series selected by a selector, each series has its full tags,
+// so series from different selectors can be matched by tags
+type Fetcher func(selector *SelectorNode) ([]*protocol.CurveData, error)
+
+func scalarValue(v float64) Value {
+	return Value{Kind: ValueScalar, Scalar: v}
+}
+
+func seriesValue(series []*protocol.CurveData) Value {
+	return Value{Kind: ValueSeries, Series: series}
+}
+
+// Eval evaluate the expression, the result must be a list of series
+func Eval(node Node, fetch Fetcher) ([]*protocol.CurveData, error) {
+	v, err := eval(node, fetch)
+	if err != nil {
+		return nil, err
+	}
+
+	if v.Kind != ValueSeries {
+		return nil, fmt.Errorf("expression result is not a series: %s", node.String())
+	}
+	return v.Series, nil
+}
+
+func eval(node Node, fetch Fetcher) (Value, error) {
+	switch n := node.(type) {
+	case *NumberNode:
+		return scalarValue(n.Value), nil
+	case *StringNode:
+		return Value{Kind: ValueString, Str: n.Value}, nil
+	case *SelectorNode:
+		series, err := fetch(n)
+		if err != nil {
+			return Value{}, err
+		}
+		return seriesValue(series), nil
+	case *UnaryNode:
+		v, err := eval(n.Expr, fetch)
+		if err != nil {
+			return Value{}, err
+		}
+		return binaryOp("*", scalarValue(-1), v)
+	case *BinaryNode:
+		left, err := eval(n.Left, fetch)
+		if err != nil {
+			return Value{}, err
+		}
+
+		right, err := eval(n.Right, fetch)
+		if err != nil {
+			return Value{}, err
+		}
+		return binaryOp(n.Op, left, right)
+	case *CallNode:
+		var args []Value
+		for _, arg := range n.Args {
+			v, err := eval(arg, fetch)
+			if err != nil {
+				return Value{}, err
+			}
+			args = append(args, v)
+		}
+		return functions[n.Name].Call(args)
+	}
+	return Value{}, fmt.Errorf("unknown expression node: %s", node.String())
+}
+
+func calculate(op string, a float64, b float64) float64 {
+	switch op {
+	case "+":
+		return a + b
+	case "-":
+		return a - b
+	case "*":
+		return a * b
+	case "/":
+		return a / b // divide by zero get NaN or Inf, the data point will be dropped
+	}
+	return math.NaN()
+}
+
+// IsValidNumber NaN and Inf can not be marshaled to json, so they are dropped from the result
+func IsValidNumber(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+func binaryOp(op string, left Value, right Value) (Value, error) {
+	if left.Kind == ValueString || right.Kind == ValueString {
+		return Value{}, fmt.Errorf("operator %s can not be applied to string", op)
+	}
+
+	if left.Kind == ValueScalar && right.Kind == ValueScalar {
+		return scalarValue(calculate(op, left.Scalar, right.Scalar)), nil
+	}
+
+	if left.Kind == ValueScalar {
+		return seriesValue(MapSeries(right.Series, func(v float64) float64 {
+			return calculate(op, left.Scalar, v)
+		})), nil
+	}
+
+	if right.Kind == ValueScalar {
+		return seriesValue(MapSeries(left.Series, func(v float64) float64 {
+			return calculate(op, v, right.Scalar)
+		})), nil
+	}
+
+	var result []*protocol.CurveData
+	for _, pair := range matchSeries(left.Series, right.Series) {
+		result = append(result, joinSeries(op, pair[0], pair[1], pair[2]))
+	}
+	return seriesValue(result), nil
+}
+
+// MapSeries apply f to each data point value, return new series
+func MapSeries(series []*protocol.CurveData, f func(float64) float64) []*protocol.CurveData {
+	var result []*protocol.CurveData
+	for _, s := range series {
+		curve := &protocol.CurveData{Metric: s.Metric, Tags: s.Tags}
+		for _, dp := range s.DPS {
+			v := f(dp.Value)
+			if IsValidNumber(v) {
+				curve.DPS = append(curve.DPS, protocol.TimeValuePoint{TimeStamp: dp.TimeStamp, Value: v})
+			}
+		}
+		result = append(result, curve)
+	}
+	return result
+}
+
+// matchSeries match series of both side by tags, return [left, right, tagsFrom] tuples:
+// if one side has only one series, it is matched with every series of the other side,
+// otherwise series are matched when they have the same values on the tag keys that all series have
+func matchSeries(left []*protocol.CurveData, right []*protocol.CurveData) [][3]*protocol.CurveData {
+	var pairs [][3]*protocol.CurveData
+	if len(left) == 0 || len(right) == 0 {
+		return pairs
+	}
+
+	if len(right) == 1 {
+		for _, l := range left {
+			pairs = append(pairs, [3]*protocol.CurveData{l, right[0], l})
+		}
+		return pairs
+	}
+
+	if len(left) == 1 {
+		for _, r := range right {
+			pairs = append(pairs, [3]*protocol.CurveData{left[0], r, r})
+		}
+		return pairs
+	}
+
+	keys := commonTagKeys(append(append([]*protocol.CurveData{}, left...), right...))
+	rightMap := make(map[string]*protocol.CurveData)
+	for _, r := range right {
+		rightMap[TagsSignature(r.Tags, keys)] = r
+	}
+
+	for _, l := range left {
+		if r, exist := rightMap[TagsSignature(l.Tags, keys)]; exist {
+			pairs = append(pairs, [3]*protocol.CurveData{l, r, l})
+		}
+	}
+	return pairs
+}
+
+func commonTagKeys(series []*protocol.CurveData) []string {
+	var keys []string
+	for k := range series[0].Tags {
+		common := true
+		for _, s := range series[1:] {
+			if _, exist := s.Tags[k]; !exist {
+				common = false
+				break
+			}
+		}
+
+		if common {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// TagsSignature join tag values of keys in order, used as a map key to group or match series
+func TagsSignature(tags map[string]string, keys []string) string {
+	var sb strings.Builder
+	for _, k := range keys {
+		sb.WriteString(k)
+		sb.WriteByte('=')
+		sb.WriteString(tags[k])
+		sb.WriteByte(',')
+	}
+	return sb.String()
+}
+
+// joinSeries calculate data points with the same timestamp, both DPS are sorted by timestamp
+func joinSeries(op string, left *protocol.CurveData, right *protocol.CurveData, tagsFrom *protocol.CurveData) *protocol.CurveData {
+	curve := &protocol.CurveData{Metric: tagsFrom.Metric, Tags: tagsFrom.Tags}
+	i, j := 0, 0
+	for i < len(left.DPS) && j < len(right.DPS) {
+		l, r := left.DPS[i], right.DPS[j]
+		if l.TimeStamp < r.TimeStamp {
+			i++
+		} else if l.TimeStamp > r.TimeStamp {
+			j++
+		} else {
+			v := calculate(op, l.Value, r.Value)
+			if IsValidNumber(v) {
+				curve.DPS = append(curve.DPS, protocol.TimeValuePoint{TimeStamp: l.TimeStamp, Value: v})
+			}
+			i++
+			j++
+		}
+	}
+	return curve
+}
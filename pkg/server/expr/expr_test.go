@@ -0,0 +1,185 @@
+package expr
+
+import (
+	"github.com/sentrycloud/sentry/pkg/protocol"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	cases := map[string]string{
+		"a + b * 2":                          "(a + (b * 2))",
+		"(a + b) * 2":                        "((a + b) * 2)",
+		"-a / 100":                           "(-a / 100)",
+		`m{ip=10.0.0.1, api!="/a,b"}`:        `m{api!="/a,b",ip="10.0.0.1"}`,
+		`SUM(sentry_sys_io{ip=*}, "ip") - 1`: `(sum(sentry_sys_io{ip="*"}, "ip") - 1)`,
+		`m{ip=~"10.0.*", app!~web}`:          `m{app!~"web",ip=~"10.0.*"}`,
+	}
+
+	for input, expect := range cases {
+		node, err := Parse(input)
+		if err != nil {
+			t.Errorf("parse %s failed: %v", input, err)
+			continue
+		}
+
+		if node.String() != expect {
+			t.Errorf("parse %s, expect %s, got %s", input, expect, node.String())
+		}
+	}
+
+	for _, input := range []string{"", "a +", "(a", "m{ip}", "m{ip=}", "unknown(a)", "abs(a, b)", "a $ b", `m{ip=~"("}`} {
+		if _, err := Parse(input); err == nil {
+			t.Errorf("parse %s expect error", input)
+		}
+	}
+}
+
+func points(values ...float64) []protocol.TimeValuePoint {
+	var dps []protocol.TimeValuePoint
+	for i, v := range values {
+		dps = append(dps, protocol.TimeValuePoint{TimeStamp: int64(i * 10), Value: v})
+	}
+	return dps
+}
+
+func testFetcher(selector *SelectorNode) ([]*protocol.CurveData, error) {
+	switch selector.Metric {
+	case "errors":
+		return []*protocol.CurveData{
+			{Metric: "errors", Tags: map[string]string{"app": "web", "ip": "1"}, DPS: points(1, 2, 3)},
+			{Metric: "errors", Tags: map[string]string{"app": "web", "ip": "2"}, DPS: points(2, 0, 4)},
+		}, nil
+	case "requests":
+		return []*protocol.CurveData{
+			{Metric: "requests", Tags: map[string]string{"app": "web", "ip": "2"}, DPS: points(10, 0, 20)},
+			{Metric: "requests", Tags: map[string]string{"app": "web", "ip": "1"}, DPS: points(10, 20)},
+		}, nil
+	}
+	return nil, nil
+}
+
+func TestEval(t *testing.T) {
+	node, _ := Parse("errors / requests * 100")
+	series, err := Eval(node, testFetcher)
+	if err != nil || len(series) != 2 {
+		t.Fatalf("eval failed: %v", err)
+	}
+
+	// ip=1 has no data point at ts 20 in requests, ip=2 divide by zero at ts 10
+	expect := map[string][]float64{"1": {10, 10}, "2": {20, 20}}
+	for _, s := range series {
+		values := expect[s.Tags["ip"]]
+		if len(s.DPS) != len(values) {
+			t.Fatalf("ip=%s expect %v, got %v", s.Tags["ip"], values, s.DPS)
+		}
+
+		for i, dp := range s.DPS {
+			if dp.Value != values[i] {
+				t.Errorf("ip=%s expect %v, got %v", s.Tags["ip"], values, s.DPS)
+			}
+		}
+	}
+
+	node, _ = Parse(`sum(errors, "app") - min(errors)`)
+	series, err = Eval(node, testFetcher)
+	if err != nil || len(series) != 1 || series[0].Tags["app"] != "web" {
+		t.Fatalf("eval aggregate failed: %v, %v", err, series)
+	}
+
+	for i, v := range []float64{2, 2, 4} {
+		if series[0].DPS[i].Value != v {
+			t.Errorf("aggregate expect 2,2,4 got %v", series[0].DPS)
+		}
+	}
+
+	node, _ = Parse("1 + 2")
+	if _, err = Eval(node, testFetcher); err == nil {
+		t.Errorf("scalar result expect error")
+	}
+}
+
+func TestCounterFunctions(t *testing.T) {
+	dps := []protocol.TimeValuePoint{{TimeStamp: 0, Value: 100}, {TimeStamp: 10, Value: 150}, {TimeStamp: 20, Value: 30}, {TimeStamp: 30, Value: 20}}
+
+	expects := map[string][]float64{
+		"increase":   {50, 30, 20},
+		"rate":       {5, 3, 2},
+		"derivative": {5, -12, -1},
+	}
+
+	for name, expect := range expects {
+		curveDataList := []*protocol.CurveData{{DPS: dps}}
+		ApplyCounterFunction(name, curveDataList)
+		result := curveDataList[0].DPS
+		if len(result) != len(expect) {
+			t.Fatalf("%s expect %v, got %v", name, expect, result)
+		}
+
+		for i, v := range expect {
+			if result[i].Value != v || result[i].TimeStamp != dps[i+1].TimeStamp {
+				t.Errorf("%s expect %v, got %v", name, expect, result)
+			}
+		}
+	}
+
+	node, err := Parse("irate(m{ip=*}) * 8")
+	if err != nil {
+		t.Fatalf("parse irate failed: %v", err)
+	}
+
+	if Selectors(node)[0].Aggregator != protocol.FunctionIRate {
+		t.Errorf("irate selector aggregator not set")
+	}
+
+	if _, err = Parse("irate(m * 8)"); err == nil {
+		t.Errorf("irate with non selector argument expect error")
+	}
+}
+
+func TestSmoothFunctions(t *testing.T) {
+	dps := []protocol.TimeValuePoint{{TimeStamp: 0, Value: 1}, {TimeStamp: 10, Value: 3}, {TimeStamp: 20, Value: 8}, {TimeStamp: 30, Value: 4}}
+
+	cases := []struct {
+		expression string
+		expect     []float64
+	}{
+		{"moving_avg(m, 2)", []float64{1, 2, 5.5, 6}},
+		{`moving_avg(m, "20s")`, []float64{1, 2, 5.5, 6}},
+		{"moving_median(m, 3)", []float64{1, 2, 3, 4}},
+		{"ewma(m, 3)", []float64{1, 2, 5, 4.5}},
+		{"cumsum(m)", []float64{1, 4, 12, 16}},
+	}
+
+	fetch := func(selector *SelectorNode) ([]*protocol.CurveData, error) {
+		return []*protocol.CurveData{{Metric: selector.Metric, DPS: dps}}, nil
+	}
+
+	for _, c := range cases {
+		node, err := Parse(c.expression)
+		if err != nil {
+			t.Fatalf("parse %s failed: %v", c.expression, err)
+		}
+
+		series, err := Eval(node, fetch)
+		if err != nil || len(series) != 1 || len(series[0].DPS) != len(c.expect) {
+			t.Fatalf("%s expect %v, got %v, %v", c.expression, c.expect, series, err)
+		}
+
+		for i, v := range c.expect {
+			if series[0].DPS[i].Value != v || series[0].DPS[i].TimeStamp != dps[i].TimeStamp {
+				t.Errorf("%s expect %v, got %v", c.expression, c.expect, series[0].DPS)
+			}
+		}
+	}
+
+	for _, expression := range []string{"moving_avg(m, 0)", `ewma(m, "5x")`} {
+		node, _ := Parse(expression)
+		if _, err := Eval(node, fetch); err == nil {
+			t.Errorf("%s expect error", expression)
+		}
+	}
+
+	if _, err := Parse("moving_median(m)"); err == nil {
+		t.Errorf("moving_median without window expect error")
+	}
+}
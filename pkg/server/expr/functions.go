@@ -0,0 +1,300 @@
+package expr
+
+import (
+	"fmt"
+	"github.com/sentrycloud/sentry/pkg/protocol"
+	"math"
+	"sort"
+)
+
+// Function is a builtin function, MaxArgs = -1 means no limit of argument count,
+// function with Aggregator must have a selector as the first argument, and the selector is queried with that aggregator
+type Function struct {
+	MinArgs    int
+	MaxArgs    int
+	Aggregator string
+	Call       func(args []Value) (Value, error)
+}
+
+var functions = map[string]*Function{
+	"abs":       mathFunction(math.Abs),
+	"ceil":      mathFunction(math.Ceil),
+	"floor":     mathFunction(math.Floor),
+	"round":     mathFunction(math.Round),
+	"clamp_min": clampFunction(math.Max),
+	"clamp_max": clampFunction(math.Min),
+	"sum":       aggregateFunction(aggregateSum),
+	"avg":       aggregateFunction(aggregateAvg),
+	"max":       aggregateFunction(aggregateMax),
+	"min":       aggregateFunction(aggregateMin),
+	"count":     aggregateFunction(aggregateCount),
+	"topk":      topkFunction(false),
+	"bottomk":   topkFunction(true),
+
+	protocol.FunctionRate:       counterFunction(protocol.FunctionRate),
+	protocol.FunctionIncrease:   counterFunction(protocol.FunctionIncrease),
+	protocol.FunctionDerivative: counterFunction(protocol.FunctionDerivative),
+	protocol.FunctionIRate:      irateFunction,
+
+	protocol.SmoothMovingAvg:    smoothFunction(protocol.SmoothMovingAvg),
+	protocol.SmoothEWMA:         smoothFunction(protocol.SmoothEWMA),
+	protocol.SmoothMovingMedian: smoothFunction(protocol.SmoothMovingMedian),
+	protocol.SmoothCumSum:       smoothFunction(protocol.SmoothCumSum),
+}
+
+// RegisterFunction add a function to the expression language, name must be in lower case
+func RegisterFunction(name string, f *Function) {
+	functions[name] = f
+}
+
+// SeriesArg return the series of args[i], or an error if it is not a series
+func SeriesArg(args []Value, i int) ([]*protocol.CurveData, error) {
+	if args[i].Kind != ValueSeries {
+		return nil, fmt.Errorf("argument %d must be a series", i+1)
+	}
+	return args[i].Series, nil
+}
+
+// ScalarArg return the number of args[i], or an error if it is not a number
+func ScalarArg(args []Value, i int) (float64, error) {
+	if args[i].Kind != ValueScalar {
+		return 0, fmt.Errorf("argument %d must be a number", i+1)
+	}
+	return args[i].Scalar, nil
+}
+
+// StringArg return the string of args[i], or an error if it is not a string
+func StringArg(args []Value, i int) (string, error) {
+	if args[i].Kind != ValueString {
+		return "", fmt.Errorf("argument %d must be a string", i+1)
+	}
+	return args[i].Str, nil
+}
+
+func mathFunction(f func(float64) float64) *Function {
+	return &Function{
+		MinArgs: 1,
+		MaxArgs: 1,
+		Call: func(args []Value) (Value, error) {
+			if args[0].Kind == ValueScalar {
+				return scalarValue(f(args[0].Scalar)), nil
+			}
+
+			series, err := SeriesArg(args, 0)
+			if err != nil {
+				return Value{}, err
+			}
+			return seriesValue(MapSeries(series, f)), nil
+		},
+	}
+}
+
+func clampFunction(f func(float64, float64) float64) *Function {
+	return &Function{
+		MinArgs: 2,
+		MaxArgs: 2,
+		Call: func(args []Value) (Value, error) {
+			series, err := SeriesArg(args, 0)
+			if err != nil {
+				return Value{}, err
+			}
+
+			limit, err := ScalarArg(args, 1)
+			if err != nil {
+				return Value{}, err
+			}
+
+			return seriesValue(MapSeries(series, func(v float64) float64 {
+				return f(v, limit)
+			})), nil
+		},
+	}
+}
+
+// aggregateFunction aggregate series with the same timestamp, the optional string arguments are tag keys to group by:
+//
+//	sum(m{ip=*})         - aggregate all series into one series
+//	sum(m{ip=*,api=*}, "api") - one series for each api
+func aggregateFunction(aggregate func([]float64) float64) *Function {
+	return &Function{
+		MinArgs: 1,
+		MaxArgs: -1,
+		Call: func(args []Value) (Value, error) {
+			series, err := SeriesArg(args, 0)
+			if err != nil {
+				return Value{}, err
+			}
+
+			var groupKeys []string
+			for i := 1; i < len(args); i++ {
+				key, err := StringArg(args, i)
+				if err != nil {
+					return Value{}, err
+				}
+				groupKeys = append(groupKeys, key)
+			}
+			return seriesValue(aggregateSeries(series, groupKeys, aggregate)), nil
+		},
+	}
+}
+
+// topkFunction select k series with the largest or smallest values at each timestamp, like topk of PromQL,
+// a series only has data points at the timestamps it is selected: topk(m{ip=*}, 5)
+func topkFunction(bottom bool) *Function {
+	return &Function{
+		MinArgs: 2,
+		MaxArgs: 2,
+		Call: func(args []Value) (Value, error) {
+			series, err := SeriesArg(args, 0)
+			if err != nil {
+				return Value{}, err
+			}
+
+			k, err := ScalarArg(args, 1)
+			if err != nil {
+				return Value{}, err
+			}
+			return seriesValue(selectSeries(series, int(k), bottom)), nil
+		},
+	}
+}
+
+func selectSeries(series []*protocol.CurveData, k int, bottom bool) []*protocol.CurveData {
+	type point struct {
+		index int
+		value float64
+	}
+
+	points := make(map[int64][]point)
+	for i, s := range series {
+		for _, dp := range s.DPS {
+			points[dp.TimeStamp] = append(points[dp.TimeStamp], point{index: i, value: dp.Value})
+		}
+	}
+
+	selected := make([][]protocol.TimeValuePoint, len(series))
+	for ts, candidates := range points {
+		sort.Slice(candidates, func(i, j int) bool {
+			if bottom {
+				return candidates[i].value < candidates[j].value
+			}
+			return candidates[i].value > candidates[j].value
+		})
+
+		for i := 0; i < k && i < len(candidates); i++ {
+			c := candidates[i]
+			selected[c.index] = append(selected[c.index], protocol.TimeValuePoint{TimeStamp: ts, Value: c.value})
+		}
+	}
+
+	var result []*protocol.CurveData
+	for i, dps := range selected {
+		if len(dps) == 0 {
+			continue
+		}
+
+		sort.Slice(dps, func(a, b int) bool { return dps[a].TimeStamp < dps[b].TimeStamp })
+		result = append(result, &protocol.CurveData{Metric: series[i].Metric, Tags: series[i].Tags, DPS: dps})
+	}
+	return result
+}
+
+func aggregateSeries(series []*protocol.CurveData, groupKeys []string, aggregate func([]float64) float64) []*protocol.CurveData {
+	var signatures []string
+	groups := make(map[string][]*protocol.CurveData)
+	for _, s := range series {
+		signature := TagsSignature(s.Tags, groupKeys)
+		if _, exist := groups[signature]; !exist {
+			signatures = append(signatures, signature)
+		}
+		groups[signature] = append(groups[signature], s)
+	}
+
+	var result []*protocol.CurveData
+	for _, signature := range signatures {
+		group := groups[signature]
+		tags := make(map[string]string)
+		for _, k := range groupKeys {
+			if v, exist := group[0].Tags[k]; exist {
+				tags[k] = v
+			}
+		}
+
+		var timestamps []int64
+		values := make(map[int64][]float64)
+		for _, s := range group {
+			for _, dp := range s.DPS {
+				if _, exist := values[dp.TimeStamp]; !exist {
+					timestamps = append(timestamps, dp.TimeStamp)
+				}
+				values[dp.TimeStamp] = append(values[dp.TimeStamp], dp.Value)
+			}
+		}
+		sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+
+		curve := &protocol.CurveData{Metric: group[0].Metric, Tags: tags}
+		for _, ts := range timestamps {
+			v := aggregate(values[ts])
+			if IsValidNumber(v) {
+				curve.DPS = append(curve.DPS, protocol.TimeValuePoint{TimeStamp: ts, Value: v})
+			}
+		}
+		result = append(result, curve)
+	}
+	return result
+}
+
+var seriesAggregators = map[string]func([]float64) float64{
+	"sum":   aggregateSum,
+	"avg":   aggregateAvg,
+	"max":   aggregateMax,
+	"min":   aggregateMin,
+	"count": aggregateCount,
+}
+
+// AggregateSeries aggregate data points of all series with the same timestamp into data points of one series,
+// aggregator is sum, avg, max, min or count
+func AggregateSeries(aggregator string, series []*protocol.CurveData) ([]protocol.TimeValuePoint, error) {
+	aggregate, ok := seriesAggregators[aggregator]
+	if !ok {
+		return nil, fmt.Errorf("aggregator %s is not supported to aggregate series", aggregator)
+	}
+
+	var dps []protocol.TimeValuePoint
+	for _, curve := range aggregateSeries(series, nil, aggregate) {
+		dps = append(dps, curve.DPS...)
+	}
+	return dps, nil
+}
+
+func aggregateSum(values []float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum
+}
+
+func aggregateAvg(values []float64) float64 {
+	return aggregateSum(values) / float64(len(values))
+}
+
+func aggregateMax(values []float64) float64 {
+	result := values[0]
+	for _, v := range values[1:] {
+		result = math.Max(result, v)
+	}
+	return result
+}
+
+func aggregateMin(values []float64) float64 {
+	result := values[0]
+	for _, v := range values[1:] {
+		result = math.Min(result, v)
+	}
+	return result
+}
+
+func aggregateCount(values []float64) float64 {
+	return float64(len(values))
+}
@@ -0,0 +1,215 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	tokenEOF = iota
+	tokenNumber
+	tokenIdent
+	tokenString
+	tokenOperator // + - * /
+	tokenLeftParen
+	tokenRightParen
+	tokenLeftBrace
+	tokenRightBrace
+	tokenComma
+	tokenEqual
+	tokenNotEqual
+	tokenRegex
+	tokenNotRegex
+)
+
+type token struct {
+	kind  int
+	text  string
+	value float64
+	pos   int
+}
+
+type lexer struct {
+	input  string
+	pos    int
+	tokens []token
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9') || c == '.'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func tokenize(input string) ([]token, error) {
+	l := &lexer{input: input}
+	for {
+		l.skipSpace()
+		if l.pos >= len(l.input) {
+			l.tokens = append(l.tokens, token{kind: tokenEOF, pos: l.pos})
+			return l.tokens, nil
+		}
+
+		c := l.input[l.pos]
+		start := l.pos
+		switch {
+		case isDigit(c) || (c == '.' && l.pos+1 < len(l.input) && isDigit(l.input[l.pos+1])):
+			if err := l.readNumber(); err != nil {
+				return nil, err
+			}
+		case isIdentStart(c):
+			for l.pos < len(l.input) && isIdentChar(l.input[l.pos]) {
+				l.pos++
+			}
+			l.tokens = append(l.tokens, token{kind: tokenIdent, text: l.input[start:l.pos], pos: start})
+		case c == '"' || c == '\'':
+			if err := l.readString(c); err != nil {
+				return nil, err
+			}
+		case c == '+' || c == '-' || c == '*' || c == '/':
+			l.pos++
+			l.tokens = append(l.tokens, token{kind: tokenOperator, text: string(c), pos: start})
+		case c == '(':
+			l.pos++
+			l.tokens = append(l.tokens, token{kind: tokenLeftParen, text: "(", pos: start})
+		case c == ')':
+			l.pos++
+			l.tokens = append(l.tokens, token{kind: tokenRightParen, text: ")", pos: start})
+		case c == '{':
+			l.pos++
+			l.tokens = append(l.tokens, token{kind: tokenLeftBrace, text: "{", pos: start})
+			if err := l.readMatchers(); err != nil {
+				return nil, err
+			}
+		case c == ',':
+			l.pos++
+			l.tokens = append(l.tokens, token{kind: tokenComma, text: ",", pos: start})
+		default:
+			return nil, fmt.Errorf("unexpected character '%c' at position %d", c, start)
+		}
+	}
+}
+
+func (l *lexer) skipSpace() {
+	for l.pos < len(l.input) && strings.ContainsRune(" \t\r\n", rune(l.input[l.pos])) {
+		l.pos++
+	}
+}
+
+func (l *lexer) readNumber() error {
+	start := l.pos
+	for l.pos < len(l.input) && (isDigit(l.input[l.pos]) || l.input[l.pos] == '.') {
+		l.pos++
+	}
+
+	// exponent part, such as 1e6
+	if l.pos < len(l.input) && (l.input[l.pos] == 'e' || l.input[l.pos] == 'E') {
+		l.pos++
+		if l.pos < len(l.input) && (l.input[l.pos] == '+' || l.input[l.pos] == '-') {
+			l.pos++
+		}
+		for l.pos < len(l.input) && isDigit(l.input[l.pos]) {
+			l.pos++
+		}
+	}
+
+	value, err := strconv.ParseFloat(l.input[start:l.pos], 64)
+	if err != nil {
+		return fmt.Errorf("invalid number '%s' at position %d", l.input[start:l.pos], start)
+	}
+
+	l.tokens = append(l.tokens, token{kind: tokenNumber, text: l.input[start:l.pos], value: value, pos: start})
+	return nil
+}
+
+func (l *lexer) readString(quote byte) error {
+	start := l.pos
+	l.pos++ // skip the open quote
+	var sb strings.Builder
+	for l.pos < len(l.input) {
+		c := l.input[l.pos]
+		if c == '\\' && l.pos+1 < len(l.input) {
+			sb.WriteByte(l.input[l.pos+1])
+			l.pos += 2
+			continue
+		}
+
+		if c == quote {
+			l.pos++
+			l.tokens = append(l.tokens, token{kind: tokenString, text: sb.String(), pos: start})
+			return nil
+		}
+
+		sb.WriteByte(c)
+		l.pos++
+	}
+	return fmt.Errorf("unterminated string at position %d", start)
+}
+
+// tag matchers are in the format of {key1=value1, key2!="value2", key3=~"regex", key4!~"regex"}, unquoted value
+// ends with , or } so tag values like 10.0.0.1 or prefix* do not need to be quoted
+func (l *lexer) readMatchers() error {
+	for {
+		l.skipSpace()
+		if l.pos >= len(l.input) {
+			return fmt.Errorf("unterminated tag matchers")
+		}
+
+		c := l.input[l.pos]
+		start := l.pos
+		switch {
+		case c == '}':
+			l.pos++
+			l.tokens = append(l.tokens, token{kind: tokenRightBrace, text: "}", pos: start})
+			return nil
+		case c == ',':
+			l.pos++
+			l.tokens = append(l.tokens, token{kind: tokenComma, text: ",", pos: start})
+		case isIdentStart(c):
+			for l.pos < len(l.input) && isIdentChar(l.input[l.pos]) {
+				l.pos++
+			}
+			l.tokens = append(l.tokens, token{kind: tokenIdent, text: l.input[start:l.pos], pos: start})
+
+			l.skipSpace()
+			if strings.HasPrefix(l.input[l.pos:], "=~") {
+				l.tokens = append(l.tokens, token{kind: tokenRegex, text: "=~", pos: l.pos})
+				l.pos += 2
+			} else if strings.HasPrefix(l.input[l.pos:], "!~") {
+				l.tokens = append(l.tokens, token{kind: tokenNotRegex, text: "!~", pos: l.pos})
+				l.pos += 2
+			} else if strings.HasPrefix(l.input[l.pos:], "!=") {
+				l.tokens = append(l.tokens, token{kind: tokenNotEqual, text: "!=", pos: l.pos})
+				l.pos += 2
+			} else if strings.HasPrefix(l.input[l.pos:], "=") {
+				l.tokens = append(l.tokens, token{kind: tokenEqual, text: "=", pos: l.pos})
+				l.pos++
+			} else {
+				return fmt.Errorf("expect =, !=, =~ or !~ after tag key at position %d", l.pos)
+			}
+
+			l.skipSpace()
+			if l.pos < len(l.input) && (l.input[l.pos] == '"' || l.input[l.pos] == '\'') {
+				if err := l.readString(l.input[l.pos]); err != nil {
+					return err
+				}
+			} else {
+				valueStart := l.pos
+				for l.pos < len(l.input) && l.input[l.pos] != ',' && l.input[l.pos] != '}' {
+					l.pos++
+				}
+				value := strings.TrimSpace(l.input[valueStart:l.pos])
+				l.tokens = append(l.tokens, token{kind: tokenString, text: value, pos: valueStart})
+			}
+		default:
+			return fmt.Errorf("unexpected character '%c' in tag matchers at position %d", c, start)
+		}
+	}
+}
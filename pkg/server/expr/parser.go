@@ -0,0 +1,380 @@
+package expr
+
+import (
+	"fmt"
+	"github.com/sentrycloud/sentry/pkg/protocol"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const MaxExpressionLength = 1024
+
+// Node is an element of the expression syntax tree
+type Node interface {
+	String() string
+}
+
+type NumberNode struct {
+	Value float64
+}
+
+type StringNode struct {
+	Value string
+}
+
+// SelectorNode select series of a metric, tags use the same conventions as MetricReq:
+// value with * suffix for star tags, and key with != prefix for not equal condition, regex conditions are in Matchers,
+// Aggregator is set when the selector is the argument of a function like irate, empty means the request aggregator
+type SelectorNode struct {
+	Metric     string
+	Tags       map[string]string
+	Matchers   []protocol.TagMatcher
+	Aggregator string
+}
+
+type BinaryNode struct {
+	Op    string
+	Left  Node
+	Right Node
+}
+
+type UnaryNode struct {
+	Op   string
+	Expr Node
+}
+
+type CallNode struct {
+	Name string
+	Args []Node
+}
+
+func (n *NumberNode) String() string {
+	return strconv.FormatFloat(n.Value, 'f', -1, 64)
+}
+
+func (n *StringNode) String() string {
+	return strconv.Quote(n.Value)
+}
+
+func (n *SelectorNode) String() string {
+	if len(n.Tags) == 0 && len(n.Matchers) == 0 {
+		return n.Metric
+	}
+
+	var matchers []string
+	for k, v := range n.Tags {
+		if strings.HasPrefix(k, "!=") {
+			matchers = append(matchers, k[2:]+"!="+strconv.Quote(v))
+		} else {
+			matchers = append(matchers, k+"="+strconv.Quote(v))
+		}
+	}
+	for _, m := range n.Matchers {
+		matchers = append(matchers, m.Key+m.Op+strconv.Quote(m.Value))
+	}
+	sort.Strings(matchers)
+	return n.Metric + "{" + strings.Join(matchers, ",") + "}"
+}
+
+func (n *BinaryNode) String() string {
+	return "(" + n.Left.String() + " " + n.Op + " " + n.Right.String() + ")"
+}
+
+func (n *UnaryNode) String() string {
+	return n.Op + n.Expr.String()
+}
+
+func (n *CallNode) String() string {
+	var args []string
+	for _, arg := range n.Args {
+		args = append(args, arg.String())
+	}
+	return n.Name + "(" + strings.Join(args, ", ") + ")"
+}
+
+// Selectors return all metric selectors in the expression
+func Selectors(node Node) []*SelectorNode {
+	var selectors []*SelectorNode
+	switch n := node.(type) {
+	case *SelectorNode:
+		selectors = append(selectors, n)
+	case *BinaryNode:
+		selectors = append(selectors, Selectors(n.Left)...)
+		selectors = append(selectors, Selectors(n.Right)...)
+	case *UnaryNode:
+		selectors = append(selectors, Selectors(n.Expr)...)
+	case *CallNode:
+		for _, arg := range n.Args {
+			selectors = append(selectors, Selectors(arg)...)
+		}
+	}
+	return selectors
+}
+
+// MetricReq transfer the selector to a MetricReq, tags are copied, so the caller can modify it
+func (n *SelectorNode) MetricReq() *protocol.MetricReq {
+	tags := make(map[string]string)
+	for k, v := range n.Tags {
+		tags[k] = v
+	}
+	return &protocol.MetricReq{Metric: n.Metric, Tags: tags, Matchers: n.Matchers}
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// Parse an expression, examples:
+//
+//	errors{app=web} / requests{app=web} * 100
+//	sum(sentry_sys_io_read{sentryIP=*}) + sum(sentry_sys_io_write{sentryIP=*})
+//	sum(sentry_server_http_qps{api=*}, "sentryIP")
+func Parse(input string) (Node, error) {
+	if len(strings.TrimSpace(input)) == 0 {
+		return nil, fmt.Errorf("empty expression")
+	}
+
+	if len(input) > MaxExpressionLength {
+		return nil, fmt.Errorf("expression is too long")
+	}
+
+	tokens, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	node, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek().kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected '%s' at position %d", p.peek().text, p.peek().pos)
+	}
+
+	err = Check(node)
+	if err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) expect(kind int, text string) (token, error) {
+	t := p.next()
+	if t.kind != kind {
+		if t.kind == tokenEOF {
+			return t, fmt.Errorf("expect '%s' but reach the end", text)
+		}
+		return t, fmt.Errorf("expect '%s' but got '%s' at position %d", text, t.text, t.pos)
+	}
+	return t, nil
+}
+
+// expr := term (('+'|'-') term)*
+func (p *parser) parseExpr() (Node, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().kind == tokenOperator && (p.peek().text == "+" || p.peek().text == "-") {
+		op := p.next().text
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryNode{Op: op, Left: left, Right: right}
+	}
+	return left, nil
+}
+
+// term := unary (('*'|'/') unary)*
+func (p *parser) parseTerm() (Node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().kind == tokenOperator && (p.peek().text == "*" || p.peek().text == "/") {
+		op := p.next().text
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryNode{Op: op, Left: left, Right: right}
+	}
+	return left, nil
+}
+
+// unary := '-' unary | primary
+func (p *parser) parseUnary() (Node, error) {
+	if p.peek().kind == tokenOperator && (p.peek().text == "-" || p.peek().text == "+") {
+		op := p.next().text
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		if op == "+" {
+			return node, nil
+		}
+
+		if number, ok := node.(*NumberNode); ok {
+			return &NumberNode{Value: -number.Value}, nil
+		}
+		return &UnaryNode{Op: op, Expr: node}, nil
+	}
+	return p.parsePrimary()
+}
+
+// primary := NUMBER | STRING | '(' expr ')' | IDENT '(' args ')' | IDENT ['{' matchers '}']
+func (p *parser) parsePrimary() (Node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenNumber:
+		return &NumberNode{Value: t.value}, nil
+	case tokenString:
+		return &StringNode{Value: t.text}, nil
+	case tokenLeftParen:
+		node, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		_, err = p.expect(tokenRightParen, ")")
+		return node, err
+	case tokenIdent:
+		if p.peek().kind == tokenLeftParen {
+			p.next()
+			return p.parseCall(t.text)
+		}
+		return p.parseSelector(t.text)
+	case tokenEOF:
+		return nil, fmt.Errorf("unexpected end of expression")
+	default:
+		return nil, fmt.Errorf("unexpected '%s' at position %d", t.text, t.pos)
+	}
+}
+
+func (p *parser) parseCall(name string) (Node, error) {
+	call := &CallNode{Name: strings.ToLower(name)}
+	if p.peek().kind == tokenRightParen {
+		p.next()
+		return call, nil
+	}
+
+	for {
+		arg, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		call.Args = append(call.Args, arg)
+
+		t := p.next()
+		if t.kind == tokenRightParen {
+			return call, nil
+		}
+
+		if t.kind != tokenComma {
+			return nil, fmt.Errorf("expect ',' or ')' in arguments of %s at position %d", name, t.pos)
+		}
+	}
+}
+
+func (p *parser) parseSelector(metric string) (Node, error) {
+	selector := &SelectorNode{Metric: metric, Tags: make(map[string]string)}
+	if p.peek().kind != tokenLeftBrace {
+		return selector, nil
+	}
+
+	p.next()
+	for p.peek().kind != tokenRightBrace {
+		key, err := p.expect(tokenIdent, "tag key")
+		if err != nil {
+			return nil, err
+		}
+
+		op := p.next()
+		if op.kind != tokenEqual && op.kind != tokenNotEqual && op.kind != tokenRegex && op.kind != tokenNotRegex {
+			return nil, fmt.Errorf("expect =, !=, =~ or !~ after tag key %s", key.text)
+		}
+
+		value, err := p.expect(tokenString, "tag value")
+		if err != nil {
+			return nil, err
+		}
+
+		if len(value.text) == 0 {
+			return nil, fmt.Errorf("empty value for tag key %s", key.text)
+		}
+
+		if op.kind == tokenRegex || op.kind == tokenNotRegex {
+			selector.Matchers = append(selector.Matchers, protocol.TagMatcher{Key: key.text, Op: op.text, Value: value.text})
+			if err = protocol.CheckMatchers(selector.Matchers[len(selector.Matchers)-1:]); err != nil {
+				return nil, err
+			}
+		} else if op.kind == tokenNotEqual {
+			selector.Tags["!="+key.text] = value.text
+		} else {
+			selector.Tags[key.text] = value.text
+		}
+
+		if p.peek().kind == tokenComma {
+			p.next()
+		}
+	}
+	p.next() // skip }
+	return selector, nil
+}
+
+// Check check function names and argument count, and set the aggregator of selectors that are arguments of
+// functions like irate, it's called by Parse, and should be called for syntax tree built by other parsers
+func Check(node Node) error {
+	switch n := node.(type) {
+	case *BinaryNode:
+		if err := Check(n.Left); err != nil {
+			return err
+		}
+		return Check(n.Right)
+	case *UnaryNode:
+		return Check(n.Expr)
+	case *CallNode:
+		f, exist := functions[n.Name]
+		if !exist {
+			return fmt.Errorf("no such function: %s", n.Name)
+		}
+
+		if len(n.Args) < f.MinArgs || (f.MaxArgs >= 0 && len(n.Args) > f.MaxArgs) {
+			return fmt.Errorf("wrong argument count for function: %s", n.Name)
+		}
+
+		if len(f.Aggregator) > 0 {
+			selector, ok := n.Args[0].(*SelectorNode)
+			if !ok {
+				return fmt.Errorf("the argument of function %s must be a metric", n.Name)
+			}
+			selector.Aggregator = f.Aggregator
+		}
+
+		for _, arg := range n.Args {
+			if err := Check(arg); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package expr
+
+import (
+	"fmt"
+	"github.com/sentrycloud/sentry/pkg/protocol"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// windowStart return the index of the first data point in the window that ends at dps[i]
+func windowStart(dps []protocol.TimeValuePoint, i int, start int, window protocol.SmoothWindow) int {
+	if window.Points > 0 {
+		if i-window.Points+1 > 0 {
+			return i - window.Points + 1
+		}
+		return 0
+	}
+
+	for start < i && dps[start].TimeStamp <= dps[i].TimeStamp-window.Seconds {
+		start++
+	}
+	return start
+}
+
+// MovingAvg calculate the average of data points in the window that ends at each data point,
+// windows at the beginning have less data points
+func MovingAvg(dps []protocol.TimeValuePoint, window protocol.SmoothWindow) []protocol.TimeValuePoint {
+	result := make([]protocol.TimeValuePoint, len(dps))
+	start := 0
+	var sum float64
+	for i, dp := range dps {
+		sum += dp.Value
+		next := windowStart(dps, i, start, window)
+		for ; start < next; start++ {
+			sum -= dps[start].Value
+		}
+		result[i] = protocol.TimeValuePoint{TimeStamp: dp.TimeStamp, Value: sum / float64(i-start+1)}
+	}
+	return result
+}
+
+// MovingMedian calculate the median of data points in the window that ends at each data point
+func MovingMedian(dps []protocol.TimeValuePoint, window protocol.SmoothWindow) []protocol.TimeValuePoint {
+	result := make([]protocol.TimeValuePoint, len(dps))
+	start := 0
+	var values []float64
+	for i, dp := range dps {
+		start = windowStart(dps, i, start, window)
+		values = values[:0]
+		for _, v := range dps[start : i+1] {
+			values = append(values, v.Value)
+		}
+		sort.Float64s(values)
+
+		median := values[len(values)/2]
+		if len(values)%2 == 0 {
+			median = (values[len(values)/2-1] + median) / 2
+		}
+		result[i] = protocol.TimeValuePoint{TimeStamp: dp.TimeStamp, Value: median}
+	}
+	return result
+}
+
+// EWMA calculate exponentially weighted moving average, alpha is 2/(N+1) for a window of N data points,
+// for a duration window, the weight of the previous average decays by exp(-interval/window)
+func EWMA(dps []protocol.TimeValuePoint, window protocol.SmoothWindow) []protocol.TimeValuePoint {
+	result := make([]protocol.TimeValuePoint, len(dps))
+	alpha := 2 / float64(window.Points+1)
+	for i, dp := range dps {
+		if i == 0 {
+			result[i] = dp
+			continue
+		}
+
+		if window.Points == 0 {
+			alpha = 1 - math.Exp(-float64(dp.TimeStamp-dps[i-1].TimeStamp)/float64(window.Seconds))
+		}
+		result[i] = protocol.TimeValuePoint{TimeStamp: dp.TimeStamp, Value: alpha*dp.Value + (1-alpha)*result[i-1].Value}
+	}
+	return result
+}
+
+// CumSum calculate the cumulative sum of data points
+func CumSum(dps []protocol.TimeValuePoint) []protocol.TimeValuePoint {
+	result := make([]protocol.TimeValuePoint, len(dps))
+	var sum float64
+	for i, dp := range dps {
+		sum += dp.Value
+		result[i] = protocol.TimeValuePoint{TimeStamp: dp.TimeStamp, Value: sum}
+	}
+	return result
+}
+
+// ApplySmoothFunction apply a checked smoothing function to the data points of each curve
+func ApplySmoothFunction(function string, window protocol.SmoothWindow, curveDataList []*protocol.CurveData) {
+	var f func([]protocol.TimeValuePoint) []protocol.TimeValuePoint
+	switch function {
+	case protocol.SmoothMovingAvg:
+		f = func(dps []protocol.TimeValuePoint) []protocol.TimeValuePoint { return MovingAvg(dps, window) }
+	case protocol.SmoothMovingMedian:
+		f = func(dps []protocol.TimeValuePoint) []protocol.TimeValuePoint { return MovingMedian(dps, window) }
+	case protocol.SmoothEWMA:
+		f = func(dps []protocol.TimeValuePoint) []protocol.TimeValuePoint { return EWMA(dps, window) }
+	case protocol.SmoothCumSum:
+		f = CumSum
+	default:
+		return
+	}
+
+	for _, curveData := range curveDataList {
+		curveData.DPS = f(curveData.DPS)
+	}
+}
+
+// smoothFunction apply smoothing function to series, the window is a number of data points or a duration string:
+//
+//	moving_avg(m{ip=*}, 10)    - average of the last 10 data points
+//	ewma(m{ip=*}, "5m")        - exponentially weighted moving average in 5 minutes
+func smoothFunction(function string) *Function {
+	minArgs, maxArgs := 2, 2
+	if function == protocol.SmoothCumSum {
+		minArgs, maxArgs = 1, 1
+	}
+
+	return &Function{
+		MinArgs: minArgs,
+		MaxArgs: maxArgs,
+		Call: func(args []Value) (Value, error) {
+			series, err := SeriesArg(args, 0)
+			if err != nil {
+				return Value{}, err
+			}
+
+			window := ""
+			if len(args) > 1 {
+				if args[1].Kind == ValueScalar {
+					window = strconv.FormatFloat(args[1].Scalar, 'f', -1, 64)
+				} else if window, err = StringArg(args, 1); err != nil {
+					return Value{}, err
+				}
+			}
+
+			_, w, err := protocol.CheckSmooth(function, window)
+			if err != nil {
+				return Value{}, fmt.Errorf("%s: %v", function, err)
+			}
+
+			var result []*protocol.CurveData
+			for _, s := range series {
+				result = append(result, &protocol.CurveData{Metric: s.Metric, Tags: s.Tags, DPS: s.DPS})
+			}
+			ApplySmoothFunction(function, w, result)
+			return seriesValue(result), nil
+		},
+	}
+}
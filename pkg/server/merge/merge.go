@@ -6,6 +6,7 @@ import (
 	"github.com/sentrycloud/sentry/pkg/server/monitor"
 	"github.com/sentrycloud/sentry/pkg/server/taos"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -19,6 +20,10 @@ type Merge struct {
 	sendTicker      *time.Ticker
 	mergeBuffer     *strings.Builder
 	resendBuffer    []string
+
+	sendWaitGroup sync.WaitGroup // in-flight sendPayload goroutines
+	stopChan      chan struct{}
+	doneChan      chan struct{}
 }
 
 func CreateMerge(mergeConfig config.MergeConfig, connPool *taos.ConnPool) *Merge {
@@ -31,6 +36,8 @@ func CreateMerge(mergeConfig config.MergeConfig, connPool *taos.ConnPool) *Merge
 	merge.mergeChan = make(chan string, merge.conf.ChanSize)
 	merge.resendChan = make(chan string, merge.conf.ChanSize)
 	merge.sendTicker = time.NewTicker(time.Duration(merge.conf.TickInterval) * time.Second)
+	merge.stopChan = make(chan struct{})
+	merge.doneChan = make(chan struct{})
 	return merge
 }
 
@@ -57,6 +64,62 @@ func (m *Merge) start() {
 			m.resendBuffer = append(m.resendBuffer, payload)
 		case <-m.sendTicker.C:
 			m.trySendPayload(true)
+		case <-m.stopChan:
+			m.flush()
+			close(m.doneChan)
+			return
+		}
+	}
+}
+
+// Stop drain the merge channel and send all buffered payload, wait until complete or timeout.
+// the tcp collector and http server must be stopped before, so no more payload will be merged
+func (m *Merge) Stop(timeout time.Duration) {
+	close(m.stopChan)
+	select {
+	case <-m.doneChan:
+		newlog.Info("merge stopped, all buffered payload are sent")
+	case <-time.After(timeout):
+		newlog.Error("merge stop timeout after %v, some buffered payload may be lost", timeout)
+	}
+}
+
+func (m *Merge) flush() {
+	m.sendTicker.Stop()
+
+	for len(m.mergeChan) > 0 {
+		m.appendPayload(<-m.mergeChan)
+		m.trySendPayload(false)
+	}
+	m.trySendPayload(true)
+
+	// failed payload of in-flight sending will be pushed to resendChan, this goroutine is the only reader,
+	// so drain it while waiting, otherwise senders block when it's full and the wait never returns
+	sendDone := make(chan struct{})
+	go func() {
+		m.sendWaitGroup.Wait()
+		close(sendDone)
+	}()
+
+	var failed []string
+	for waiting := true; waiting; {
+		select {
+		case payload := <-m.resendChan:
+			failed = append(failed, payload)
+		case <-sendDone:
+			waiting = false
+		}
+	}
+
+	for len(m.resendChan) > 0 {
+		failed = append(failed, <-m.resendChan)
+	}
+
+	// send them in the last chance
+	for _, payload := range failed {
+		err := m.connPool.SchemalessWrite(payload)
+		if err != nil {
+			newlog.Error("send payload failed when stop, %d bytes payload is lost: %v", len(payload), err)
 		}
 	}
 }
@@ -85,6 +148,7 @@ func (m *Merge) trySendPayload(fromTick bool) {
 	// resend failed metrics first
 	if len(m.resendBuffer) > 0 {
 		for _, payload := range m.resendBuffer {
+			m.sendWaitGroup.Add(1)
 			go m.sendPayload(payload)
 		}
 
@@ -94,6 +158,7 @@ func (m *Merge) trySendPayload(fromTick bool) {
 	// send new metrics in batch mode
 	if m.mergeBuffer.Len() >= m.conf.PayloadBatchSize || fromTick {
 		m.mergeBuffer.WriteString("]") // enclose metric list with [ ]
+		m.sendWaitGroup.Add(1)
 		go m.sendPayload(m.mergeBuffer.String())
 		m.mergeBuffer = new(strings.Builder)
 
@@ -102,6 +167,8 @@ func (m *Merge) trySendPayload(fromTick bool) {
 }
 
 func (m *Merge) sendPayload(payload string) {
+	defer m.sendWaitGroup.Done()
+
 	err := m.connPool.SchemalessWrite(payload)
 	if err != nil {
 		newlog.Error("send payload failed: %v", err)
@@ -0,0 +1,50 @@
+package merge
+
+import (
+	"github.com/sentrycloud/sentry/pkg/server/config"
+	"github.com/sentrycloud/sentry/pkg/server/taos"
+	"testing"
+	"time"
+)
+
+// failingPool can not connect, so every send fails
+func failingPool() *taos.ConnPool {
+	return taos.CreateConnPool(config.TaosConfig{Host: "127.0.0.1", Port: 1, User: "sentry", Password: "sentry", Database: "sentry"})
+}
+
+func TestFlushWithFailedSend(t *testing.T) {
+	m := CreateMerge(config.MergeConfig{ChanSize: 1, PayloadBatchSize: 1024, TickInterval: 5}, failingPool())
+
+	// more failed sends than the resend chan can hold
+	for i := 0; i < 10; i++ {
+		m.sendWaitGroup.Add(1)
+		go m.sendPayload("[]")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		m.flush()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("flush is blocked by failed sends")
+	}
+}
+
+func TestStop(t *testing.T) {
+	m := CreateMerge(config.MergeConfig{ChanSize: 1, PayloadBatchSize: 1, TickInterval: 1}, failingPool())
+	m.Start()
+	for i := 0; i < 10; i++ {
+		m.MergePayload(`{"metric":"m","tags":{"ip":"1"},"timestamp":1700000000,"value":1}`)
+	}
+
+	m.Stop(5 * time.Second)
+	select {
+	case <-m.doneChan:
+	default:
+		t.Fatal("merge is not stopped")
+	}
+}
@@ -0,0 +1,130 @@
+package metadata
+
+import (
+	"github.com/sentrycloud/sentry/pkg/dbmodel"
+	"github.com/sentrycloud/sentry/pkg/newlog"
+	"github.com/sentrycloud/sentry/pkg/protocol"
+	"sync"
+	"time"
+)
+
+const (
+	ReloadInterval = 60   // reload all metadata from MySQL, so changes by other servers are seen
+	SaveChanSize   = 1024 // metadata set at ingest time is saved to MySQL asynchronously, dropped if the chan is full
+)
+
+var (
+	mu       sync.RWMutex
+	metas    = make(map[string]protocol.MetricMeta)
+	saveChan chan dbmodel.MetricMeta
+)
+
+// Start load metadata of all metrics, and save metadata set at ingest time in the background
+func Start() {
+	reload()
+	saveChan = make(chan dbmodel.MetricMeta, SaveChanSize)
+	go saveLoop()
+	go func() {
+		for {
+			time.Sleep(ReloadInterval * time.Second)
+			reload()
+		}
+	}()
+}
+
+func reload() {
+	var entities []dbmodel.MetricMeta
+	if err := dbmodel.QueryMetricMetas(&entities); err != nil {
+		newlog.Error("query metric metadata failed: %v", err)
+		return
+	}
+
+	loaded := make(map[string]protocol.MetricMeta, len(entities))
+	for _, e := range entities {
+		loaded[e.Metric] = protocol.MetricMeta{Unit: e.Unit, Type: e.Type, Description: e.Description, AppName: e.AppName}
+	}
+
+	mu.Lock()
+	metas = loaded
+	mu.Unlock()
+}
+
+func saveLoop() {
+	for entity := range saveChan {
+		if err := dbmodel.MergeMetricMeta(&entity); err != nil {
+			newlog.Error("save metadata of metric=%s failed: %v", entity.Metric, err)
+		}
+	}
+}
+
+// Get return metadata of the metric, nil if it's not registered
+func Get(metric string) *protocol.MetricMeta {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if meta, exist := metas[metric]; exist {
+		return &meta
+	}
+	return nil
+}
+
+// Set update metadata of the metric in memory, it's called after the metadata is saved to MySQL
+func Set(metric string, meta protocol.MetricMeta) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	metas[metric] = meta
+}
+
+// Remove remove metadata of the metric in memory, it's called after the metadata is deleted from MySQL
+func Remove(metric string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	delete(metas, metric)
+}
+
+// Update set metadata of a data point at ingest time, metadata at ingest time often has some fields only,
+// so non-empty fields are merged into the existing metadata, and only changed metadata is saved to MySQL
+func Update(metric string, meta protocol.MetricMeta) {
+	var err error
+	meta.Type, err = protocol.CheckMetricType(meta.Type)
+	if err != nil {
+		newlog.Error("invalid metadata of metric=%s: %v", metric, err)
+		return
+	}
+
+	mu.Lock()
+	old, exist := metas[metric]
+	merged := mergeMeta(old, meta)
+	if exist && old == merged {
+		mu.Unlock()
+		return
+	}
+	metas[metric] = merged // data points after this one with the same metadata are not saved again
+	mu.Unlock()
+
+	entity := dbmodel.MetricMeta{Metric: metric, Unit: meta.Unit, Type: meta.Type, Description: meta.Description, AppName: meta.AppName}
+	select {
+	case saveChan <- entity:
+	default:
+		newlog.Warn("metadata save chan is full, drop metadata of metric=%s", metric)
+	}
+}
+
+// mergeMeta return old metadata with fields that are not empty in meta
+func mergeMeta(old protocol.MetricMeta, meta protocol.MetricMeta) protocol.MetricMeta {
+	if len(meta.Unit) > 0 {
+		old.Unit = meta.Unit
+	}
+	if len(meta.Type) > 0 {
+		old.Type = meta.Type
+	}
+	if len(meta.Description) > 0 {
+		old.Description = meta.Description
+	}
+	if len(meta.AppName) > 0 {
+		old.AppName = meta.AppName
+	}
+	return old
+}
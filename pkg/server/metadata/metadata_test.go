@@ -0,0 +1,61 @@
+package metadata
+
+import (
+	"github.com/sentrycloud/sentry/pkg/dbmodel"
+	"github.com/sentrycloud/sentry/pkg/protocol"
+	"testing"
+)
+
+func TestUpdate(t *testing.T) {
+	saveChan = make(chan dbmodel.MetricMeta, 10)
+	defer func() { saveChan = nil }()
+
+	meta := protocol.MetricMeta{Unit: "bytes/s", Type: "Counter", Description: "disk read", AppName: "sentry"}
+	Update("m", meta)
+	Update("m", meta)
+	if len(saveChan) != 1 {
+		t.Fatalf("same metadata should be saved once, saved %d times", len(saveChan))
+	}
+
+	if saved := <-saveChan; saved.Metric != "m" || saved.Type != protocol.MetricTypeCounter || saved.Unit != "bytes/s" {
+		t.Errorf("saved metadata not match: %v", saved)
+	}
+
+	if got := Get("m"); got == nil || got.Type != protocol.MetricTypeCounter {
+		t.Errorf("get metadata failed: %v", got)
+	}
+
+	Update("m", protocol.MetricMeta{Type: "summary"})
+	if len(saveChan) != 0 || Get("m").Unit != "bytes/s" {
+		t.Errorf("invalid metadata should be ignored")
+	}
+
+	Remove("m")
+	if Get("m") != nil {
+		t.Errorf("metadata should be removed")
+	}
+}
+
+func TestUpdatePartial(t *testing.T) {
+	saveChan = make(chan dbmodel.MetricMeta, 10)
+	defer func() { saveChan = nil }()
+	defer Remove("m")
+
+	Update("m", protocol.MetricMeta{Unit: "bytes", Description: "disk read", AppName: "sentry"})
+	<-saveChan
+
+	Update("m", protocol.MetricMeta{Unit: "bytes/s"})
+	got := Get("m")
+	if got == nil || got.Unit != "bytes/s" || got.Description != "disk read" || got.AppName != "sentry" {
+		t.Errorf("partial update should keep fields set earlier: %v", got)
+	}
+
+	if saved := <-saveChan; saved.Unit != "bytes/s" || saved.Description != "" || saved.AppName != "" {
+		t.Errorf("only fields of the partial update should be saved: %v", saved)
+	}
+
+	Update("m", protocol.MetricMeta{Description: "disk read"})
+	if len(saveChan) != 0 {
+		t.Errorf("unchanged metadata should not be saved")
+	}
+}
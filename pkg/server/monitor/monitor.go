@@ -16,6 +16,8 @@ const (
 	agentCountMetricName = "sentry_server_agent_count"
 	dataPointsMetricName = "sentry_server_data_point"
 	chanSizeMetricName   = "sentry_server_chan_size"
+	queryCacheMetricName = "sentry_server_query_cache"
+	cacheMemMetricName   = "sentry_server_query_cache_memory"
 	collectInterval      = 10
 )
 
@@ -24,6 +26,11 @@ var (
 	DataPointsCollector     sentrySdk.Collector
 	MergeChanSizeCollector  sentrySdk.Collector
 	ResendChanSizeCollector sentrySdk.Collector
+
+	QueryCacheHitCollector     sentrySdk.Collector
+	QueryCachePartialCollector sentrySdk.Collector
+	QueryCacheMissCollector    sentrySdk.Collector
+	QueryCacheMemoryCollector  sentrySdk.Collector
 )
 
 func InitMonitor() {
@@ -35,6 +42,11 @@ func InitMonitor() {
 	tags["chan"] = "resend"
 	ResendChanSizeCollector = sentrySdk.GetCollector(chanSizeMetricName, tags, sentrySdk.Avg, collectInterval)
 
+	QueryCacheHitCollector = sentrySdk.GetCollector(queryCacheMetricName, map[string]string{"type": "hit"}, sentrySdk.Sum, collectInterval)
+	QueryCachePartialCollector = sentrySdk.GetCollector(queryCacheMetricName, map[string]string{"type": "partial"}, sentrySdk.Sum, collectInterval)
+	QueryCacheMissCollector = sentrySdk.GetCollector(queryCacheMetricName, map[string]string{"type": "miss"}, sentrySdk.Sum, collectInterval)
+	QueryCacheMemoryCollector = sentrySdk.GetCollector(cacheMemMetricName, nil, sentrySdk.Avg, collectInterval)
+
 	reportURL := fmt.Sprintf("http://%s:51001/server/api/putMetrics", getLocalIP())
 	sentrySdk.SetReportURL(reportURL) // report to self
 	sentrySdk.StartCollectGC(appName)
@@ -0,0 +1,163 @@
+package promql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	tokenEOF = iota
+	tokenNumber
+	tokenIdent
+	tokenString
+	tokenOperator // + - * /
+	tokenLeftParen
+	tokenRightParen
+	tokenLeftBrace
+	tokenRightBrace
+	tokenComma
+	tokenMatchOp // = != =~ !~
+	tokenRange   // duration in brackets, like [5m]
+)
+
+type token struct {
+	kind  int
+	text  string
+	value float64
+	pos   int
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9') || c == '.'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func tokenize(input string) ([]token, error) {
+	var tokens []token
+	pos := 0
+	for {
+		for pos < len(input) && strings.ContainsRune(" \t\r\n", rune(input[pos])) {
+			pos++
+		}
+
+		if pos >= len(input) {
+			return append(tokens, token{kind: tokenEOF, pos: pos}), nil
+		}
+
+		c := input[pos]
+		start := pos
+		switch {
+		case c == '#':
+			for pos < len(input) && input[pos] != '\n' {
+				pos++ // comment to the end of line
+			}
+		case isDigit(c) || (c == '.' && pos+1 < len(input) && isDigit(input[pos+1])):
+			for pos < len(input) && (isDigit(input[pos]) || input[pos] == '.') {
+				pos++
+			}
+
+			if pos < len(input) && (input[pos] == 'e' || input[pos] == 'E') {
+				pos++
+				if pos < len(input) && (input[pos] == '+' || input[pos] == '-') {
+					pos++
+				}
+				for pos < len(input) && isDigit(input[pos]) {
+					pos++
+				}
+			}
+
+			value, err := strconv.ParseFloat(input[start:pos], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number '%s' at position %d", input[start:pos], start)
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: input[start:pos], value: value, pos: start})
+		case isIdentStart(c):
+			for pos < len(input) && isIdentChar(input[pos]) {
+				pos++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: input[start:pos], pos: start})
+		case c == '"' || c == '\'' || c == '`':
+			value, end, err := readString(input, pos)
+			if err != nil {
+				return nil, err
+			}
+			pos = end
+			tokens = append(tokens, token{kind: tokenString, text: value, pos: start})
+		case c == '+' || c == '-' || c == '*' || c == '/':
+			pos++
+			tokens = append(tokens, token{kind: tokenOperator, text: string(c), pos: start})
+		case c == '=' || c == '!':
+			op := string(c)
+			if pos+1 < len(input) && (input[pos+1] == '~' || (c == '!' && input[pos+1] == '=')) {
+				op += string(input[pos+1])
+			}
+
+			if op == "!" || strings.HasPrefix(input[pos:], "==") {
+				return nil, fmt.Errorf("unsupported operator at position %d", start)
+			}
+			pos += len(op)
+			tokens = append(tokens, token{kind: tokenMatchOp, text: op, pos: start})
+		case c == '[':
+			end := strings.IndexByte(input[pos:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated range at position %d", start)
+			}
+
+			rng := strings.TrimSpace(input[pos+1 : pos+end])
+			if strings.Contains(rng, ":") {
+				return nil, fmt.Errorf("subquery is not supported at position %d", start)
+			}
+			pos += end + 1
+			tokens = append(tokens, token{kind: tokenRange, text: rng, pos: start})
+		default:
+			kinds := map[byte]int{'(': tokenLeftParen, ')': tokenRightParen, '{': tokenLeftBrace, '}': tokenRightBrace, ',': tokenComma}
+			kind, ok := kinds[c]
+			if !ok {
+				return nil, fmt.Errorf("unexpected character '%c' at position %d", c, start)
+			}
+			pos++
+			tokens = append(tokens, token{kind: kind, text: string(c), pos: start})
+		}
+	}
+}
+
+// readString read a quoted string at pos, escape sequences are not processed in raw string quoted by `
+func readString(input string, pos int) (string, int, error) {
+	quote := input[pos]
+	start := pos
+	pos++
+	for pos < len(input) {
+		c := input[pos]
+		if c == '\\' && quote != '`' {
+			pos += 2
+			continue
+		}
+
+		if c == quote {
+			if quote == '`' {
+				return input[start+1 : pos], pos + 1, nil
+			}
+
+			var sb strings.Builder
+			for s := input[start+1 : pos]; len(s) > 0; {
+				r, _, tail, err := strconv.UnquoteChar(s, quote)
+				if err != nil {
+					return "", 0, fmt.Errorf("invalid string at position %d", start)
+				}
+				sb.WriteRune(r)
+				s = tail
+			}
+			return sb.String(), pos + 1, nil
+		}
+		pos++
+	}
+	return "", 0, fmt.Errorf("unterminated string at position %d", start)
+}
@@ -0,0 +1,479 @@
+package promql
+
+import (
+	"fmt"
+	"github.com/sentrycloud/sentry/pkg/protocol"
+	"github.com/sentrycloud/sentry/pkg/server/expr"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const MaxQueryLength = 4096
+
+// functions of the subset, aggregations and rate functions are handled by the parser
+var instantFunctions = map[string]int{"abs": 1, "ceil": 1, "floor": 1, "round": 1, "clamp_min": 2, "clamp_max": 2}
+var rangeFunctions = map[string]string{"rate": protocol.FunctionRate, "irate": protocol.FunctionIRate, "increase": protocol.FunctionIncrease}
+var aggregations = map[string]bool{"sum": true, "avg": true, "max": true, "min": true, "count": true, "topk": true, "bottomk": true}
+
+// matrixNode is a range vector selector like m[5m], it's only allowed as argument of rate, irate and increase
+type matrixNode struct {
+	selector *expr.SelectorNode
+	rng      string
+}
+
+func (n *matrixNode) String() string {
+	return n.selector.String() + "[" + n.rng + "]"
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// Parse a PromQL query to the syntax tree of expr package, so it can be evaluated by expr.Eval. the subset is:
+// selectors with =, !=, =~ and !~ matchers, rate, irate and increase of range vectors, sum, avg, max, min and count
+// with by clause, topk, bottomk, abs, ceil, floor, round, clamp_min, clamp_max and + - * / arithmetic.
+// label matchers are converted to tag matchers of the selector, and regex is anchored like PromQL, examples:
+//
+//	sum by (app) (rate(http_requests_total{code=~"5.."}[5m]))
+//	topk(5, sentry_sys_cpu_usage{sentryIP!="10.0.0.1"}) * 100
+func Parse(input string) (expr.Node, error) {
+	if len(strings.TrimSpace(input)) == 0 {
+		return nil, fmt.Errorf("empty query")
+	}
+
+	if len(input) > MaxQueryLength {
+		return nil, fmt.Errorf("query is too long")
+	}
+
+	tokens, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	node, err := p.parseInstant()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek().kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected '%s' at position %d", p.peek().text, p.peek().pos)
+	}
+
+	if err = expr.Check(node); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) expect(kind int, text string) (token, error) {
+	t := p.next()
+	if t.kind != kind {
+		if t.kind == tokenEOF {
+			return t, fmt.Errorf("expect '%s' but reach the end", text)
+		}
+		return t, fmt.Errorf("expect '%s' but got '%s' at position %d", text, t.text, t.pos)
+	}
+	return t, nil
+}
+
+// parseInstant parse an expression that must not be a range vector
+func (p *parser) parseInstant() (expr.Node, error) {
+	node, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	if m, ok := node.(*matrixNode); ok {
+		return nil, fmt.Errorf("range vector %s is only supported in rate, irate and increase", m.String())
+	}
+	return node, nil
+}
+
+// expr := term (('+'|'-') term)*
+func (p *parser) parseExpr() (expr.Node, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().kind == tokenOperator && (p.peek().text == "+" || p.peek().text == "-") {
+		op := p.next().text
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+
+		if left, err = binary(op, left, right); err != nil {
+			return nil, err
+		}
+	}
+	return left, nil
+}
+
+// term := unary (('*'|'/') unary)*
+func (p *parser) parseTerm() (expr.Node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().kind == tokenOperator && (p.peek().text == "*" || p.peek().text == "/") {
+		op := p.next().text
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		if left, err = binary(op, left, right); err != nil {
+			return nil, err
+		}
+	}
+	return left, nil
+}
+
+func binary(op string, left expr.Node, right expr.Node) (expr.Node, error) {
+	for _, n := range []expr.Node{left, right} {
+		if m, ok := n.(*matrixNode); ok {
+			return nil, fmt.Errorf("range vector %s can not be an operand of %s", m.String(), op)
+		}
+	}
+	return &expr.BinaryNode{Op: op, Left: left, Right: right}, nil
+}
+
+// unary := ('-'|'+') unary | primary
+func (p *parser) parseUnary() (expr.Node, error) {
+	if p.peek().kind == tokenOperator && (p.peek().text == "-" || p.peek().text == "+") {
+		op := p.next().text
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		if op == "+" {
+			return node, nil
+		}
+
+		if number, ok := node.(*expr.NumberNode); ok {
+			return &expr.NumberNode{Value: -number.Value}, nil
+		}
+
+		if m, ok := node.(*matrixNode); ok {
+			return nil, fmt.Errorf("range vector %s can not be negative", m.String())
+		}
+		return &expr.UnaryNode{Op: op, Expr: node}, nil
+	}
+	return p.parsePrimary()
+}
+
+// primary := NUMBER | '(' expr ')' | aggregation | IDENT '(' args ')' | selector
+func (p *parser) parsePrimary() (expr.Node, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokenNumber:
+		p.next()
+		return &expr.NumberNode{Value: t.value}, nil
+	case tokenLeftParen:
+		p.next()
+		node, err := p.parseInstant()
+		if err != nil {
+			return nil, err
+		}
+		_, err = p.expect(tokenRightParen, ")")
+		return node, err
+	case tokenLeftBrace:
+		return p.parseSelector("")
+	case tokenIdent:
+		p.next()
+		name := strings.ToLower(t.text)
+		if aggregations[name] && (p.peek().kind == tokenLeftParen || p.peek().kind == tokenIdent) {
+			return p.parseAggregation(name)
+		}
+
+		if p.peek().kind == tokenLeftParen {
+			p.next()
+			return p.parseCall(name)
+		}
+		return p.parseSelector(t.text)
+	case tokenEOF:
+		return nil, fmt.Errorf("unexpected end of query")
+	default:
+		return nil, fmt.Errorf("unexpected '%s' at position %d", t.text, t.pos)
+	}
+}
+
+func (p *parser) parseArgs() ([]expr.Node, error) {
+	var args []expr.Node
+	if p.peek().kind == tokenRightParen {
+		p.next()
+		return args, nil
+	}
+
+	for {
+		arg, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+
+		t := p.next()
+		if t.kind == tokenRightParen {
+			return args, nil
+		}
+
+		if t.kind != tokenComma {
+			return nil, fmt.Errorf("expect ',' or ')' in arguments at position %d", t.pos)
+		}
+	}
+}
+
+func (p *parser) parseCall(name string) (expr.Node, error) {
+	args, err := p.parseArgs()
+	if err != nil {
+		return nil, err
+	}
+
+	if function, ok := rangeFunctions[name]; ok {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("function %s expect one argument", name)
+		}
+
+		m, ok := args[0].(*matrixNode)
+		if !ok {
+			return nil, fmt.Errorf("the argument of function %s must be a range vector", name)
+		}
+		return &expr.CallNode{Name: function, Args: []expr.Node{m.selector}}, nil
+	}
+
+	count, ok := instantFunctions[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported function: %s", name)
+	}
+
+	if len(args) != count {
+		return nil, fmt.Errorf("function %s expect %d arguments", name, count)
+	}
+
+	for _, arg := range args {
+		if m, ok := arg.(*matrixNode); ok {
+			return nil, fmt.Errorf("range vector %s is not supported in function %s", m.String(), name)
+		}
+	}
+	return &expr.CallNode{Name: name, Args: args}, nil
+}
+
+// aggregation := AGG [by '(' labels ')'] '(' args ')' [by '(' labels ')'],
+// the group labels are string arguments of the aggregate function in expr package
+func (p *parser) parseAggregation(name string) (expr.Node, error) {
+	labels, err := p.parseGrouping()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = p.expect(tokenLeftParen, "("); err != nil {
+		return nil, err
+	}
+
+	args, err := p.parseArgs()
+	if err != nil {
+		return nil, err
+	}
+
+	if labels == nil {
+		if labels, err = p.parseGrouping(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, arg := range args {
+		if m, ok := arg.(*matrixNode); ok {
+			return nil, fmt.Errorf("range vector %s is not supported in %s", m.String(), name)
+		}
+	}
+
+	if name == "topk" || name == "bottomk" {
+		if len(args) != 2 || len(labels) > 0 {
+			return nil, fmt.Errorf("%s expect a number and a vector without by clause", name)
+		}
+		return &expr.CallNode{Name: name, Args: []expr.Node{args[1], args[0]}}, nil
+	}
+
+	if len(args) != 1 {
+		return nil, fmt.Errorf("%s expect one argument", name)
+	}
+
+	for _, label := range labels {
+		args = append(args, &expr.StringNode{Value: label})
+	}
+	return &expr.CallNode{Name: name, Args: args}, nil
+}
+
+// parseGrouping parse by (label, ...), nil means no by clause, without is not supported
+func (p *parser) parseGrouping() ([]string, error) {
+	t := p.peek()
+	if t.kind != tokenIdent {
+		return nil, nil
+	}
+
+	if strings.ToLower(t.text) != "by" {
+		return nil, fmt.Errorf("unsupported '%s' at position %d, only by clause is supported", t.text, t.pos)
+	}
+	p.next()
+
+	if _, err := p.expect(tokenLeftParen, "("); err != nil {
+		return nil, err
+	}
+
+	labels := make([]string, 0)
+	for p.peek().kind != tokenRightParen {
+		label, err := p.expect(tokenIdent, "label")
+		if err != nil {
+			return nil, err
+		}
+		labels = append(labels, label.text)
+
+		if p.peek().kind == tokenComma {
+			p.next()
+		}
+	}
+	p.next() // skip )
+	return labels, nil
+}
+
+// selector := [IDENT] ['{' matchers '}'] ['[' duration ']']
+func (p *parser) parseSelector(metric string) (expr.Node, error) {
+	selector := &expr.SelectorNode{Metric: metric, Tags: make(map[string]string)}
+	if p.peek().kind == tokenLeftBrace {
+		p.next()
+		for p.peek().kind != tokenRightBrace {
+			if err := p.parseMatcher(selector); err != nil {
+				return nil, err
+			}
+
+			if p.peek().kind == tokenComma {
+				p.next()
+			} else if p.peek().kind != tokenRightBrace {
+				return nil, fmt.Errorf("expect ',' or '}' at position %d", p.peek().pos)
+			}
+		}
+		p.next() // skip }
+	}
+
+	if len(selector.Metric) == 0 {
+		return nil, fmt.Errorf("metric name is required in selector")
+	}
+
+	if err := protocol.CheckMatchers(selector.Matchers); err != nil {
+		return nil, err
+	}
+
+	if p.peek().kind == tokenRange {
+		rng := p.next().text
+		if _, err := ParseDuration(rng); err != nil {
+			return nil, err
+		}
+		return &matrixNode{selector: selector, rng: rng}, nil
+	}
+	return selector, nil
+}
+
+// parseMatcher convert a label matcher to a tag matcher, empty value means the label is absent like PromQL
+func (p *parser) parseMatcher(selector *expr.SelectorNode) error {
+	key, err := p.expect(tokenIdent, "label")
+	if err != nil {
+		return err
+	}
+
+	op := p.next()
+	if op.kind != tokenMatchOp {
+		return fmt.Errorf("expect =, !=, =~ or !~ after label %s", key.text)
+	}
+
+	value, err := p.expect(tokenString, "label value")
+	if err != nil {
+		return err
+	}
+
+	if key.text == "__name__" {
+		if op.text != protocol.MatchEqual {
+			return fmt.Errorf("only = is supported for __name__")
+		}
+		selector.Metric = value.text
+		return nil
+	}
+
+	matcher := protocol.TagMatcher{Key: key.text, Op: op.text, Value: value.text}
+	switch {
+	case len(value.text) == 0 && op.text == protocol.MatchEqual:
+		matcher.Op = protocol.MatchAbsent
+	case len(value.text) == 0 && op.text == protocol.MatchNotEqual:
+		matcher.Op, matcher.Value = protocol.MatchRegex, "."
+	case op.text == protocol.MatchRegex || op.text == protocol.MatchNotRegex:
+		matcher.Value = "^(" + value.text + ")$" // PromQL regex is fully anchored, TDengine MATCH is not
+	}
+	selector.Matchers = append(selector.Matchers, matcher)
+	return nil
+}
+
+// ParseDuration parse PromQL duration like 30s, 5m, 1h30m or a float number of seconds, result is in seconds
+func ParseDuration(s string) (float64, error) {
+	if v, err := strconv.ParseFloat(s, 64); err == nil {
+		return v, nil
+	}
+
+	units := map[string]float64{"ms": 0.001, "s": 1, "m": 60, "h": 3600, "d": 24 * 3600, "w": 7 * 24 * 3600, "y": 365 * 24 * 3600}
+	var total float64
+	rest := s
+	for len(rest) > 0 {
+		i := 0
+		for i < len(rest) && rest[i] >= '0' && rest[i] <= '9' {
+			i++
+		}
+
+		j := i
+		for j < len(rest) && rest[j] >= 'a' && rest[j] <= 'z' {
+			j++
+		}
+
+		n, err := strconv.Atoi(rest[:i])
+		unit, ok := units[rest[i:j]]
+		if err != nil || !ok {
+			return 0, fmt.Errorf("invalid duration: %s", s)
+		}
+
+		total += float64(n) * unit
+		rest = rest[j:]
+	}
+
+	if total <= 0 {
+		return 0, fmt.Errorf("invalid duration: %s", s)
+	}
+	return total, nil
+}
+
+// ParseTime parse unix timestamp in seconds with optional decimal part, or RFC3339 time, result is in seconds
+func ParseTime(s string) (int64, error) {
+	if v, err := strconv.ParseFloat(s, 64); err == nil {
+		return int64(v), nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time: %s", s)
+	}
+	return t.Unix(), nil
+}
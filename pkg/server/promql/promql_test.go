@@ -0,0 +1,79 @@
+package promql
+
+import (
+	"github.com/sentrycloud/sentry/pkg/protocol"
+	"github.com/sentrycloud/sentry/pkg/server/expr"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	cases := map[string]string{
+		`http_requests_total`: `http_requests_total`,
+		`sum by (app) (rate(http_requests_total{code=~"5.."}[5m]))`: `sum(rate(http_requests_total{code=~"^(5..)$"}), "app")`,
+		`avg(m{host!="a", dc=''}) by (dc, host) * 100`:              `(avg(m{dcabsent"",host!="a"}, "dc", "host") * 100)`,
+		`topk(3, irate({__name__="m", app='web'}[1m]))`:             `topk(irate(m{app="web"}), 3)`,
+		`-clamp_max(a, 10) / (b + 1)  # comment`:                    `(-clamp_max(a, 10) / (b + 1))`,
+		"count(m{path=`/api/\\d+`})":                                `count(m{path="/api/\\d+"})`,
+	}
+
+	for query, expect := range cases {
+		node, err := Parse(query)
+		if err != nil {
+			t.Errorf("parse %s failed: %v", query, err)
+			continue
+		}
+
+		if node.String() != expect {
+			t.Errorf("parse %s expect %s, got %s", query, expect, node.String())
+		}
+	}
+
+	for _, query := range []string{"", "m[5m]", "rate(m)", "sum without (a) (m)", "m > 1", "rate(m[5m:1m])", "{app=\"a\"}",
+		"abs(m[5m])", "histogram_quantile(0.9, m)", "m{a=~\"(\"}", "topk by (a) (3, m)", "m offset 5m"} {
+		if _, err := Parse(query); err == nil {
+			t.Errorf("parse %s expect error", query)
+		}
+	}
+}
+
+func TestEvalTopk(t *testing.T) {
+	node, err := Parse("topk(1, m) * 2")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+
+	series, err := expr.Eval(node, func(selector *expr.SelectorNode) ([]*protocol.CurveData, error) {
+		return []*protocol.CurveData{
+			{Metric: "m", Tags: map[string]string{"ip": "1"}, DPS: []protocol.TimeValuePoint{{TimeStamp: 10, Value: 1}, {TimeStamp: 20, Value: 5}}},
+			{Metric: "m", Tags: map[string]string{"ip": "2"}, DPS: []protocol.TimeValuePoint{{TimeStamp: 10, Value: 3}, {TimeStamp: 20, Value: 4}}},
+		}, nil
+	})
+
+	if err != nil || len(series) != 2 {
+		t.Fatalf("eval failed: %v, %v", err, series)
+	}
+
+	for _, s := range series {
+		if len(s.DPS) != 1 {
+			t.Fatalf("ip=%s expect one data point, got %v", s.Tags["ip"], s.DPS)
+		}
+
+		if (s.Tags["ip"] == "1" && s.DPS[0] != protocol.TimeValuePoint{TimeStamp: 20, Value: 10}) || (s.Tags["ip"] == "2" && s.DPS[0] != protocol.TimeValuePoint{TimeStamp: 10, Value: 6}) {
+			t.Errorf("ip=%s not match: %v", s.Tags["ip"], s.DPS)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	for s, expect := range map[string]float64{"30s": 30, "1h30m": 5400, "15": 15, "0.5": 0.5, "100ms": 0.1} {
+		if v, err := ParseDuration(s); err != nil || v != expect {
+			t.Errorf("parse %s expect %v, got %v, %v", s, expect, v, err)
+		}
+	}
+
+	for _, s := range []string{"", "5x", "m", "0s"} {
+		if _, err := ParseDuration(s); err == nil {
+			t.Errorf("parse %s expect error", s)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package tail
+
+import (
+	"errors"
+	"github.com/sentrycloud/sentry/pkg/protocol"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	MaxSubscribers       = 64
+	SubscriberBufferSize = 1024 // data points buffered for a subscriber, more data points are dropped until it catches up
+)
+
+var (
+	ErrTooManySubscribers = errors.New("too many live tail subscribers")
+	ErrClosed             = errors.New("live tail is closed")
+)
+
+// Subscriber receive data points of a metric that match the tags, tag value can be "*" for any value,
+// "prefix*" for values with the prefix, or "v1||v2" for any of the values
+type Subscriber struct {
+	metric  string
+	tags    map[string]string
+	points  chan protocol.MetricValue
+	done    chan struct{}
+	dropped int64
+}
+
+var (
+	mu              sync.RWMutex
+	subscribers     = make(map[string]map[*Subscriber]struct{}) // subscribers of each metric
+	subscriberCount int32
+	closed          bool
+)
+
+// Subscribe add a subscriber of the metric, it must be unsubscribed when the client leaves
+func Subscribe(metric string, tags map[string]string) (*Subscriber, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if closed {
+		return nil, ErrClosed
+	}
+
+	if subscriberCount >= MaxSubscribers {
+		return nil, ErrTooManySubscribers
+	}
+
+	s := &Subscriber{
+		metric: metric,
+		tags:   tags,
+		points: make(chan protocol.MetricValue, SubscriberBufferSize),
+		done:   make(chan struct{}),
+	}
+
+	if subscribers[metric] == nil {
+		subscribers[metric] = make(map[*Subscriber]struct{})
+	}
+	subscribers[metric][s] = struct{}{}
+	atomic.AddInt32(&subscriberCount, 1)
+	return s, nil
+}
+
+// Unsubscribe remove the subscriber, data points are not sent to it any more
+func Unsubscribe(s *Subscriber) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, exist := subscribers[s.metric][s]; !exist {
+		return
+	}
+
+	delete(subscribers[s.metric], s)
+	if len(subscribers[s.metric]) == 0 {
+		delete(subscribers, s.metric)
+	}
+	atomic.AddInt32(&subscriberCount, -1)
+}
+
+// Close notify all subscribers to leave and reject new subscribers, it's called before the http server shutdown,
+// so streaming requests do not block the shutdown
+func Close() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if closed {
+		return
+	}
+
+	closed = true
+	for _, metricSubscribers := range subscribers {
+		for s := range metricSubscribers {
+			close(s.done)
+		}
+	}
+}
+
+// Publish send data points to subscribers that match, it's called at ingest time, so it never blocks:
+// if the buffer of a subscriber is full, the data point is dropped and counted for the subscriber
+func Publish(metrics []protocol.MetricValue) {
+	if atomic.LoadInt32(&subscriberCount) == 0 {
+		return
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	for _, metric := range metrics {
+		for s := range subscribers[metric.Metric] {
+			if !matchTags(s.tags, metric.Tags) {
+				continue
+			}
+
+			point := metric
+			point.Tags = make(map[string]string, len(metric.Tags))
+			for k, v := range metric.Tags {
+				point.Tags[k] = v
+			}
+
+			select {
+			case s.points <- point:
+			default:
+				atomic.AddInt64(&s.dropped, 1)
+			}
+		}
+	}
+}
+
+// Points return the chan of data points that match the subscriber
+func (s *Subscriber) Points() <-chan protocol.MetricValue {
+	return s.points
+}
+
+// Done is closed when live tail is closed, the subscriber should leave
+func (s *Subscriber) Done() <-chan struct{} {
+	return s.done
+}
+
+// TakeDropped return the count of data points dropped since the last call
+func (s *Subscriber) TakeDropped() int64 {
+	return atomic.SwapInt64(&s.dropped, 0)
+}
+
+func matchTags(filter map[string]string, tags map[string]string) bool {
+	for k, v := range filter {
+		value, exist := tags[k]
+		if !exist {
+			return false
+		}
+
+		switch {
+		case strings.HasSuffix(v, "*"):
+			if !strings.HasPrefix(value, v[:len(v)-1]) {
+				return false
+			}
+		case strings.Contains(v, "||"):
+			if !containsValue(strings.Split(v, "||"), value) {
+				return false
+			}
+		case v != value:
+			return false
+		}
+	}
+	return true
+}
+
+func containsValue(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package tail
+
+import (
+	"github.com/sentrycloud/sentry/pkg/protocol"
+	"testing"
+)
+
+func TestPublish(t *testing.T) {
+	s, err := Subscribe("m", map[string]string{"ip": "10.0.*", "app": "web||api"})
+	if err != nil {
+		t.Fatalf("subscribe failed: %v", err)
+	}
+	defer Unsubscribe(s)
+
+	Publish([]protocol.MetricValue{
+		{Metric: "m", Tags: map[string]string{"ip": "10.0.0.1", "app": "web"}, Value: 1},
+		{Metric: "m", Tags: map[string]string{"ip": "10.1.0.1", "app": "web"}, Value: 2},
+		{Metric: "m", Tags: map[string]string{"ip": "10.0.0.1", "app": "db"}, Value: 3},
+		{Metric: "m", Tags: map[string]string{"ip": "10.0.0.1"}, Value: 4},
+		{Metric: "n", Tags: map[string]string{"ip": "10.0.0.1", "app": "api"}, Value: 5},
+	})
+
+	if len(s.Points()) != 1 {
+		t.Fatalf("expect 1 matched data point, got %d", len(s.Points()))
+	}
+
+	if point := <-s.Points(); point.Value != 1 {
+		t.Errorf("unexpected data point: %v", point)
+	}
+
+	// a slow subscriber drop data points instead of blocking ingestion
+	metrics := make([]protocol.MetricValue, SubscriberBufferSize+10)
+	for i := range metrics {
+		metrics[i] = protocol.MetricValue{Metric: "m", Tags: map[string]string{"ip": "10.0.0.2", "app": "api"}}
+	}
+	Publish(metrics)
+	if len(s.Points()) != SubscriberBufferSize || s.TakeDropped() != 10 || s.TakeDropped() != 0 {
+		t.Errorf("expect buffer full and 10 dropped, buffered %d", len(s.Points()))
+	}
+}
+
+func TestSubscribeLimit(t *testing.T) {
+	var list []*Subscriber
+	for i := 0; i < MaxSubscribers; i++ {
+		s, err := Subscribe("m", nil)
+		if err != nil {
+			t.Fatalf("subscribe failed: %v", err)
+		}
+		list = append(list, s)
+	}
+
+	if _, err := Subscribe("m", nil); err != ErrTooManySubscribers {
+		t.Errorf("expect too many subscribers, got %v", err)
+	}
+
+	for _, s := range list {
+		Unsubscribe(s)
+	}
+
+	if subscriberCount != 0 || len(subscribers) != 0 {
+		t.Errorf("expect no subscriber, count=%d", subscriberCount)
+	}
+}
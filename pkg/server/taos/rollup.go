@@ -0,0 +1,208 @@
+package taos
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"github.com/sentrycloud/sentry/pkg/newlog"
+	"hash/crc32"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	RollupCheckInterval = 600 // check new metrics and tag changes every 10 minutes
+	RollupTableSuffix   = "__rollup_"
+	RollupWatermark     = 60 // seconds, data points delayed less than watermark are still aggregated
+	DescTableSqlFormat  = "DESC `%s`;"
+	DropStreamSqlFormat = "DROP STREAM IF EXISTS `%s`;"
+	DropStableSqlFormat = "DROP STABLE IF EXISTS `%s`;"
+
+	// rollup table has the same tags of the metric, and keep sum/count/min/max of each interval,
+	// so sum, count, avg, min, max and spread can be calculated from rollup for any multiple of the interval
+	CreateStreamSqlFormat = "CREATE STREAM IF NOT EXISTS `%s` TRIGGER WINDOW_CLOSE WATERMARK %ds IGNORE EXPIRED 1 FILL_HISTORY 1 " +
+		"INTO `%s` AS SELECT _wstart AS _ts, SUM(_value) AS _sum, COUNT(_value) AS _count, MIN(_value) AS _min, MAX(_value) AS _max " +
+		"FROM `%s` PARTITION BY %s INTERVAL(%ds);"
+)
+
+type Rollup struct {
+	Name     string
+	Interval int64 // seconds
+}
+
+// Rollups are ordered from the coarsest to the finest
+var Rollups = []Rollup{{Name: "1h", Interval: 3600}, {Name: "5m", Interval: 300}}
+
+var (
+	rollupConnPool  *ConnPool
+	rollupTagKeys   = make(map[string]string) // metric -> tag keys that rollup streams are created with
+	streamNameRegex = regexp.MustCompile("[^a-zA-Z0-9_]")
+)
+
+func RollupTableName(metric string, rollup Rollup) string {
+	return metric + RollupTableSuffix + rollup.Name
+}
+
+func IsRollupTable(table string) bool {
+	return strings.Contains(table, RollupTableSuffix)
+}
+
+// stream name only allow letters, digits and underscore, add crc32 of the metric to avoid name conflict
+func rollupStreamName(metric string, rollup Rollup) string {
+	name := streamNameRegex.ReplaceAllString(metric, "_")
+	if len(name) > 100 {
+		name = name[:100]
+	}
+	return fmt.Sprintf("rollup_%s_%s_%08x", rollup.Name, name, crc32.ChecksumIEEE([]byte(metric)))
+}
+
+func buildCreateStreamSql(metric string, tagKeys []string, rollup Rollup) string {
+	partition := "tbname"
+	if len(tagKeys) > 0 {
+		partition = "`" + strings.Join(tagKeys, "`,`") + "`"
+	}
+	return fmt.Sprintf(CreateStreamSqlFormat, rollupStreamName(metric, rollup), RollupWatermark,
+		RollupTableName(metric, rollup), metric, partition, rollup.Interval)
+}
+
+// StartRollup create rollup streams for all metrics, and recreate them when tags of a metric changed
+func StartRollup(pool *ConnPool) {
+	newlog.Info("StartRollup")
+	rollupConnPool = pool
+
+	for {
+		checkStartTick := time.Now().Unix()
+		createRollups()
+		checkTotalTime := time.Now().Unix() - checkStartTick
+		time.Sleep(time.Duration(RollupCheckInterval-checkTotalTime) * time.Second)
+	}
+}
+
+func createRollups() {
+	rows, err := queryRollupRows(ShowStablesSql, 1)
+	if err != nil {
+		return
+	}
+
+	createCount := 0
+	for _, row := range rows {
+		metric, _ := row[0].(string)
+		if len(metric) == 0 || IsRollupTable(metric) {
+			continue
+		}
+
+		tagKeys, err := queryTagKeys(metric)
+		if err != nil {
+			continue
+		}
+
+		signature := strings.Join(tagKeys, ",")
+		oldSignature, exist := rollupTagKeys[metric]
+		if exist && oldSignature == signature {
+			continue
+		}
+
+		if !exist {
+			// first check after restart, compare with tags of the existing rollup table
+			rollupTags, err := queryTagKeys(RollupTableName(metric, Rollups[0]))
+			exist = err == nil && len(rollupTags) > 0
+			oldSignature = strings.Join(rollupTags, ",")
+		}
+
+		if exist && oldSignature != signature {
+			newlog.Warn("tags of metric=%s changed from [%s] to [%s], recreate rollup", metric, oldSignature, signature)
+			dropRollup(metric)
+		}
+
+		if createRollup(metric, tagKeys) {
+			rollupTagKeys[metric] = signature
+			createCount++
+		}
+	}
+
+	if createCount > 0 {
+		newlog.Info("create or check rollup for %d metrics", createCount)
+	}
+}
+
+func createRollup(metric string, tagKeys []string) bool {
+	for _, rollup := range Rollups {
+		if err := execRollupSql(buildCreateStreamSql(metric, tagKeys, rollup)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func dropRollup(metric string) {
+	for _, rollup := range Rollups {
+		_ = execRollupSql(fmt.Sprintf(DropStreamSqlFormat, rollupStreamName(metric, rollup)))
+		_ = execRollupSql(fmt.Sprintf(DropStableSqlFormat, RollupTableName(metric, rollup)))
+	}
+}
+
+// queryTagKeys return sorted tag keys of a stable, DESC returns field, type, length, note for each column
+func queryTagKeys(table string) ([]string, error) {
+	rows, err := queryRollupRows(fmt.Sprintf(DescTableSqlFormat, table), 4)
+	if err != nil {
+		return nil, err
+	}
+
+	var tagKeys []string
+	for _, row := range rows {
+		if note, _ := row[3].(string); note == "TAG" {
+			tagKeys = append(tagKeys, row[0].(string))
+		}
+	}
+	sort.Strings(tagKeys)
+	return tagKeys, nil
+}
+
+func queryRollupRows(sql string, columnCount int) ([][]driver.Value, error) {
+	conn, err := rollupConnPool.GetConn()
+	if err != nil {
+		newlog.Error("get taos conn failed: %v", err)
+		return nil, err
+	}
+
+	defer rollupConnPool.PutConn(conn)
+	rows, err := conn.Query(sql)
+	if err != nil {
+		newlog.Error("%s failed: %v", sql, err)
+		return nil, err
+	}
+
+	defer rows.Close()
+	var result [][]driver.Value
+	for {
+		values := make([]driver.Value, columnCount)
+		err = rows.Next(values)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			newlog.Error("%s fetch rows failed: %v", sql, err)
+			return nil, err
+		}
+		result = append(result, values)
+	}
+	return result, nil
+}
+
+func execRollupSql(sql string) error {
+	conn, err := rollupConnPool.GetConn()
+	if err != nil {
+		newlog.Error("get taos conn failed: %v", err)
+		return err
+	}
+
+	defer rollupConnPool.PutConn(conn)
+	_, err = conn.Exec(sql)
+	if err != nil {
+		newlog.Error("%s failed: %v", sql, err)
+	}
+	return err
+}
@@ -59,6 +59,9 @@ func scanAllTables() {
 
 	totalDropTableCount := 0
 	for index, metric := range metrics {
+		if IsRollupTable(metric) {
+			continue // rollup tables are kept for long range queries
+		}
 		totalDropTableCount += deleteOldTable(metric)
 
 		// log the process
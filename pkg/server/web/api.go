@@ -1,10 +1,13 @@
 package web
 
 import (
+	"errors"
+	"github.com/sentrycloud/sentry/pkg/graceful"
 	"github.com/sentrycloud/sentry/pkg/newlog"
 	"github.com/sentrycloud/sentry/pkg/protocol"
 	"github.com/sentrycloud/sentry/pkg/server/collector"
 	"github.com/sentrycloud/sentry/pkg/server/config"
+	"github.com/sentrycloud/sentry/pkg/server/tail"
 	"github.com/sentrycloud/sentry/pkg/server/web/mysql"
 	"github.com/sentrycloud/sentry/pkg/server/web/tsdb"
 	"log"
@@ -14,7 +17,10 @@ import (
 	"strconv"
 )
 
-var serverCollector *collector.Collector
+var (
+	serverCollector *collector.Collector
+	httpServer      *http.Server
+)
 
 // SPAHandler implements the http.Handler interface, so we can use it
 // to respond to HTTP requests. The path to the static directory and
@@ -54,6 +60,9 @@ func (h SPAHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 func Start(serverConfig *config.ServerConfig, server *collector.Collector) {
 	tsdb.Init(serverConfig.TaosServer)
+	tsdb.InitQueryCache(serverConfig.QueryCache)
+	tsdb.InitRollup(serverConfig.Rollup)
+	tsdb.InitQueryLimit(serverConfig.Query)
 	serverCollector = server
 
 	spaHandler := SPAHandler{staticPath: serverConfig.FrontEndPath, indexPath: "index.html"}
@@ -62,28 +71,61 @@ func Start(serverConfig *config.ServerConfig, server *collector.Collector) {
 	mux.Handle("/", spaHandler)
 
 	mux.HandleFunc(protocol.PutMetricsUrl, putMetricsHandler)
+	mux.HandleFunc(protocol.ImportUrl, importHandler)
+	mux.HandleFunc(protocol.TailUrl, tailHandler)
 
 	mux.HandleFunc(protocol.MetricUrl, tsdb.QueryMetrics)
 	mux.HandleFunc(protocol.TagKeyUrl, tsdb.QueryTagKeys)
 	mux.HandleFunc(protocol.TagValueUrl, tsdb.QueryTagValues)
+	mux.HandleFunc(protocol.TagValueSearchUrl, tsdb.SearchTagValues)
 	mux.HandleFunc(protocol.CurveUrl, tsdb.QueryCurves)
 	mux.HandleFunc(protocol.RangeUrl, tsdb.QueryTimeSeriesDataForRange)
 	mux.HandleFunc(protocol.TopNUrl, tsdb.QueryTopN)
 	mux.HandleFunc(protocol.ChartDataUrl, tsdb.QueryChartData)
+	mux.HandleFunc(protocol.ExportUrl, tsdb.ExportTimeSeriesData)
+	mux.HandleFunc(protocol.RangeExportUrl, tsdb.ExportRangeData)
+	mux.HandleFunc(protocol.ChartDataExportUrl, tsdb.ExportChartData)
+	mux.HandleFunc(protocol.DashboardDataUrl, tsdb.QueryDashboardData)
+	mux.HandleFunc(protocol.CardinalityUrl, tsdb.QueryCardinality)
+	mux.HandleFunc(protocol.CardinalityTagKeysUrl, tsdb.QueryTopTagKeys)
+	mux.HandleFunc(protocol.CardinalityNewSeriesUrl, tsdb.QueryNewSeries)
+
+	mux.HandleFunc(protocol.OpenTSDBQueryUrl, tsdb.QueryOpenTSDB)
+	mux.HandleFunc(protocol.OpenTSDBSuggestUrl, tsdb.SuggestOpenTSDB)
+	mux.HandleFunc(protocol.OpenTSDBLookupUrl, tsdb.LookupOpenTSDB)
+	mux.HandleFunc(protocol.OpenTSDBAggregatorsUrl, tsdb.QueryOpenTSDBAggregators)
+	mux.HandleFunc(protocol.OpenTSDBFiltersUrl, tsdb.QueryOpenTSDBFilters)
+
+	mux.HandleFunc(protocol.PromQueryUrl, tsdb.QueryProm)
+	mux.HandleFunc(protocol.PromQueryRangeUrl, tsdb.QueryPromRange)
+	mux.HandleFunc(protocol.PromLabelsUrl, tsdb.QueryPromLabels)
+	mux.HandleFunc(protocol.PromLabelValuesUrl, tsdb.QueryPromLabelValues)
 
 	mux.HandleFunc(protocol.AlarmRuleUrl, mysql.HandleAlarmRule)
 	mux.HandleFunc(protocol.ContactUrl, mysql.HandleContact)
 	mux.HandleFunc(protocol.MetricWhiteListUrl, mysql.HandleMetricWhiteList)
+	mux.HandleFunc(protocol.MetricMetaUrl, mysql.HandleMetricMeta)
 	mux.HandleFunc(protocol.DashboardUrl, mysql.HandleDashboard)
 	mux.HandleFunc(protocol.ChartUrl, mysql.HandleChart)
 	mux.HandleFunc(protocol.ChartListUrl, mysql.HandleChartList)
 
 	newlog.Info("listen on http port: %d", serverConfig.HttpPort)
+	httpServer = &http.Server{Addr: "0.0.0.0:" + strconv.Itoa(serverConfig.HttpPort), Handler: mux}
 	go func() {
-		log.Fatal(http.ListenAndServe("0.0.0.0:"+strconv.Itoa(serverConfig.HttpPort), mux))
+		err := httpServer.ListenAndServe()
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
 	}()
 }
 
+// Stop the http server, so no more metrics will be put or imported, and wait active requests to complete,
+// live tail requests are notified to leave first, otherwise they never complete
+func Stop() {
+	tail.Close()
+	graceful.ShutdownHttpServer(httpServer, "sentry_server")
+}
+
 // this api is used for collect metrics from sentry-sdk.
 // usually sentry-sdk is connected to sentry-agent to send metrics, but if the machine can't install sentry-agent,
 // sentry-sdk can be configured to send metrics directly to sentry-server
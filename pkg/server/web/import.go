@@ -0,0 +1,71 @@
+package web
+
+import (
+	"errors"
+	"github.com/sentrycloud/sentry/pkg/newlog"
+	"github.com/sentrycloud/sentry/pkg/protocol"
+	"github.com/sentrycloud/sentry/pkg/server/monitor"
+	"io"
+	"net/http"
+	"time"
+)
+
+const ImportBatchSize = 1000 // send data points to merge in batch mode
+
+// importHandler import data points exported by the export api, request body is the exported file,
+// and the format is set by the format query parameter: /server/api/import?format=csv
+func importHandler(w http.ResponseWriter, r *http.Request) {
+	defer monitor.AddMonitorStats(time.Now(), "import")
+
+	if r.Method != "POST" {
+		protocol.MethodNotSupport(w)
+		return
+	}
+
+	format, err := protocol.CheckExportFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		protocol.WriteQueryResp(w, protocol.CodeExportFormatError, nil)
+		return
+	}
+
+	reader, err := protocol.NewImportReader(r.Body, format)
+	if err != nil {
+		newlog.Error("import: create import reader failed: %v", err)
+		protocol.WriteQueryResp(w, protocol.CodeImportDataError, nil)
+		return
+	}
+
+	var result protocol.ImportResult
+	var batch []protocol.MetricValue
+	for {
+		metric, e := reader.Read()
+		if e == io.EOF {
+			break
+		}
+
+		if e != nil {
+			if errors.Is(e, protocol.ErrInvalidImportLine) {
+				result.Total++
+				result.Invalid++
+				continue
+			}
+
+			// data points already in batch are still imported, so return the result with the error code
+			newlog.Error("import: read data failed: %v", e)
+			result.Invalid += serverCollector.BackfillMetrics(batch)
+			protocol.WriteQueryResp(w, protocol.CodeImportDataError, &result)
+			return
+		}
+
+		result.Total++
+		batch = append(batch, *metric)
+		if len(batch) >= ImportBatchSize {
+			result.Invalid += serverCollector.BackfillMetrics(batch)
+			batch = nil
+		}
+	}
+
+	result.Invalid += serverCollector.BackfillMetrics(batch)
+	newlog.Info("import: total=%d, invalid=%d", result.Total, result.Invalid)
+	protocol.WriteQueryResp(w, protocol.CodeOK, &result)
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/sentrycloud/sentry/pkg/dbmodel"
 	"github.com/sentrycloud/sentry/pkg/newlog"
 	"github.com/sentrycloud/sentry/pkg/protocol"
+	"github.com/sentrycloud/sentry/pkg/server/expr"
 	"github.com/sentrycloud/sentry/pkg/server/monitor"
 	"net/http"
 	"strconv"
@@ -240,12 +241,14 @@ func validateChartParam(chart *ChartParams) error {
 		return errors.New("chart type not in line/pie/topN")
 	}
 
-	if chart.Aggregation != "sum" && chart.Aggregation != "avg" && chart.Aggregation != "max" && chart.Aggregation != "min" {
-		return errors.New("chart aggregation not in sum/avg/max/min")
+	aggregation, err := protocol.CheckAggregator(chart.Aggregation)
+	if err != nil {
+		return errors.New("chart aggregation error: " + err.Error())
 	}
+	chart.Aggregation = aggregation
 
-	downSample, err := protocol.TransferDownSample(chart.DownSample)
-	if err != nil || downSample == 0 {
+	_, err = protocol.ParseDownSample(chart.DownSample)
+	if err != nil {
 		return errors.New("wrong down sample format")
 	}
 
@@ -258,6 +261,17 @@ func validateChartParam(chart *ChartParams) error {
 			return errors.New("no name in line")
 		}
 
+		if _, _, err = protocol.ParseOffsets(line.Offset); err != nil {
+			return errors.New("wrong offset format: " + err.Error())
+		}
+
+		if len(line.Expression) > 0 {
+			if _, err = expr.Parse(line.Expression); err != nil {
+				return errors.New("invalid expression: " + err.Error())
+			}
+			continue
+		}
+
 		if len(line.Metric) == 0 {
 			return errors.New("no metric in line")
 		}
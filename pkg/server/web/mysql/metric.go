@@ -1,8 +1,10 @@
 package mysql
 
 import (
+	"errors"
 	"github.com/sentrycloud/sentry/pkg/dbmodel"
 	"github.com/sentrycloud/sentry/pkg/protocol"
+	"github.com/sentrycloud/sentry/pkg/server/metadata"
 	"github.com/sentrycloud/sentry/pkg/server/monitor"
 	"net/http"
 	"time"
@@ -26,3 +28,59 @@ func HandleMetricWhiteList(w http.ResponseWriter, r *http.Request) {
 		protocol.MethodNotSupport(w)
 	}
 }
+
+// HandleMetricMeta query, set or delete metadata of metrics, PUT and POST both add or update the metadata of a metric
+func HandleMetricMeta(w http.ResponseWriter, r *http.Request) {
+	defer monitor.AddMonitorStats(time.Now(), "metricMeta")
+
+	var entity dbmodel.MetricMeta
+	switch r.Method {
+	case "GET":
+		var entities []dbmodel.MetricMeta
+		if err := dbmodel.QueryMetricMetas(&entities); err != nil {
+			protocol.WriteQueryResp(w, protocol.CodeExecMySQLError, nil)
+			return
+		}
+		protocol.WriteQueryResp(w, protocol.CodeOK, entities)
+	case "PUT", "POST":
+		modifyEntity(w, r, saveMetricMeta, &entity)
+	case "DELETE":
+		modifyEntity(w, r, deleteMetricMeta, &entity)
+	default:
+		protocol.MethodNotSupport(w)
+	}
+}
+
+func saveMetricMeta(entity interface{}) error {
+	meta := entity.(*dbmodel.MetricMeta)
+	if len(meta.Metric) == 0 {
+		return errors.New("empty metric")
+	}
+
+	var err error
+	meta.Type, err = protocol.CheckMetricType(meta.Type)
+	if err != nil {
+		return err
+	}
+
+	if err = dbmodel.SaveMetricMeta(meta); err != nil {
+		return err
+	}
+
+	metadata.Set(meta.Metric, protocol.MetricMeta{Unit: meta.Unit, Type: meta.Type, Description: meta.Description, AppName: meta.AppName})
+	return nil
+}
+
+func deleteMetricMeta(entity interface{}) error {
+	meta := entity.(*dbmodel.MetricMeta)
+	if err := dbmodel.GetEntity(meta); err != nil {
+		return err
+	}
+
+	if err := dbmodel.DeleteEntity(meta); err != nil {
+		return err
+	}
+
+	metadata.Remove(meta.Metric)
+	return nil
+}
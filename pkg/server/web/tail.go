@@ -0,0 +1,130 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"github.com/sentrycloud/sentry/pkg/newlog"
+	"github.com/sentrycloud/sentry/pkg/protocol"
+	"github.com/sentrycloud/sentry/pkg/server/tail"
+	"net/http"
+	"time"
+)
+
+const (
+	TailBatchSize         = 100              // data points in an event
+	TailFlushInterval     = time.Second      // buffered data points are sent at least every second
+	TailKeepAliveInterval = 15 * time.Second // comment line is sent if there is no event, so proxies keep the connection
+)
+
+// tailHandler stream data points of ?metric= that match ?tags= (json like {"ip":"10.0.*"}) as server-sent events:
+//
+//	event: points
+//	data: [{"metric":"m","tags":{"ip":"10.0.0.1"},"timestamp":1700000000,"value":1}]
+//
+//	event: dropped
+//	data: {"dropped":10}
+//
+// a dropped event is sent if the client can not keep up, and data points are dropped from its buffer
+func tailHandler(w http.ResponseWriter, r *http.Request) {
+	metric := r.URL.Query().Get("metric")
+	if len(metric) == 0 {
+		protocol.WriteQueryResp(w, protocol.CodeMetricError, nil)
+		return
+	}
+
+	tags := make(map[string]string)
+	if tagsParam := r.URL.Query().Get("tags"); len(tagsParam) > 0 {
+		if err := protocol.Json.UnmarshalFromString(tagsParam, &tags); err != nil {
+			newlog.Error("tail: decode tags failed: %v", err)
+			protocol.WriteQueryResp(w, protocol.CodeJsonDecodeError, nil)
+			return
+		}
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		protocol.WriteQueryResp(w, protocol.CodeInvalidParamError, nil)
+		return
+	}
+
+	subscriber, err := tail.Subscribe(metric, tags)
+	if err != nil {
+		newlog.Warn("tail metric=%s failed: %v", metric, err)
+		protocol.WriteQueryResp(w, protocol.CodeTooManyTailsError, nil)
+		return
+	}
+	defer tail.Unsubscribe(subscriber)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	flushTicker := time.NewTicker(TailFlushInterval)
+	defer flushTicker.Stop()
+
+	batch := make([]protocol.MetricValue, 0, TailBatchSize)
+	lastWrite := time.Now()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-subscriber.Done():
+			return
+		case point := <-subscriber.Points():
+			batch = append(batch, point)
+			if len(batch) < TailBatchSize {
+				continue
+			}
+		case <-flushTicker.C:
+		}
+
+		err = writeTailEvents(w, batch, subscriber.TakeDropped(), time.Since(lastWrite) >= TailKeepAliveInterval)
+		if errors.Is(err, errNoEvent) {
+			continue
+		}
+
+		if err != nil {
+			newlog.Info("tail metric=%s stopped: %v", metric, err)
+			return
+		}
+
+		flusher.Flush()
+		batch = batch[:0]
+		lastWrite = time.Now()
+	}
+}
+
+var errNoEvent = errors.New("no event to write")
+
+// writeTailEvents write a points event and a dropped event if there are any, or a comment line to keep alive
+func writeTailEvents(w http.ResponseWriter, batch []protocol.MetricValue, dropped int64, keepAlive bool) error {
+	if len(batch) == 0 && dropped == 0 {
+		if !keepAlive {
+			return errNoEvent
+		}
+
+		_, err := fmt.Fprint(w, ": keep-alive\n\n")
+		return err
+	}
+
+	if len(batch) > 0 {
+		data, err := protocol.Json.Marshal(batch)
+		if err != nil {
+			return err
+		}
+
+		if _, err = fmt.Fprintf(w, "event: points\ndata: %s\n\n", data); err != nil {
+			return err
+		}
+	}
+
+	if dropped > 0 {
+		if _, err := fmt.Fprintf(w, "event: dropped\ndata: {\"dropped\":%d}\n\n", dropped); err != nil {
+			return err
+		}
+	}
+	return nil
+}
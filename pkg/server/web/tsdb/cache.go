@@ -0,0 +1,267 @@
+package tsdb
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"github.com/sentrycloud/sentry/pkg/protocol"
+	"github.com/sentrycloud/sentry/pkg/server/config"
+	"github.com/sentrycloud/sentry/pkg/server/expr"
+	"github.com/sentrycloud/sentry/pkg/server/monitor"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	cacheDataPointSize = 16  // int64 timestamp and float64 value
+	cacheCurveSize     = 128 // estimated size of curve struct and tags
+)
+
+// queryCacheEntry hold data points of complete intervals in [start, end), start and end are in milliseconds
+type queryCacheEntry struct {
+	key     string
+	start   int64
+	end     int64
+	curves  []*protocol.CurveData
+	size    int64
+	expire  time.Time
+	element *list.Element
+}
+
+// queryCache is a LRU cache for range query results, intervals that are complete will not change,
+// so the next query with the same key only need to query the fresh tail
+type queryCache struct {
+	mu            sync.Mutex
+	entries       map[string]*queryCacheEntry
+	lru           *list.List // front is the most recently used
+	memory        int64
+	maxMemory     int64
+	ttl           time.Duration
+	completeDelay int64 // milliseconds
+
+	hitCount     int64
+	partialCount int64
+	missCount    int64
+}
+
+var rangeCache *queryCache
+
+// InitQueryCache create the range query cache, no cache is used if it's not enabled
+func InitQueryCache(cacheConfig config.QueryCacheConfig) {
+	if !cacheConfig.Enable {
+		rangeCache = nil
+		return
+	}
+	rangeCache = newQueryCache(cacheConfig)
+}
+
+func newQueryCache(cacheConfig config.QueryCacheConfig) *queryCache {
+	return &queryCache{
+		entries:       make(map[string]*queryCacheEntry),
+		lru:           list.New(),
+		maxMemory:     int64(cacheConfig.MaxMemory) * 1024 * 1024,
+		ttl:           time.Duration(cacheConfig.TTL) * time.Second,
+		completeDelay: int64(cacheConfig.CompleteDelay) * 1000,
+	}
+}
+
+// CollectMetrics report cache hit, partial hit, miss count and memory size to monitor
+func CollectMetrics() {
+	c := rangeCache
+	if c == nil {
+		return
+	}
+
+	monitor.QueryCacheHitCollector.Put(float64(atomic.SwapInt64(&c.hitCount, 0)))
+	monitor.QueryCachePartialCollector.Put(float64(atomic.SwapInt64(&c.partialCount, 0)))
+	monitor.QueryCacheMissCollector.Put(float64(atomic.SwapInt64(&c.missCount, 0)))
+
+	c.mu.Lock()
+	memory := c.memory
+	c.mu.Unlock()
+	monitor.QueryCacheMemoryCollector.Put(float64(memory))
+}
+
+// rangeCacheKey normalize the query, so the same query with different tag order has the same key,
+// it must be called before the query, because not equal tags are deleted from tags when building sql
+func rangeCacheKey(queryType string, req *protocol.MetricReq, aggregator string, downSample int64, offset int64) string {
+	var filters []string
+	for k, values := range req.Filters {
+		filters = append(filters, k+"="+strings.Join(values, "||"))
+	}
+	sort.Strings(filters)
+
+	var matchers []string
+	for _, m := range req.Matchers {
+		matchers = append(matchers, m.Key+m.Op+m.Value)
+	}
+	sort.Strings(matchers)
+	return fmt.Sprintf("%s|%s|%s|%s|%s|%s|%d|%d", queryType, req.Metric, curveSignature(req.Tags), strings.Join(filters, ","),
+		strings.Join(matchers, ","), aggregator, downSample, offset)
+}
+
+// cachedQueryCurves query curves in [start, end) with cache, start must be aligned with downSample, otherwise the first
+// interval is not complete. data points are in seconds without offset, as internalQueryRange returns.
+// cache is skipped in debug mode, so all queries of the request are executed and recorded
+func cachedQueryCurves(ctx context.Context, key string, start int64, end int64, offset int64, downSample int64,
+	query func(start int64, end int64) ([]*protocol.CurveData, int)) ([]*protocol.CurveData, int) {
+	c := rangeCache
+	interval := downSample * 1000
+	if c == nil || interval <= 0 || start%interval != 0 || offset%interval != 0 || getQueryDebug(ctx) != nil {
+		return query(start, end)
+	}
+	return c.query(key, start, end, offset, interval, query)
+}
+
+func (c *queryCache) query(key string, start int64, end int64, offset int64, interval int64,
+	query func(start int64, end int64) ([]*protocol.CurveData, int)) ([]*protocol.CurveData, int) {
+	// intervals end before complete will not change any more
+	complete := (time.Now().UnixMilli() - c.completeDelay - offset) / interval * interval
+	if complete > end/interval*interval {
+		complete = end / interval * interval
+	}
+
+	cached, cacheEnd := c.get(key, start, end)
+	if cached == nil {
+		atomic.AddInt64(&c.missCount, 1)
+		curves, code := query(start, end)
+		if code == protocol.CodeOK {
+			c.put(key, start, complete, curves)
+		}
+		return curves, code
+	}
+
+	if cacheEnd >= end {
+		atomic.AddInt64(&c.hitCount, 1)
+		return cached, protocol.CodeOK
+	}
+
+	atomic.AddInt64(&c.partialCount, 1)
+	fresh, code := query(cacheEnd-1, end) // query use _ts > start, so minus 1 to include cacheEnd
+	if code != protocol.CodeOK {
+		return nil, code
+	}
+
+	curves := mergeCachedCurves(cached, fresh)
+	if complete > cacheEnd {
+		c.put(key, start, complete, curves)
+	}
+	return curves, protocol.CodeOK
+}
+
+// get return copy of cached curves in [start, min(end, entry.end)) and the end of the cached range
+func (c *queryCache) get(key string, start int64, end int64) ([]*protocol.CurveData, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, exist := c.entries[key]
+	if !exist {
+		return nil, 0
+	}
+
+	if time.Now().After(entry.expire) {
+		c.remove(entry)
+		return nil, 0
+	}
+
+	if entry.start > start || entry.end <= start {
+		return nil, 0
+	}
+
+	cacheEnd := entry.end
+	if cacheEnd > end {
+		cacheEnd = end
+	}
+	c.lru.MoveToFront(entry.element)
+	return sliceCurves(entry.curves, start, cacheEnd), cacheEnd
+}
+
+// put cache data points of complete intervals in [start, end)
+func (c *queryCache) put(key string, start int64, end int64, curves []*protocol.CurveData) {
+	if end <= start {
+		return
+	}
+
+	cachedCurves := sliceCurves(curves, start, end)
+	var size = int64(len(key))
+	for _, curve := range cachedCurves {
+		size += cacheCurveSize + int64(len(curve.DPS))*cacheDataPointSize
+	}
+
+	if size > c.maxMemory {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if old, exist := c.entries[key]; exist {
+		c.remove(old)
+	}
+
+	entry := &queryCacheEntry{
+		key:    key,
+		start:  start,
+		end:    end,
+		curves: cachedCurves,
+		size:   size,
+		expire: time.Now().Add(c.ttl),
+	}
+	entry.element = c.lru.PushFront(entry)
+	c.entries[key] = entry
+	c.memory += size
+
+	for c.memory > c.maxMemory {
+		c.remove(c.lru.Back().Value.(*queryCacheEntry))
+	}
+}
+
+func (c *queryCache) remove(entry *queryCacheEntry) {
+	c.lru.Remove(entry.element)
+	delete(c.entries, entry.key)
+	c.memory -= entry.size
+}
+
+// sliceCurves copy curves and their tags with data points in [start, end), start and end are in milliseconds
+func sliceCurves(curves []*protocol.CurveData, start int64, end int64) []*protocol.CurveData {
+	var result []*protocol.CurveData
+	for _, curve := range curves {
+		sliced := &protocol.CurveData{Metric: curve.Metric, Tags: copyTags(curve.Tags)}
+		for _, dp := range curve.DPS {
+			if dp.TimeStamp*1000 >= start && dp.TimeStamp*1000 < end {
+				sliced.DPS = append(sliced.DPS, dp)
+			}
+		}
+		result = append(result, sliced)
+	}
+	return result
+}
+
+// mergeCachedCurves append fresh data points to cached curves with the same tags, and add new curves at the end
+func mergeCachedCurves(cached []*protocol.CurveData, fresh []*protocol.CurveData) []*protocol.CurveData {
+	curveMap := make(map[string]*protocol.CurveData)
+	for _, curve := range cached {
+		curveMap[curveSignature(curve.Tags)] = curve
+	}
+
+	for _, curve := range fresh {
+		if old, exist := curveMap[curveSignature(curve.Tags)]; exist {
+			old.DPS = append(old.DPS, curve.DPS...)
+		} else if len(cached) < LineCountLimit {
+			cached = append(cached, curve)
+		}
+	}
+	return cached
+}
+
+func curveSignature(tags map[string]string) string {
+	var keys []string
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return strings.TrimSuffix(expr.TagsSignature(tags, keys), ",")
+}
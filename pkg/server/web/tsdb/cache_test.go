@@ -0,0 +1,71 @@
+package tsdb
+
+import (
+	"context"
+	"github.com/sentrycloud/sentry/pkg/protocol"
+	"github.com/sentrycloud/sentry/pkg/server/config"
+	"testing"
+	"time"
+)
+
+func TestQueryCache(t *testing.T) {
+	rangeCache = newQueryCache(config.QueryCacheConfig{Enable: true, TTL: 60, MaxMemory: 1, CompleteDelay: 0})
+	defer func() { rangeCache = nil }()
+
+	var queryStarts []int64
+	query := func(start int64, end int64) ([]*protocol.CurveData, int) {
+		queryStarts = append(queryStarts, start)
+		curve := &protocol.CurveData{Metric: "m", Tags: map[string]string{"ip": "1"}}
+		for ts := (start + 9999) / 10000 * 10; ts*1000 < end; ts += 10 {
+			curve.DPS = append(curve.DPS, protocol.TimeValuePoint{TimeStamp: ts, Value: float64(ts)})
+		}
+		return []*protocol.CurveData{curve}, protocol.CodeOK
+	}
+
+	now := time.Now().Unix() / 10 * 10
+	start := (now - 600) * 1000
+	end := now * 1000
+	key := rangeCacheKey("range", &protocol.MetricReq{Metric: "m", Tags: map[string]string{"ip": "1"}}, "sum", 10, 0)
+
+	curves, _ := cachedQueryCurves(context.Background(), key, start, end, 0, 10, query)
+	if len(curves) != 1 || len(curves[0].DPS) != 60 {
+		t.Fatalf("first query failed: %v", curves)
+	}
+
+	// the same range is cached completely
+	curves, _ = cachedQueryCurves(context.Background(), key, start, end, 0, 10, query)
+	if len(queryStarts) != 1 || len(curves[0].DPS) != 60 {
+		t.Errorf("expect cache hit, query count=%d, points=%d", len(queryStarts), len(curves[0].DPS))
+	}
+
+	// changing tags of returned curves, like renaming a curve of an expression, does not change the cache
+	curves[0].Tags["ip"] = "2"
+	curves, _ = cachedQueryCurves(context.Background(), key, start, end, 0, 10, query)
+	if len(queryStarts) != 1 || curves[0].Tags["ip"] != "1" {
+		t.Errorf("tags of cached curves should not be changed: %v", curves[0].Tags)
+	}
+
+	// range move forward, only the fresh tail is queried
+	curves, _ = cachedQueryCurves(context.Background(), key, start+60000, end+60000, 0, 10, query)
+	if len(queryStarts) != 2 || queryStarts[1] != end-1 {
+		t.Fatalf("expect query fresh tail, query starts: %v", queryStarts)
+	}
+
+	dps := curves[0].DPS
+	if len(dps) != 60 || dps[0].TimeStamp != now-540 || dps[len(dps)-1].TimeStamp != now+50 {
+		t.Errorf("merge cached curves failed: %v", dps)
+	}
+
+	// unaligned start is not cached
+	cachedQueryCurves(context.Background(), key, start+1, end, 0, 10, query)
+	if len(queryStarts) != 3 {
+		t.Errorf("unaligned start should not use cache")
+	}
+
+	// debug mode execute all queries
+	ctx, debug := withQueryDebug(context.Background(), true)
+	cachedQueryCurves(ctx, key, start, end, 0, 10, query)
+	if len(queryStarts) != 4 || debug.result() == nil {
+		t.Errorf("debug mode should not use cache")
+	}
+}
@@ -0,0 +1,247 @@
+package tsdb
+
+import (
+	"context"
+	"github.com/sentrycloud/sentry/pkg/newlog"
+	"github.com/sentrycloud/sentry/pkg/protocol"
+	"github.com/sentrycloud/sentry/pkg/server/monitor"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	CardinalityScanInterval = 3600 // scan cardinality of all metrics every hour
+	NewTablesHours          = 24   // NewTables of a metric are tables created in the last 24 hours
+	DefaultTopTagKeys       = 20
+	DefaultNewSeriesLimit   = 100
+	MaxNewSeriesLimit       = 1000
+	MaxNewSeriesHours       = 24 * 30
+)
+
+var (
+	cardinalityMu     sync.RWMutex
+	cardinalityReport = &protocol.CardinalityReport{Metrics: []protocol.MetricCardinality{}}
+)
+
+// StartCardinalityScan scan child table count and distinct values of tag keys of all metrics in the background,
+// the scan takes many queries, so the result is cached and queried by the cardinality APIs
+func StartCardinalityScan() {
+	newlog.Info("StartCardinalityScan")
+	for {
+		scanStartTime := time.Now()
+		scanCardinality()
+		scanTotalTime := time.Since(scanStartTime)
+		time.Sleep(CardinalityScanInterval*time.Second - scanTotalTime)
+	}
+}
+
+func scanCardinality() {
+	startTime := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	metrics, code := internalQueryMetrics(ctx, "")
+	cancel()
+	if code != protocol.CodeOK {
+		newlog.Error("scanCardinality: query metrics failed: %s", protocol.CodeMsg[code])
+		return
+	}
+
+	newTablesAfter := startTime.Add(-NewTablesHours * time.Hour).UnixMilli()
+	report := &protocol.CardinalityReport{Metrics: make([]protocol.MetricCardinality, 0, len(metrics))}
+	for _, metric := range metrics {
+		metricCardinality, code := queryMetricCardinality(metric, newTablesAfter)
+		if code != protocol.CodeOK {
+			newlog.Error("scanCardinality: query cardinality of metric=%s failed: %s", metric, protocol.CodeMsg[code])
+			continue
+		}
+		report.Metrics = append(report.Metrics, metricCardinality)
+	}
+
+	sort.SliceStable(report.Metrics, func(i, j int) bool { return report.Metrics[i].Tables > report.Metrics[j].Tables })
+	report.Updated = time.Now().Unix()
+	report.Duration = report.Updated - startTime.Unix()
+
+	cardinalityMu.Lock()
+	cardinalityReport = report
+	cardinalityMu.Unlock()
+	newlog.Info("scanCardinality complete in %d seconds, metrics=%d", report.Duration, len(report.Metrics))
+}
+
+// queryMetricCardinality query cardinality of a metric, each query has its own timeout
+func queryMetricCardinality(metric string, newTablesAfter int64) (protocol.MetricCardinality, int) {
+	result := protocol.MetricCardinality{Metric: metric, Tags: []protocol.TagCardinality{}}
+	var code int
+	if result.Tables, code = queryCount(buildTableCountSql(taosDatabase, metric, 0)); code != protocol.CodeOK {
+		return result, code
+	}
+
+	if result.NewTables, code = queryCount(buildTableCountSql(taosDatabase, metric, newTablesAfter)); code != protocol.CodeOK {
+		return result, code
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	tagKeys, code := internalQueryTagKeys(ctx, metric)
+	cancel()
+	if code != protocol.CodeOK {
+		return result, code
+	}
+
+	for _, key := range tagKeys {
+		values, code := queryCount(buildTagCardinalitySql(metric, key))
+		if code != protocol.CodeOK {
+			return result, code
+		}
+		result.Tags = append(result.Tags, protocol.TagCardinality{Key: key, Values: values})
+	}
+
+	sort.SliceStable(result.Tags, func(i, j int) bool { return result.Tags[i].Values > result.Tags[j].Values })
+	return result, protocol.CodeOK
+}
+
+// queryCount query a sql that returns one row of count
+func queryCount(sql string, err error) (int64, int) {
+	if err != nil {
+		newlog.Error("build count sql failed: %v", err)
+		return 0, protocol.CodeSqlParamError
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	results, err := QueryTSDB(ctx, sql, 1)
+	if err != nil {
+		return 0, queryErrorCode(err)
+	}
+
+	if len(results) == 0 {
+		return 0, protocol.CodeOK
+	}
+
+	count, _ := results[0][0].(int64)
+	return count, protocol.CodeOK
+}
+
+func getCardinalityReport() *protocol.CardinalityReport {
+	cardinalityMu.RLock()
+	defer cardinalityMu.RUnlock()
+
+	return cardinalityReport
+}
+
+// QueryCardinality return the cached cardinality of all metrics, or metrics that contain ?metric=
+func QueryCardinality(w http.ResponseWriter, r *http.Request) {
+	defer monitor.AddMonitorStats(time.Now(), "cardinality")
+
+	report := getCardinalityReport()
+	name := r.URL.Query().Get("metric")
+	if len(name) == 0 {
+		protocol.WriteQueryResp(w, protocol.CodeOK, report)
+		return
+	}
+
+	filtered := &protocol.CardinalityReport{Updated: report.Updated, Duration: report.Duration, Metrics: []protocol.MetricCardinality{}}
+	for _, m := range report.Metrics {
+		if strings.Contains(m.Metric, name) {
+			filtered.Metrics = append(filtered.Metrics, m)
+		}
+	}
+	protocol.WriteQueryResp(w, protocol.CodeOK, filtered)
+}
+
+// QueryTopTagKeys return ?limit= tag keys of all metrics with the most distinct values in the cached cardinality
+func QueryTopTagKeys(w http.ResponseWriter, r *http.Request) {
+	defer monitor.AddMonitorStats(time.Now(), "cardinalityTagKeys")
+
+	limit := queryIntParam(r, "limit", DefaultTopTagKeys)
+	tagKeys := []protocol.TagKeyCardinality{}
+	for _, m := range getCardinalityReport().Metrics {
+		for _, tag := range m.Tags {
+			tagKeys = append(tagKeys, protocol.TagKeyCardinality{Metric: m.Metric, Key: tag.Key, Values: tag.Values})
+		}
+	}
+
+	sort.SliceStable(tagKeys, func(i, j int) bool { return tagKeys[i].Values > tagKeys[j].Values })
+	if len(tagKeys) > limit {
+		tagKeys = tagKeys[:limit]
+	}
+	protocol.WriteQueryResp(w, protocol.CodeOK, tagKeys)
+}
+
+// internalQueryNewSeries query the latest series of a metric created in the last hours, the newest first
+func internalQueryNewSeries(ctx context.Context, metric string, hours int, limit int) ([]protocol.NewSeries, int) {
+	createdAfter := time.Now().Add(-time.Duration(hours) * time.Hour).UnixMilli()
+	sql, err := buildNewTablesSql(taosDatabase, metric, createdAfter, limit)
+	if err != nil {
+		newlog.Error("queryNewSeries: build sql failed: %v", err)
+		return nil, protocol.CodeSqlParamError
+	}
+
+	results, err := QueryTSDB(ctx, sql, 2)
+	if err != nil {
+		return nil, queryErrorCode(err)
+	}
+
+	series := make([]protocol.NewSeries, 0, len(results))
+	index := make(map[string]int)
+	for _, row := range results {
+		table, _ := row[0].(string)
+		created, _ := row[1].(int64)
+		index[table] = len(series)
+		series = append(series, protocol.NewSeries{Table: table, Created: created, Tags: make(map[string]string)})
+	}
+
+	if len(series) == 0 {
+		return series, protocol.CodeOK
+	}
+
+	tables := make([]string, 0, len(series))
+	for _, s := range series {
+		tables = append(tables, s.Table)
+	}
+
+	sql, err = buildTableTagsSql(taosDatabase, metric, tables)
+	if err != nil {
+		newlog.Error("queryNewSeries: build sql failed: %v", err)
+		return nil, protocol.CodeSqlParamError
+	}
+
+	results, err = QueryTSDB(ctx, sql, 3)
+	if err != nil {
+		return nil, queryErrorCode(err)
+	}
+
+	for _, row := range results {
+		table, _ := row[0].(string)
+		key, _ := row[1].(string)
+		value, ok := row[2].(string)
+		if i, exist := index[table]; exist && ok {
+			series[i].Tags[key] = value // tag_value is NULL if the series does not have the tag key
+		}
+	}
+	return series, protocol.CodeOK
+}
+
+// QueryNewSeries return series of ?metric= created in the last ?hours=, it's queried from TDengine directly
+func QueryNewSeries(w http.ResponseWriter, r *http.Request) {
+	defer monitor.AddMonitorStats(time.Now(), "cardinalityNewSeries")
+	ctx, cancel := queryContext(r)
+	defer cancel()
+
+	metric := r.URL.Query().Get("metric")
+	if len(metric) == 0 {
+		protocol.WriteQueryResp(w, protocol.CodeMetricError, nil)
+		return
+	}
+
+	hours := queryIntParam(r, "hours", NewTablesHours)
+	limit := queryIntParam(r, "limit", DefaultNewSeriesLimit)
+	if hours > MaxNewSeriesHours || limit > MaxNewSeriesLimit {
+		protocol.WriteQueryResp(w, protocol.CodeInvalidParamError, nil)
+		return
+	}
+
+	series, code := internalQueryNewSeries(ctx, metric, hours, limit)
+	protocol.WriteQueryResp(w, code, series)
+}
@@ -1,130 +1,271 @@
 package tsdb
 
 import (
+	"context"
+	"fmt"
 	"github.com/sentrycloud/sentry/pkg/dbmodel"
 	"github.com/sentrycloud/sentry/pkg/newlog"
 	"github.com/sentrycloud/sentry/pkg/protocol"
+	"github.com/sentrycloud/sentry/pkg/server/expr"
+	"github.com/sentrycloud/sentry/pkg/server/metadata"
 	"github.com/sentrycloud/sentry/pkg/server/monitor"
 	"net/http"
 	"strings"
 	"time"
 )
 
-const OneDayMilliseconds = 3600 * 24 * 1000
 const LineCountLimit = 100 // limit the max line count that a query can take
 
 type ChartDataReq struct {
 	dbmodel.Chart
-	Start  int64             `json:"start"`
-	End    int64             `json:"end"`
-	Filter map[string]string `json:"filter"`
+	Start     int64             `json:"start"`
+	End       int64             `json:"end"`
+	Filter    map[string]string `json:"filter"`
+	Fill      string            `json:"fill"`       // none, null, zero, previous or linear for missing intervals
+	MaxPoints int               `json:"max_points"` // max data points of a line, down sample is increased if needed
+
+	PercentChange bool   `json:"percent_change"` // add percent change curves of lines with multiple offsets
+	Smooth        string `json:"smooth"`         // moving_avg, ewma, moving_median or cumsum applied to all lines
+	SmoothWindow  string `json:"smooth_window"`  // number of data points like "10", or duration like "5m"
+	TopnOthers    bool   `json:"topn_others"`    // add the remainder beyond topn_limit as "others" for topN chart
+	Debug         bool   `json:"debug"`          // return sql, latency, curves and dropped filters with the data
 }
 
 type ChartData struct {
 	*protocol.CurveData
-	Name string `json:"name"`
+	Name          string `json:"name"`
+	Offset        string `json:"offset,omitempty"`         // offset of the line that the curve is queried with
+	PercentChange bool   `json:"percent_change,omitempty"` // percent change from the curve of the offset to the first offset
 }
 
-func QueryChartData(w http.ResponseWriter, r *http.Request) {
-	defer monitor.AddMonitorStats(time.Now(), "chartData")
+// checkChartDataReq check the request and query lines of the chart, return the down sample to use
+func checkChartDataReq(chartDataReq *ChartDataReq) (int64, []dbmodel.Line, int) {
+	downSample, code := checkChartDataParam(chartDataReq)
+	if code != protocol.CodeOK {
+		return 0, nil, code
+	}
 
-	var chartDataReq ChartDataReq
-	err := protocol.DecodeRequest(r, &chartDataReq)
+	lines, err := dbmodel.QueryChatLines(chartDataReq.ID)
 	if err != nil {
-		protocol.WriteQueryResp(w, protocol.CodeJsonDecodeError, nil)
-		return
+		newlog.Error("query mysql failed: %v", err)
+		return 0, nil, protocol.CodeExecMySQLError
 	}
+	return downSample, lines, protocol.CodeOK
+}
 
-	downSample, err := protocol.TransferDownSample(chartDataReq.DownSample)
-	if err != nil || downSample == 0 {
+// checkChartDataParam check and normalize parameters of the request, return the down sample to use
+func checkChartDataParam(chartDataReq *ChartDataReq) (int64, int) {
+	downSample, err := protocol.ParseDownSample(chartDataReq.DownSample)
+	if err != nil {
 		newlog.Error("parse downSample failed: %v", err)
-		protocol.WriteQueryResp(w, protocol.CodeDownSampleError, nil)
-		return
+		return 0, protocol.CodeDownSampleError
 	}
 
-	lines, err := dbmodel.QueryChatLines(chartDataReq.ID)
+	if downSample == protocol.DownSampleAuto || chartDataReq.MaxPoints > 0 {
+		downSample = protocol.ChooseDownSample(chartDataReq.End-chartDataReq.Start, chartDataReq.MaxPoints, downSample)
+	}
+
+	chartDataReq.Fill, err = protocol.CheckFill(chartDataReq.Fill)
 	if err != nil {
-		newlog.Error("query mysql failed: %v", err)
-		protocol.WriteQueryResp(w, protocol.CodeExecMySQLError, nil)
-		return
+		newlog.Error("check fill failed: %v", err)
+		return 0, protocol.CodeFillError
 	}
 
-	if chartDataReq.Type == "topN" {
-		if len(lines) != 1 {
-			newlog.Error("topN can have one line only")
-			protocol.WriteQueryResp(w, protocol.CodeInvalidParamError, nil)
-			return
+	chartDataReq.Smooth, _, err = protocol.CheckSmooth(chartDataReq.Smooth, chartDataReq.SmoothWindow)
+	if err != nil {
+		newlog.Error("check smooth failed: %v", err)
+		return 0, protocol.CodeSmoothError
+	}
+
+	chartDataReq.Aggregation, err = protocol.CheckAggregator(chartDataReq.Aggregation)
+	if err != nil {
+		newlog.Error("check aggregation failed: %v", err)
+		return 0, protocol.CodeAggregatorError
+	}
+	return downSample, protocol.CodeOK
+}
+
+func internalQueryChartTopN(ctx context.Context, chartDataReq *ChartDataReq, lines []dbmodel.Line, downSample int64) ([]protocol.TopNData, int) {
+	if len(lines) != 1 || len(lines[0].Expression) > 0 {
+		newlog.Error("topN can have one metric line only")
+		return nil, protocol.CodeInvalidParamError
+	}
+
+	var tags = map[string]string{}
+	err := protocol.Json.UnmarshalFromString(lines[0].Tags, &tags)
+	if err != nil {
+		newlog.Error("unmarshal tags failed for charId=%d, lineId=%d, tags=%s", chartDataReq.ID, lines[0].ID, lines[0].Tags)
+		return nil, protocol.CodeJsonDecodeError
+	}
+
+	req := protocol.TopNRequest{
+		Start:      chartDataReq.Start,
+		End:        chartDataReq.End,
+		Aggregator: chartDataReq.Aggregation,
+		DownSample: downSample,
+		Limit:      chartDataReq.TopnLimit,
+		Order:      "desc",
+		Metric:     lines[0].Metric,
+		Tags:       filterTags(ctx, lines[0].Metric, tags, chartDataReq.Filter),
+		Others:     chartDataReq.TopnOthers,
+	}
+
+	return internalQueryTopN(ctx, &req)
+}
+
+// queryLineCurves query curves of a line with the offset in milliseconds, and name the curves
+func queryLineCurves(ctx context.Context, chartDataReq *ChartDataReq, line *dbmodel.Line, offset int64, downSample int64) ([]ChartData, int) {
+	var chartDataList []ChartData
+	if len(line.Expression) > 0 {
+		fetch := newExpressionFetcher(ctx, chartDataReq.Start, chartDataReq.End, offset, chartDataReq.Aggregation, downSample, chartDataReq.Filter)
+		curveDataList, code := queryExpression(line.Expression, fetch)
+		if code != protocol.CodeOK {
+			return nil, code
 		}
 
-		var tags = map[string]string{}
-		err = protocol.Json.UnmarshalFromString(lines[0].Tags, &tags)
-		if err != nil {
-			newlog.Error("unmarshal tags failed for charId=%d, lineId=%d, tags=%s", chartDataReq.ID, lines[0].ID, lines[0].Tags)
-			protocol.WriteQueryResp(w, protocol.CodeJsonDecodeError, nil)
-			return
+		for _, curveData := range curveDataList {
+			chartData := ChartData{
+				CurveData: curveData,
+				Name:      getExpressionLineName(line.Name, len(curveDataList), curveData.Tags),
+			}
+			chartDataList = append(chartDataList, chartData)
 		}
+		return chartDataList, protocol.CodeOK
+	}
+
+	var tags = map[string]string{}
+	err := protocol.Json.UnmarshalFromString(line.Tags, &tags)
+	if err != nil {
+		newlog.Error("unmarshal tags failed for charId=%d, lineId=%d, tags=%s", chartDataReq.ID, line.ID, line.Tags)
+		return nil, protocol.CodeJsonDecodeError
+	}
+
+	metricReq := protocol.MetricReq{
+		Metric: line.Metric,
+		Tags:   filterTags(ctx, line.Metric, tags, chartDataReq.Filter),
+	}
+
+	// all curves of the line are queried in one query
+	curveDataList, code := internalQueryGroupRange(ctx, chartDataReq.Start, chartDataReq.End, offset, chartDataReq.Aggregation, downSample, &metricReq)
+	if code != protocol.CodeOK {
+		return nil, code
+	}
 
-		req := protocol.TopNRequest{
-			Start:      chartDataReq.Start,
-			End:        chartDataReq.End,
-			Aggregator: chartDataReq.Aggregation,
-			DownSample: downSample,
-			Limit:      chartDataReq.TopnLimit,
-			Order:      "desc",
-			Metric:     lines[0].Metric,
-			Tags:       filterTags(lines[0].Metric, tags, chartDataReq.Filter),
+	for _, curveData := range curveDataList {
+		lineName := getLineName(line.Name, len(curveDataList), tags, curveData.Tags)
+		chartData := ChartData{
+			CurveData: curveData,
+			Name:      lineName,
 		}
 
-		topNDataList, code := internalQueryTopN(&req)
-		protocol.WriteQueryResp(w, code, topNDataList)
-	} else {
-		chartDataReq.Start *= 1000 // transfer to milliseconds
-		chartDataReq.End *= 1000
-		var chartDataList []ChartData
-		for _, line := range lines {
-			var tags = map[string]string{}
-			err = protocol.Json.UnmarshalFromString(line.Tags, &tags)
-			if err != nil {
-				newlog.Error("unmarshal tags failed for charId=%d, lineId=%d, tags=%s", chartDataReq.ID, line.ID, line.Tags)
+		chartDataList = append(chartDataList, chartData)
+	}
+	return chartDataList, protocol.CodeOK
+}
+
+// percentChangeCurves calculate percent change from curves of an offset to curves of the first offset with the same tags
+func percentChangeCurves(base []ChartData, compared []ChartData, offsetName string) []ChartData {
+	var result []ChartData
+	for _, c := range compared {
+		for _, b := range base {
+			if curveName(b.CurveData) != curveName(c.CurveData) {
 				continue
 			}
 
-			metricReq := protocol.MetricReq{
-				Metric: line.Metric,
-				Tags:   filterTags(line.Metric, tags, chartDataReq.Filter),
-			}
+			curveData := &protocol.CurveData{Metric: b.Metric, Tags: b.Tags, DPS: protocol.PercentChange(b.DPS, c.DPS)}
+			result = append(result, ChartData{CurveData: curveData, Name: b.Name + " vs " + offsetName + " %",
+				Offset: offsetName, PercentChange: true})
+			break
+		}
+	}
+	return result
+}
 
-			curveList, code := internalQueryCurves(&metricReq)
-			if code != protocol.CodeOK || curveList == nil {
-				continue
-			}
+// internalQueryChartData query curves of all lines in a chart that is not topN,
+// start and end of the request are transferred to milliseconds
+func internalQueryChartData(ctx context.Context, chartDataReq *ChartDataReq, lines []dbmodel.Line, downSample int64) ([]ChartData, int) {
+	// align start to down sample and transfer to milliseconds, so complete intervals can be cached
+	chartDataReq.Start = chartDataReq.Start / downSample * downSample * 1000
+	chartDataReq.End *= 1000
+	debug := getQueryDebug(ctx)
+	debug.setAlignment(chartDataReq.Start, chartDataReq.End, downSample)
+	_, window, _ := protocol.CheckSmooth(chartDataReq.Smooth, chartDataReq.SmoothWindow)
+	var chartDataList []ChartData
+	for i := range lines {
+		offsetNames, offsets, err := protocol.ParseOffsets(lines[i].Offset)
+		if err != nil {
+			newlog.Error("parse offset failed for chartId=%d, lineId=%d: %v", chartDataReq.ID, lines[i].ID, err)
+			debug.addError(fmt.Sprintf("lineId=%d: parse offset failed: %v", lines[i].ID, err))
+			continue
+		}
 
-			if len(curveList) > LineCountLimit {
-				// do not query too many line once, that maybe hog too much resource
-				curveList = curveList[:LineCountLimit]
+		// the line is queried for each offset, curves of other offsets are compared with the first offset
+		var base []ChartData
+		for j, offset := range offsets {
+			lineDataList, code := queryLineCurves(ctx, chartDataReq, &lines[i], offset, downSample)
+			if isAbortCode(code) {
+				return nil, code
+			} else if code != protocol.CodeOK {
+				debug.addError(fmt.Sprintf("lineId=%d, offset=%s: %s", lines[i].ID, offsetNames[j], protocol.CodeMsg[code]))
+				continue // query error still return success
 			}
 
-			for _, curve := range curveList {
-				m := protocol.MetricReq{
-					Metric: line.Metric,
-					Tags:   curve,
-				}
-				offset := int64(line.Offset * OneDayMilliseconds)
-				curveData, retCode := internalQueryRange(chartDataReq.Start, chartDataReq.End, offset, chartDataReq.Aggregation, downSample, &m)
-				if retCode != protocol.CodeOK {
-					continue // query error still return success
-				}
-
-				lineName := getLineName(line.Name, len(curveList), tags, curve)
-				chartData := ChartData{
-					CurveData: curveData,
-					Name:      lineName,
+			for k := range lineDataList {
+				alignDataPoints(lineDataList[k].DPS, downSample) // percent change match data points by timestamp
+				expr.ApplySmoothFunction(chartDataReq.Smooth, window, []*protocol.CurveData{lineDataList[k].CurveData})
+				if offset != 0 {
+					lineDataList[k].Offset = offsetNames[j]
+					if len(offsets) > 1 {
+						lineDataList[k].Name += " (" + offsetNames[j] + ")"
+					}
 				}
+			}
 
-				chartDataList = append(chartDataList, chartData)
+			chartDataList = append(chartDataList, lineDataList...)
+			if j == 0 {
+				base = lineDataList
+			} else if chartDataReq.PercentChange {
+				chartDataList = append(chartDataList, percentChangeCurves(base, lineDataList, offsetNames[j])...)
 			}
 		}
-		protocol.WriteQueryResp(w, protocol.CodeOK, chartDataList)
+	}
+
+	for _, chartData := range chartDataList {
+		chartData.DPS = protocol.FillDataPoints(chartData.DPS, chartDataReq.Start, chartDataReq.End, downSample, chartDataReq.Fill)
+		chartData.DownSample = downSample
+		if !chartData.PercentChange {
+			chartData.Meta = metadata.Get(chartData.Metric)
+		}
+		debug.addCurve(chartData.CurveData)
+	}
+	return chartDataList, protocol.CodeOK
+}
+
+func QueryChartData(w http.ResponseWriter, r *http.Request) {
+	defer monitor.AddMonitorStats(time.Now(), "chartData")
+	ctx, cancel := queryContext(r)
+	defer cancel()
+
+	var chartDataReq ChartDataReq
+	err := protocol.DecodeRequest(r, &chartDataReq)
+	if err != nil {
+		protocol.WriteQueryResp(w, protocol.CodeJsonDecodeError, nil)
+		return
+	}
+
+	downSample, lines, code := checkChartDataReq(&chartDataReq)
+	if code != protocol.CodeOK {
+		protocol.WriteQueryResp(w, code, nil)
+		return
+	}
+
+	ctx, debug := withQueryDebug(ctx, chartDataReq.Debug)
+	if chartDataReq.Type == "topN" {
+		topNDataList, code := internalQueryChartTopN(ctx, &chartDataReq, lines, downSample)
+		protocol.WriteDebugQueryResp(w, code, topNDataList, debug.result())
+	} else {
+		chartDataList, code := internalQueryChartData(ctx, &chartDataReq, lines, downSample)
+		protocol.WriteDebugQueryResp(w, code, chartDataList, debug.result())
 	}
 }
 
@@ -147,19 +288,25 @@ func getLineName(lineName string, curveCount int, tags, curve map[string]string)
 	return lineName
 }
 
-func filterTags(metric string, tags map[string]string, filter map[string]string) map[string]string {
+func filterTags(ctx context.Context, metric string, tags map[string]string, filter map[string]string) map[string]string {
 	if filter == nil || len(filter) == 0 {
 		return tags
 	}
 
-	metricTagKeys, code := internalQueryTagKeys(metric)
+	debug := getQueryDebug(ctx)
+	metricTagKeys, code := queryMetricTagKeys(ctx, metric)
 	if code != protocol.CodeOK {
+		for _, k := range sortedKeys(filter) {
+			debug.addDroppedFilter(metric, k, filter[k])
+		}
 		return tags
 	}
 
-	for k, v := range filter {
+	for _, k := range sortedKeys(filter) {
 		if contains(metricTagKeys, k) {
-			tags[k] = v
+			tags[k] = filter[k]
+		} else {
+			debug.addDroppedFilter(metric, k, filter[k])
 		}
 	}
 
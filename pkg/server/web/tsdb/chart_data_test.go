@@ -0,0 +1,28 @@
+package tsdb
+
+import (
+	"github.com/sentrycloud/sentry/pkg/protocol"
+	"testing"
+)
+
+func TestPercentChangeCurvesNotAligned(t *testing.T) {
+	tags := map[string]string{"ip": "1"}
+	base := []ChartData{{Name: "m", CurveData: &protocol.CurveData{Metric: "m", Tags: tags,
+		DPS: []protocol.TimeValuePoint{{TimeStamp: 12, Value: 110}, {TimeStamp: 23, Value: 90}, {TimeStamp: 31, Value: 50}}}}}
+	compared := []ChartData{{Name: "m", CurveData: &protocol.CurveData{Metric: "m", Tags: tags,
+		DPS: []protocol.TimeValuePoint{{TimeStamp: 17, Value: 100}, {TimeStamp: 20, Value: 100}, {TimeStamp: 39, Value: -100}}}}}
+
+	for _, curves := range [][]ChartData{base, compared} {
+		alignDataPoints(curves[0].DPS, 10)
+	}
+
+	result := percentChangeCurves(base, compared, "-1d")
+	if len(result) != 1 || result[0].Name != "m vs -1d %" || !result[0].PercentChange {
+		t.Fatalf("expect one percent change curve, got %v", result)
+	}
+
+	expect := []protocol.TimeValuePoint{{TimeStamp: 10, Value: 10}, {TimeStamp: 20, Value: -10}, {TimeStamp: 30, Value: 150}}
+	if !equalPoints(result[0].DPS, expect) {
+		t.Errorf("expect %v, got %v", expect, result[0].DPS)
+	}
+}
@@ -0,0 +1,144 @@
+package tsdb
+
+import (
+	"fmt"
+	"github.com/sentrycloud/sentry/pkg/newlog"
+	"github.com/sentrycloud/sentry/pkg/protocol"
+	"github.com/sentrycloud/sentry/pkg/server/monitor"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// exportFormat get the export format from url query, such as: /server/api/range/export?format=csv
+func exportFormat(r *http.Request) (string, int) {
+	format, err := protocol.CheckExportFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		newlog.Error("check export format failed: %v", err)
+		return "", protocol.CodeExportFormatError
+	}
+	return format, protocol.CodeOK
+}
+
+// writeSeriesExport write curves as a csv or newline delimited json file,
+// csv has a timestamp column and one column per curve, json has one data point per line
+func writeSeriesExport(w http.ResponseWriter, format string, filename string, names []string, curves []*protocol.CurveData) {
+	contentType := "application/x-ndjson"
+	if format == protocol.ExportFormatCsv {
+		contentType = "text/csv"
+	}
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.%s\"", filename, format))
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	seriesWriter := protocol.NewSeriesExportWriter(w, format, ExportFlushRows, func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	})
+
+	if err := seriesWriter.Write(names, curves); err != nil {
+		// http status is already sent, the client will get a truncated file
+		newlog.Error("export %s failed: %v", filename, err)
+	}
+}
+
+// curveName name a curve by metric and sorted tags, like: metric{k1=v1,k2=v2}
+func curveName(curve *protocol.CurveData) string {
+	if len(curve.Tags) == 0 {
+		return curve.Metric
+	}
+
+	var pairs []string
+	for _, k := range sortedKeys(curve.Tags) {
+		pairs = append(pairs, k+"="+curve.Tags[k])
+	}
+	return curve.Metric + "{" + strings.Join(pairs, ",") + "}"
+}
+
+// ExportRangeData export curves of a TimeSeriesDataRequest, the range can be up to MaxExportRange
+func ExportRangeData(w http.ResponseWriter, r *http.Request) {
+	defer monitor.AddMonitorStats(time.Now(), "rangeExport")
+
+	format, code := exportFormat(r)
+	if code != protocol.CodeOK {
+		protocol.WriteQueryResp(w, code, nil)
+		return
+	}
+
+	var req protocol.TimeSeriesDataRequest
+	err := protocol.DecodeRequest(r, &req)
+	if err != nil {
+		protocol.WriteQueryResp(w, protocol.CodeJsonDecodeError, nil)
+		return
+	}
+
+	code = transferTimeSeriesDataRequest(&req, true)
+	if code != protocol.CodeOK {
+		newlog.Error("exportRangeData: transferTimeSeriesDataRequest failed: %s", protocol.CodeMsg[code])
+		protocol.WriteQueryResp(w, code, nil)
+		return
+	}
+
+	// export can take longer than the query timeout, it's canceled when the client disconnects
+	curves, code := internalQueryTimeSeriesData(r.Context(), &req)
+	if code != protocol.CodeOK {
+		protocol.WriteQueryResp(w, code, nil)
+		return
+	}
+
+	names := make([]string, len(curves))
+	for i, curve := range curves {
+		names[i] = curveName(curve)
+	}
+	writeSeriesExport(w, format, "range", names, curves)
+}
+
+// ExportChartData export lines of a chart with the same parameters as QueryChartData, topN chart is not supported
+func ExportChartData(w http.ResponseWriter, r *http.Request) {
+	defer monitor.AddMonitorStats(time.Now(), "chartExport")
+
+	format, code := exportFormat(r)
+	if code != protocol.CodeOK {
+		protocol.WriteQueryResp(w, code, nil)
+		return
+	}
+
+	var chartDataReq ChartDataReq
+	err := protocol.DecodeRequest(r, &chartDataReq)
+	if err != nil {
+		protocol.WriteQueryResp(w, protocol.CodeJsonDecodeError, nil)
+		return
+	}
+
+	downSample, lines, code := checkChartDataReq(&chartDataReq)
+	if code != protocol.CodeOK {
+		protocol.WriteQueryResp(w, code, nil)
+		return
+	}
+
+	if chartDataReq.Type == "topN" {
+		newlog.Error("exportChartData: topN chart can not be exported, chartId=%d", chartDataReq.ID)
+		protocol.WriteQueryResp(w, protocol.CodeInvalidParamError, nil)
+		return
+	}
+
+	chartDataList, code := internalQueryChartData(r.Context(), &chartDataReq, lines, downSample)
+	if code != protocol.CodeOK {
+		protocol.WriteQueryResp(w, code, nil)
+		return
+	}
+
+	names := make([]string, len(chartDataList))
+	curves := make([]*protocol.CurveData, len(chartDataList))
+	for i, chartData := range chartDataList {
+		names[i] = chartData.Name
+		if len(names[i]) == 0 {
+			names[i] = curveName(chartData.CurveData)
+		}
+		curves[i] = chartData.CurveData
+	}
+	writeSeriesExport(w, format, fmt.Sprintf("chart_%d", chartDataReq.ID), names, curves)
+}
@@ -1,6 +1,7 @@
 package tsdb
 
 import (
+	"context"
 	"github.com/sentrycloud/sentry/pkg/newlog"
 	"github.com/sentrycloud/sentry/pkg/protocol"
 	"github.com/sentrycloud/sentry/pkg/server/monitor"
@@ -8,23 +9,33 @@ import (
 	"time"
 )
 
-func internalQueryCurves(req *protocol.MetricReq) ([]map[string]string, int) {
+func internalQueryCurves(ctx context.Context, req *protocol.MetricReq) ([]map[string]string, int) {
 	starTags, noStarTags, err := splitTags(req.Tags)
 	if err != nil {
 		newlog.Error("queryCurves: splitTags failed: %v", err)
 		return nil, protocol.CodeSplitTagsError
 	}
 
+	if err = protocol.CheckMatchers(req.Matchers); err != nil {
+		newlog.Error("queryCurves: check matchers failed: %v", err)
+		return nil, protocol.CodeTagMatcherError
+	}
+
 	var curveList []map[string]string
 	if len(starTags) == 0 {
 		curveList = append(curveList, noStarTags)
 		return curveList, protocol.CodeOK
 	}
 
-	sql, starKeys := buildCurvesRequest(req.Metric, noStarTags, starTags)
-	results, err := QueryTSDB(sql, len(starKeys))
+	sql, starKeys, err := buildCurvesRequest(req.Metric, noStarTags, starTags, req.Matchers)
+	if err != nil {
+		newlog.Error("queryCurves: build sql failed: %v", err)
+		return nil, protocol.CodeSqlParamError
+	}
+
+	results, err := QueryTSDB(ctx, sql, len(starKeys))
 	if err != nil {
-		return nil, protocol.CodeExecTSDBSqlError
+		return nil, queryErrorCode(err)
 	}
 
 	for _, row := range results {
@@ -45,6 +56,8 @@ func internalQueryCurves(req *protocol.MetricReq) ([]map[string]string, int) {
 
 func QueryCurves(w http.ResponseWriter, r *http.Request) {
 	defer monitor.AddMonitorStats(time.Now(), "curve")
+	ctx, cancel := queryContext(r)
+	defer cancel()
 	
 	var req protocol.MetricReq
 	err := protocol.DecodeRequest(r, &req)
@@ -54,6 +67,6 @@ func QueryCurves(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	curveList, code := internalQueryCurves(&req)
+	curveList, code := internalQueryCurves(ctx, &req)
 	protocol.WriteQueryResp(w, code, curveList)
 }
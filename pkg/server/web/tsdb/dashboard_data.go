@@ -0,0 +1,167 @@
+package tsdb
+
+import (
+	"context"
+	"github.com/sentrycloud/sentry/pkg/dbmodel"
+	"github.com/sentrycloud/sentry/pkg/newlog"
+	"github.com/sentrycloud/sentry/pkg/protocol"
+	"github.com/sentrycloud/sentry/pkg/server/monitor"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DashboardDataReq query data of all charts in a dashboard, the time range and filter are shared by all charts
+type DashboardDataReq struct {
+	ID        uint32            `json:"id"` // dashboard id
+	Start     int64             `json:"start"`
+	End       int64             `json:"end"`
+	Filter    map[string]string `json:"filter"`
+	Fill      string            `json:"fill"`
+	MaxPoints int               `json:"max_points"`
+}
+
+// DashboardChartData is the result of a chart, a failed chart does not fail other charts
+type DashboardChartData struct {
+	Code int         `json:"code"`
+	Msg  string      `json:"msg"`
+	Data interface{} `json:"data"` // []ChartData, or []protocol.TopNData for topN chart
+}
+
+type tagKeysCacheKey struct{}
+
+type tagKeysEntry struct {
+	once sync.Once
+	keys []string
+	code int
+}
+
+// tagKeysCache share tag keys of metrics between charts of a request, so each metric is described once
+type tagKeysCache struct {
+	mu      sync.Mutex
+	entries map[string]*tagKeysEntry
+}
+
+func withTagKeysCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, tagKeysCacheKey{}, &tagKeysCache{entries: make(map[string]*tagKeysEntry)})
+}
+
+// queryMetricTagKeys query tag keys of the metric, use the tag keys cache of the request if it has one
+func queryMetricTagKeys(ctx context.Context, metric string) ([]string, int) {
+	c, _ := ctx.Value(tagKeysCacheKey{}).(*tagKeysCache)
+	if c == nil {
+		return internalQueryTagKeys(ctx, metric)
+	}
+
+	c.mu.Lock()
+	entry, exist := c.entries[metric]
+	if !exist {
+		entry = &tagKeysEntry{}
+		c.entries[metric] = entry
+	}
+	c.mu.Unlock()
+
+	entry.once.Do(func() {
+		entry.keys, entry.code = internalQueryTagKeys(ctx, metric)
+	})
+	return entry.keys, entry.code
+}
+
+// queryDashboardChart query data of a chart with the chart config saved in MySQL
+func queryDashboardChart(ctx context.Context, req *DashboardDataReq, chart dbmodel.Chart, lines []dbmodel.Line) (interface{}, int) {
+	chartDataReq := ChartDataReq{
+		Chart:     chart,
+		Start:     req.Start,
+		End:       req.End,
+		Filter:    req.Filter,
+		Fill:      req.Fill,
+		MaxPoints: req.MaxPoints,
+	}
+
+	downSample, code := checkChartDataParam(&chartDataReq)
+	if code != protocol.CodeOK {
+		return nil, code
+	}
+
+	if chart.Type == "topN" {
+		return internalQueryChartTopN(ctx, &chartDataReq, lines, downSample)
+	}
+	return internalQueryChartData(ctx, &chartDataReq, lines, downSample)
+}
+
+// internalQueryDashboardData query all charts of the dashboard by at most dashboardWorkers goroutines,
+// lines of all charts are queried from MySQL in one query
+func internalQueryDashboardData(ctx context.Context, req *DashboardDataReq) (map[uint32]*DashboardChartData, int) {
+	charts, err := dbmodel.QueryDashboardCharts(req.ID)
+	if err != nil {
+		newlog.Error("query charts of dashboardId=%d failed: %v", req.ID, err)
+		return nil, protocol.CodeExecMySQLError
+	}
+
+	result := make(map[uint32]*DashboardChartData, len(charts))
+	if len(charts) == 0 {
+		return result, protocol.CodeOK
+	}
+
+	chartIds := make([]uint32, 0, len(charts))
+	for _, chart := range charts {
+		chartIds = append(chartIds, chart.ID)
+		result[chart.ID] = &DashboardChartData{} // workers only write to their own chart, so the map is not changed concurrently
+	}
+
+	lines, err := dbmodel.QueryChartsLines(chartIds)
+	if err != nil {
+		newlog.Error("query lines of dashboardId=%d failed: %v", req.ID, err)
+		return nil, protocol.CodeExecMySQLError
+	}
+
+	chartLines := make(map[uint32][]dbmodel.Line)
+	for _, line := range lines {
+		chartLines[line.ChartId] = append(chartLines[line.ChartId], line)
+	}
+
+	ctx = withTagKeysCache(ctx)
+	workers := dashboardWorkers
+	if workers > len(charts) {
+		workers = len(charts)
+	}
+
+	chartChan := make(chan dbmodel.Chart)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chart := range chartChan {
+				data, code := queryDashboardChart(ctx, req, chart, chartLines[chart.ID])
+				chartData := result[chart.ID]
+				chartData.Code, chartData.Msg, chartData.Data = code, protocol.CodeMsg[code], data
+			}
+		}()
+	}
+
+	for _, chart := range charts {
+		chartChan <- chart
+	}
+	close(chartChan)
+	wg.Wait()
+
+	return result, protocol.CodeOK
+}
+
+func QueryDashboardData(w http.ResponseWriter, r *http.Request) {
+	defer monitor.AddMonitorStats(time.Now(), "dashboardData")
+	ctx, cancel := queryContext(r)
+	defer cancel()
+
+	var req DashboardDataReq
+	err := protocol.DecodeRequest(r, &req)
+	if err != nil {
+		newlog.Error("queryDashboardData: decode query request failed: %v", err)
+		protocol.WriteQueryResp(w, protocol.CodeJsonDecodeError, nil)
+		return
+	}
+
+	result, code := internalQueryDashboardData(ctx, &req)
+	protocol.WriteQueryResp(w, code, result)
+}
@@ -0,0 +1,25 @@
+package tsdb
+
+import (
+	"context"
+	"github.com/sentrycloud/sentry/pkg/protocol"
+	"testing"
+)
+
+func TestTagKeysCache(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ctx, debug := withQueryDebug(ctx, true)
+	ctx = withTagKeysCache(ctx)
+
+	for i := 0; i < 3; i++ {
+		if _, code := queryMetricTagKeys(ctx, "m"); code != protocol.CodeQueryCanceledError {
+			t.Fatalf("expect query canceled, got %s", protocol.CodeMsg[code])
+		}
+	}
+
+	queryMetricTagKeys(ctx, "n")
+	if queries := debug.result().Queries; len(queries) != 2 {
+		t.Errorf("expect tag keys of each metric queried once, got %v", queries)
+	}
+}
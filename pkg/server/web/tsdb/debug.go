@@ -0,0 +1,105 @@
+package tsdb
+
+import (
+	"context"
+	"github.com/sentrycloud/sentry/pkg/protocol"
+	"sync"
+	"time"
+)
+
+type queryDebugKey struct{}
+
+// queryDebug collect debug info of a request in debug mode, it's carried by the context of the request,
+// so queries deep in the call chain can be recorded, all methods can be called on nil if debug is not set
+type queryDebug struct {
+	mu   sync.Mutex
+	info protocol.QueryDebug
+}
+
+// withQueryDebug return a context that carries a queryDebug if debug is set, otherwise the ctx and nil
+func withQueryDebug(ctx context.Context, debug bool) (context.Context, *queryDebug) {
+	if !debug {
+		return ctx, nil
+	}
+
+	d := &queryDebug{info: protocol.QueryDebug{
+		Queries:        []protocol.DebugQuery{},
+		Curves:         []protocol.DebugCurve{},
+		DroppedFilters: []protocol.DroppedFilter{},
+		Errors:         []string{},
+	}}
+	return context.WithValue(ctx, queryDebugKey{}, d), d
+}
+
+func getQueryDebug(ctx context.Context) *queryDebug {
+	d, _ := ctx.Value(queryDebugKey{}).(*queryDebug)
+	return d
+}
+
+func (d *queryDebug) addQuery(sql string, startTime time.Time, rows int, err error) {
+	if d == nil {
+		return
+	}
+
+	query := protocol.DebugQuery{SQL: sql, Latency: time.Since(startTime).Milliseconds(), Rows: rows}
+	if err != nil {
+		query.Error = err.Error()
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.info.Queries = append(d.info.Queries, query)
+}
+
+func (d *queryDebug) addCurve(curveData *protocol.CurveData) {
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.info.Curves = append(d.info.Curves, protocol.DebugCurve{Metric: curveData.Metric, Tags: curveData.Tags, Points: len(curveData.DPS)})
+}
+
+func (d *queryDebug) addDroppedFilter(metric string, key string, value string) {
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.info.DroppedFilters = append(d.info.DroppedFilters, protocol.DroppedFilter{Metric: metric, Key: key, Value: value})
+}
+
+func (d *queryDebug) addError(err string) {
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.info.Errors = append(d.info.Errors, err)
+}
+
+// setAlignment set the effective time range in milliseconds and down sample that data points are aligned with
+func (d *queryDebug) setAlignment(start int64, end int64, downSample int64) {
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.info.Start, d.info.End, d.info.DownSample = start, end, downSample
+}
+
+// result return the debug info to write in the response, nil if debug is not set
+func (d *queryDebug) result() *protocol.QueryDebug {
+	if d == nil {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	info := d.info
+	return &info
+}
@@ -0,0 +1,29 @@
+package tsdb
+
+import (
+	"context"
+	"errors"
+	"github.com/sentrycloud/sentry/pkg/protocol"
+	"testing"
+	"time"
+)
+
+func TestQueryDebug(t *testing.T) {
+	ctx, debug := withQueryDebug(context.Background(), false)
+	getQueryDebug(ctx).addQuery("SELECT 1", time.Now(), 1, nil)
+	if debug != nil || debug.result() != nil {
+		t.Errorf("expect no debug info")
+	}
+
+	ctx, debug = withQueryDebug(context.Background(), true)
+	getQueryDebug(ctx).addQuery("SELECT 1", time.Now(), 0, errors.New("query timeout"))
+	debug.addCurve(&protocol.CurveData{Metric: "m", Tags: map[string]string{"ip": "1"}, DPS: make([]protocol.TimeValuePoint, 3)})
+	debug.addDroppedFilter("m", "idc", "bj")
+	debug.setAlignment(1000, 9000, 10)
+
+	info := debug.result()
+	if len(info.Queries) != 1 || info.Queries[0].Error != "query timeout" || len(info.Curves) != 1 || info.Curves[0].Points != 3 ||
+		len(info.DroppedFilters) != 1 || info.Start != 1000 || info.DownSample != 10 || len(info.Errors) != 0 {
+		t.Errorf("unexpected debug info: %+v", info)
+	}
+}
@@ -0,0 +1,147 @@
+package tsdb
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"github.com/sentrycloud/sentry/pkg/newlog"
+	"github.com/sentrycloud/sentry/pkg/protocol"
+	"github.com/sentrycloud/sentry/pkg/server/monitor"
+	"net/http"
+	"time"
+)
+
+const ExportFlushRows = 1000 // flush to the client every these rows, so the client can receive data in streaming mode
+
+func transferExportRequest(req *protocol.ExportRequest) int {
+	code := checkAndTransferTimeWithLimit(req.Last, &req.Start, &req.End, MaxExportRange)
+	if code != protocol.CodeOK {
+		return code
+	}
+
+	req.Start *= 1000 // transfer to milliseconds
+	req.End *= 1000
+
+	var err error
+	req.Format, err = protocol.CheckExportFormat(req.Format)
+	if err != nil {
+		return protocol.CodeExportFormatError
+	}
+
+	var starKeys []string
+	starKeys, req.Tags, _, code = splitTagFilters(req.Metric, req.Tags)
+	if code != protocol.CodeOK {
+		return code
+	}
+
+	if len(starKeys) > 0 {
+		return protocol.CodeStarKeysError
+	}
+
+	return protocol.CodeOK
+}
+
+// export raw data points, all tag columns are selected, so each data point can be imported back with its tags
+func buildExportQuerySql(req *protocol.ExportRequest, tagKeys []string) (string, error) {
+	return selectFrom(req.Metric).Column("CAST(_ts as BIGINT)").Column("_value").TagColumn(tagKeys...).
+		TimeRange(req.Start, req.End, true).WhereTags(req.Tags).Build()
+}
+
+// ExportTimeSeriesData export raw data points of a metric in a time range to newline delimited json or csv
+func ExportTimeSeriesData(w http.ResponseWriter, r *http.Request) {
+	defer monitor.AddMonitorStats(time.Now(), "export")
+
+	var req protocol.ExportRequest
+	err := protocol.DecodeRequest(r, &req)
+	if err != nil {
+		protocol.WriteQueryResp(w, protocol.CodeJsonDecodeError, nil)
+		return
+	}
+
+	code := transferExportRequest(&req)
+	if code != protocol.CodeOK {
+		newlog.Error("exportTimeSeriesData: transferExportRequest failed: %s", protocol.CodeMsg[code])
+		protocol.WriteQueryResp(w, code, nil)
+		return
+	}
+
+	// export is in streaming mode and can take longer than the query timeout, it's canceled when the client disconnects
+	ctx := r.Context()
+	tagKeys, code := internalQueryTagKeys(ctx, req.Metric)
+	if code != protocol.CodeOK {
+		protocol.WriteQueryResp(w, code, nil)
+		return
+	}
+
+	sql, err := buildExportQuerySql(&req, tagKeys)
+	if err != nil {
+		newlog.Error("exportTimeSeriesData: build sql failed: %v", err)
+		protocol.WriteQueryResp(w, protocol.CodeSqlParamError, nil)
+		return
+	}
+
+	exportWriter := protocol.NewExportWriter(w, req.Format, req.Metric, tagKeys)
+	flusher, _ := w.(http.Flusher)
+	tagValues := make([]string, len(tagKeys))
+	rowCount := 0
+
+	// write the http header when the first row arrived, so the query error can still be sent as a json response
+	writeHeader := func() error {
+		contentType := "application/x-ndjson"
+		if req.Format == protocol.ExportFormatCsv {
+			contentType = "text/csv"
+		}
+		w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.%s\"", req.Metric, req.Format))
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusOK)
+		return exportWriter.WriteHeader()
+	}
+
+	err = QueryTSDBStream(ctx, sql, 2+len(tagKeys), func(values []driver.Value) error {
+		if rowCount == 0 {
+			if e := writeHeader(); e != nil {
+				return e
+			}
+		}
+
+		for i := range tagKeys {
+			tagValues[i] = ""
+			if v, ok := values[2+i].(string); ok {
+				tagValues[i] = v
+			}
+		}
+
+		e := exportWriter.Write(values[0].(int64), values[1].(float64), tagValues)
+		if e != nil {
+			return e
+		}
+
+		rowCount++
+		if rowCount%ExportFlushRows == 0 {
+			e = exportWriter.Flush()
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		return e
+	})
+
+	if rowCount == 0 {
+		if err != nil {
+			protocol.WriteQueryResp(w, queryErrorCode(err), nil)
+			return
+		}
+
+		err = writeHeader() // empty result still return a valid file
+	}
+
+	if err != nil {
+		// http status is already sent, the client will get a truncated file
+		newlog.Error("exportTimeSeriesData: export metric=%s failed after %d rows: %v", req.Metric, rowCount, err)
+	}
+
+	err = exportWriter.Flush()
+	if err != nil {
+		newlog.Error("exportTimeSeriesData: flush failed: %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package tsdb
+
+import (
+	"context"
+	"errors"
+	"github.com/sentrycloud/sentry/pkg/newlog"
+	"github.com/sentrycloud/sentry/pkg/protocol"
+	"github.com/sentrycloud/sentry/pkg/server/expr"
+	"sort"
+	"strings"
+)
+
+// newExpressionFetcher return a fetcher that query all curves of a selector in [start, end),
+// start and end are in milliseconds, filter is the same as chart filter and can be nil
+func newExpressionFetcher(ctx context.Context, start int64, end int64, offset int64, aggregator string, downSample int64,
+	filter map[string]string) expr.Fetcher {
+	return func(selector *expr.SelectorNode) ([]*protocol.CurveData, error) {
+		metricReq := selector.MetricReq()
+		metricReq.Tags = filterTags(ctx, metricReq.Metric, metricReq.Tags, filter)
+
+		selectorAggregator := aggregator
+		if len(selector.Aggregator) > 0 {
+			selectorAggregator = selector.Aggregator
+		}
+
+		curveDataList, code := internalQueryGroupRange(ctx, start, end, offset, selectorAggregator, downSample, metricReq)
+		if code != protocol.CodeOK {
+			return nil, codeError(code)
+		}
+
+		for _, curveData := range curveDataList {
+			alignDataPoints(curveData.DPS, downSample)
+		}
+		return curveDataList, nil
+	}
+}
+
+// alignDataPoints align timestamps of data points to the start of their interval, FIRST(_ts) is the first
+// data point in the interval, so data points of different metrics or offsets can be matched by timestamp
+func alignDataPoints(dps []protocol.TimeValuePoint, downSample int64) {
+	for i := range dps {
+		dps[i].TimeStamp = dps[i].TimeStamp / downSample * downSample
+	}
+}
+
+// codeError is returned by the fetcher, so the error code of the query can be returned by queryExpression
+type codeError int
+
+func (e codeError) Error() string {
+	return protocol.CodeMsg[int(e)]
+}
+
+func queryExpression(expression string, fetch expr.Fetcher) ([]*protocol.CurveData, int) {
+	node, err := expr.Parse(expression)
+	if err != nil {
+		newlog.Error("parse expression %s failed: %v", expression, err)
+		return nil, protocol.CodeExpressionError
+	}
+	return evalExpression(node, fetch)
+}
+
+// evalExpression evaluate the syntax tree, the error code of the query is returned if the fetcher failed
+func evalExpression(node expr.Node, fetch expr.Fetcher) ([]*protocol.CurveData, int) {
+	curveDataList, err := expr.Eval(node, fetch)
+	if err != nil {
+		newlog.Error("eval expression %s failed: %v", node.String(), err)
+		var queryErr codeError
+		if errors.As(err, &queryErr) {
+			return nil, int(queryErr) // query failed, such as timeout
+		}
+		return nil, protocol.CodeExpressionError
+	}
+
+	return curveDataList, protocol.CodeOK
+}
+
+// getExpressionLineName use line name for single curve, otherwise use tag values to differentiate each other
+func getExpressionLineName(lineName string, curveCount int, tags map[string]string) string {
+	if curveCount <= 1 || len(tags) == 0 {
+		return lineName
+	}
+
+	var keys []string
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var values []string
+	for _, k := range keys {
+		values = append(values, tags[k])
+	}
+	return strings.Join(values, "-")
+}
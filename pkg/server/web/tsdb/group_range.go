@@ -0,0 +1,143 @@
+package tsdb
+
+import (
+	"context"
+	"database/sql/driver"
+	"github.com/sentrycloud/sentry/pkg/newlog"
+	"github.com/sentrycloud/sentry/pkg/protocol"
+	"sort"
+	"strings"
+)
+
+// buildGroupRangeQuerySql query all curves of star tags in one query, each curve is a partition:
+// SELECT CAST(FIRST(_ts) as BIGINT),SUM(_value),`ip` FROM `m` WHERE ... AND `ip` IS NOT NULL PARTITION BY `ip` INTERVAL(10s)
+func buildGroupRangeQuerySql(start int64, end int64, aggregator string, downSample int64, metric string,
+	tags map[string]string, filters map[string][]string, matchers []protocol.TagMatcher, starTags map[string]string) (string, []string, error) {
+	starKeys := sortedKeys(starTags)
+	table, aggregate, rollup := querySource(metric, aggregator, start, end, downSample)
+	builder := selectFrom(table).Column("CAST(FIRST(_ts) as BIGINT)").Column(aggregate).TagColumn(starKeys...).
+		TimeRange(start, end, rollup).WhereTags(tags).WhereFilters(filters).WhereMatchers(matchers)
+
+	for _, k := range starKeys {
+		builder.WhereNull(k, false)
+		if prefix := starTags[k]; len(prefix) > 0 {
+			builder.WherePrefix(k, prefix)
+		}
+	}
+
+	sql, err := builder.PartitionBy(starKeys...).Interval(downSample).Build()
+	return sql, starKeys, err
+}
+
+// internalQueryGroupRange query all curves of a metric with star tags in one query instead of one query for each curve,
+// tags with || are used as filters, and at most LineCountLimit curves are returned
+func internalQueryGroupRange(ctx context.Context, start int64, end int64, offset int64, aggregator string, downSample int64,
+	metricReq *protocol.MetricReq) ([]*protocol.CurveData, int) {
+	starTags, noStarTags, err := splitTags(metricReq.Tags)
+	if err != nil {
+		newlog.Error("queryGroupRange: splitTags failed: %v", err)
+		return nil, protocol.CodeSplitTagsError
+	}
+
+	if err = protocol.CheckMatchers(metricReq.Matchers); err != nil {
+		newlog.Error("queryGroupRange: check matchers failed: %v", err)
+		return nil, protocol.CodeTagMatcherError
+	}
+
+	tags := make(map[string]string)
+	filters := make(map[string][]string)
+	for k, v := range noStarTags {
+		if strings.Contains(v, "||") {
+			filters[k] = strings.Split(v, "||")
+		} else {
+			tags[k] = v
+		}
+	}
+
+	key := rangeCacheKey("group", metricReq, aggregator, downSample, offset)
+	return cachedQueryCurves(ctx, key, start, end, offset, downSample, func(start int64, end int64) ([]*protocol.CurveData, int) {
+		if len(starTags) == 0 {
+			m := protocol.MetricReq{Metric: metricReq.Metric, Tags: copyTags(tags), Filters: filters, Matchers: metricReq.Matchers}
+			curveData, code := internalQueryRange(ctx, start, end, offset, aggregator, downSample, &m)
+			if code != protocol.CodeOK {
+				return nil, code
+			}
+			return []*protocol.CurveData{curveData}, protocol.CodeOK
+		}
+		return queryGroupRange(ctx, start, end, offset, aggregator, downSample, metricReq.Metric, copyTags(tags), filters, metricReq.Matchers, starTags)
+	})
+}
+
+func copyTags(tags map[string]string) map[string]string {
+	result := make(map[string]string)
+	for k, v := range tags {
+		result[k] = v
+	}
+	return result
+}
+
+func queryGroupRange(ctx context.Context, start int64, end int64, offset int64, aggregator string, downSample int64, metric string,
+	tags map[string]string, filters map[string][]string, matchers []protocol.TagMatcher, starTags map[string]string) ([]*protocol.CurveData, int) {
+	sql, starKeys, err := buildGroupRangeQuerySql(start+offset, end+offset, aggregator, downSample, metric, tags, filters, matchers, starTags)
+	if err != nil {
+		newlog.Error("build group range query sql failed: %v", err)
+		return nil, protocol.CodeSqlParamError
+	}
+
+	results, err := QueryTSDB(ctx, sql, 2+len(starKeys))
+	if err != nil {
+		return nil, queryErrorCode(err)
+	}
+
+	return splitPartitionRows(results, metric, tags, starKeys, offset), protocol.CodeOK
+}
+
+// splitPartitionRows split rows of the PARTITION BY query to a curve for each combination of star key values,
+// a row is [timestamp, value, star key values...], timestamp is in milliseconds with offset
+func splitPartitionRows(results [][]driver.Value, metric string, tags map[string]string, starKeys []string, offset int64) []*protocol.CurveData {
+	// WhereTags has deleted not equal keys, so tags only have equal conditions now
+	var curveDataList []*protocol.CurveData
+	curveMap := make(map[string]*protocol.CurveData)
+	for _, row := range results {
+		if row[1] == nil {
+			continue
+		}
+
+		curveTags := make(map[string]string)
+		for k, v := range tags {
+			curveTags[k] = v
+		}
+
+		var values []string
+		for idx, key := range starKeys {
+			value, _ := row[2+idx].(string)
+			curveTags[key] = value
+			values = append(values, value)
+		}
+
+		key := strings.Join(values, "\x00")
+		curveData, exist := curveMap[key]
+		if !exist {
+			if len(curveDataList) >= LineCountLimit {
+				continue // do not return too many curves, that maybe hog too much resource of the browser
+			}
+
+			curveData = &protocol.CurveData{Metric: metric, Tags: curveTags}
+			curveMap[key] = curveData
+			curveDataList = append(curveDataList, curveData)
+		}
+
+		curveData.DPS = append(curveData.DPS, protocol.TimeValuePoint{
+			TimeStamp: (row[0].(int64) - offset) / 1000,
+			Value:     row[1].(float64),
+		})
+	}
+
+	// data points of a partition may not be returned in order
+	for _, curveData := range curveDataList {
+		sort.Slice(curveData.DPS, func(i, j int) bool {
+			return curveData.DPS[i].TimeStamp < curveData.DPS[j].TimeStamp
+		})
+	}
+	return curveDataList
+}
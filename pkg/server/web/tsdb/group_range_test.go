@@ -0,0 +1,63 @@
+package tsdb
+
+import (
+	"database/sql/driver"
+	"github.com/sentrycloud/sentry/pkg/protocol"
+	"strconv"
+	"testing"
+)
+
+func TestSplitPartitionRows(t *testing.T) {
+	tags := map[string]string{"env": "prod"}
+	results := [][]driver.Value{
+		{int64(30000), 3.0, "web", "1"},
+		{int64(10000), 1.0, "web", "1"},
+		{int64(20000), 5.0, "api", "2"},
+		{int64(20000), nil, "web", "1"}, // stddev may return null for an interval
+		{int64(10000), 4.0, "api", "2"},
+		{int64(20000), 2.0, "web", "1"},
+	}
+
+	curves := splitPartitionRows(results, "m", tags, []string{"app", "ip"}, 5000)
+	if len(curves) != 2 {
+		t.Fatalf("expect 2 curves, got %d", len(curves))
+	}
+
+	web, api := curves[0], curves[1]
+	if web.Metric != "m" || web.Tags["app"] != "web" || web.Tags["ip"] != "1" || web.Tags["env"] != "prod" ||
+		api.Tags["app"] != "api" || api.Tags["ip"] != "2" || api.Tags["env"] != "prod" {
+		t.Errorf("wrong tags of curves: %v, %v", web.Tags, api.Tags)
+	}
+
+	if len(tags) != 1 {
+		t.Errorf("tags of the request should not be changed: %v", tags)
+	}
+
+	expectWeb := []protocol.TimeValuePoint{{TimeStamp: 5, Value: 1}, {TimeStamp: 15, Value: 2}, {TimeStamp: 25, Value: 3}}
+	expectApi := []protocol.TimeValuePoint{{TimeStamp: 5, Value: 4}, {TimeStamp: 15, Value: 5}}
+	if !equalPoints(web.DPS, expectWeb) || !equalPoints(api.DPS, expectApi) {
+		t.Errorf("data points are not sorted or offset is not removed: %v, %v", web.DPS, api.DPS)
+	}
+
+	// curves more than LineCountLimit are dropped
+	results = nil
+	for i := 0; i <= LineCountLimit; i++ {
+		results = append(results, []driver.Value{int64(10000), 1.0, strconv.Itoa(i)})
+	}
+	if curves = splitPartitionRows(results, "m", nil, []string{"ip"}, 0); len(curves) != LineCountLimit {
+		t.Errorf("expect %d curves, got %d", LineCountLimit, len(curves))
+	}
+}
+
+func equalPoints(a []protocol.TimeValuePoint, b []protocol.TimeValuePoint) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
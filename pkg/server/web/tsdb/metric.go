@@ -1,16 +1,41 @@
 package tsdb
 
 import (
-	"fmt"
+	"context"
 	"github.com/sentrycloud/sentry/pkg/protocol"
+	"github.com/sentrycloud/sentry/pkg/server/metadata"
 	"github.com/sentrycloud/sentry/pkg/server/monitor"
+	"github.com/sentrycloud/sentry/pkg/server/taos"
 	"net/http"
 	"time"
 )
 
+// internalQueryMetrics query metrics that contain the name, rollup tables are not metrics
+func internalQueryMetrics(ctx context.Context, name string) ([]string, int) {
+	sql, err := buildShowMetricsSql(name)
+	if err != nil {
+		return nil, protocol.CodeSqlParamError
+	}
+
+	results, err := QueryTSDB(ctx, sql, 1)
+	if err != nil {
+		return nil, queryErrorCode(err)
+	}
+
+	var metrics []string
+	for _, row := range results {
+		if row[0] != nil && !taos.IsRollupTable(row[0].(string)) {
+			metrics = append(metrics, row[0].(string))
+		}
+	}
+	return metrics, protocol.CodeOK
+}
+
 // QueryMetrics all metrics that start with the name in the request
 func QueryMetrics(w http.ResponseWriter, r *http.Request) {
 	defer monitor.AddMonitorStats(time.Now(), "metric")
+	ctx, cancel := queryContext(r)
+	defer cancel()
 	
 	var m protocol.MetricReq
 	err := protocol.DecodeRequest(r, &m)
@@ -19,19 +44,16 @@ func QueryMetrics(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	sql := fmt.Sprintf("show stables like '%%%s%%'", m.Metric)
-	results, err := QueryTSDB(sql, 1)
-	if err != nil {
-		protocol.WriteQueryResp(w, protocol.CodeExecTSDBSqlError, nil)
+	metrics, code := internalQueryMetrics(ctx, m.Metric)
+	if code != protocol.CodeOK || r.URL.Query().Get("meta") != "true" {
+		protocol.WriteQueryResp(w, code, metrics)
 		return
 	}
 
-	var metrics []string
-	for _, row := range results {
-		if row[0] != nil {
-			metrics = append(metrics, row[0].(string))
-		}
+	// with ?meta=true, metrics are returned with metadata
+	infos := make([]protocol.MetricInfo, 0, len(metrics))
+	for _, metric := range metrics {
+		infos = append(infos, protocol.MetricInfo{Metric: metric, Meta: metadata.Get(metric)})
 	}
-
-	protocol.WriteQueryResp(w, protocol.CodeOK, metrics)
+	protocol.WriteQueryResp(w, protocol.CodeOK, infos)
 }
@@ -0,0 +1,401 @@
+package tsdb
+
+import (
+	"context"
+	"github.com/sentrycloud/sentry/pkg/newlog"
+	"github.com/sentrycloud/sentry/pkg/protocol"
+	"github.com/sentrycloud/sentry/pkg/server/expr"
+	"github.com/sentrycloud/sentry/pkg/server/monitor"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultSuggestLimit = 25 // the same default as OpenTSDB for suggest and lookup
+	MaxSuggestLimit     = 1000
+)
+
+// writeOpenTSDBResp write data without QueryResp wrapper, error is written as OpenTSDB does: {"error":{"code":400,"message":""}}
+func writeOpenTSDBResp(w http.ResponseWriter, code int, data interface{}) {
+	httpStatus := http.StatusOK
+	if code != protocol.CodeOK {
+		switch code {
+		case protocol.CodeExecTSDBSqlError, protocol.CodeGetConnPoolError, protocol.CodeQueryCanceledError:
+			httpStatus = http.StatusInternalServerError
+		case protocol.CodeQueryTimeoutError:
+			httpStatus = http.StatusGatewayTimeout
+		case protocol.CodeTooManyQueriesError:
+			httpStatus = http.StatusServiceUnavailable
+		default:
+			httpStatus = http.StatusBadRequest
+		}
+		data = map[string]protocol.OpenTSDBError{"error": {Code: httpStatus, Message: protocol.CodeMsg[code]}}
+	}
+
+	jsonData, err := protocol.Json.Marshal(data)
+	if err != nil {
+		newlog.Error("marshal OpenTSDB response failed: %v", err)
+		httpStatus = http.StatusInternalServerError
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(httpStatus)
+	w.Write(jsonData)
+}
+
+func queryIntParam(r *http.Request, name string, defaultValue int) int {
+	v, err := strconv.Atoi(r.URL.Query().Get(name))
+	if err != nil || v <= 0 {
+		return defaultValue
+	}
+	return v
+}
+
+// decodeOpenTSDBQuery decode POST body, or GET parameters like: start=1h-ago&m=sum:1m-avg:metric{host=*}
+func decodeOpenTSDBQuery(r *http.Request, req *protocol.OpenTSDBQueryRequest) int {
+	if r.Method == http.MethodPost {
+		if err := protocol.DecodeRequest(r, req); err != nil {
+			return protocol.CodeJsonDecodeError
+		}
+		return protocol.CodeOK
+	}
+
+	params := r.URL.Query()
+	if start := params.Get("start"); len(start) > 0 {
+		req.Start = start
+	}
+
+	if end := params.Get("end"); len(end) > 0 {
+		req.End = end
+	}
+
+	_, req.MsResolution = params["ms"]
+	for _, m := range params["m"] {
+		q, err := protocol.ParseOpenTSDBSubQuery(m)
+		if err != nil {
+			newlog.Error("parse OpenTSDB query %s failed: %v", m, err)
+			return protocol.CodeInvalidParamError
+		}
+		req.Queries = append(req.Queries, *q)
+	}
+	return protocol.CodeOK
+}
+
+// internalQueryOpenTSDB query a sub query with internalQueryGroupRange, start and end are in seconds,
+// all data points in a down sample interval of the grouped series are aggregated by the aggregator in one step,
+// so the down sample aggregator is used only when the aggregator is none, which groups by all tag keys
+func internalQueryOpenTSDB(ctx context.Context, start int64, end int64, q *protocol.OpenTSDBSubQuery, ms bool) ([]protocol.OpenTSDBQueryResult, int) {
+	metricReq, err := protocol.OpenTSDBMetricReq(q)
+	if err != nil {
+		newlog.Error("queryOpenTSDB: convert filters of metric=%s failed: %v", q.Metric, err)
+		return nil, protocol.CodeTagMatcherError
+	}
+
+	downSample, downSampleAggregator, fill := int64(protocol.DownSampleAuto), "avg", protocol.FillNone
+	if len(q.DownSample) > 0 {
+		downSample, downSampleAggregator, fill, err = protocol.ParseOpenTSDBDownSample(q.DownSample)
+		if err != nil {
+			newlog.Error("queryOpenTSDB: parse down sample failed: %v", err)
+			return nil, protocol.CodeDownSampleError
+		}
+	}
+
+	aggregator := downSampleAggregator
+	if !strings.EqualFold(q.Aggregator, protocol.OpenTSDBAggregatorNone) {
+		aggregator, err = protocol.OpenTSDBAggregator(q.Aggregator)
+		if err != nil {
+			newlog.Error("queryOpenTSDB: check aggregator failed: %v", err)
+			return nil, protocol.CodeAggregatorError
+		}
+	}
+
+	code := checkAndTransferTimeWithLimit(0, &start, &end, maxQueryRange())
+	if code != protocol.CodeOK {
+		return nil, code
+	}
+
+	if downSample == protocol.DownSampleAuto {
+		downSample = protocol.ChooseDownSample(end-start, 0, downSample)
+	}
+
+	if end-start > MaxQueryRange {
+		if code = checkRollupQuery(aggregator, downSample); code != protocol.CodeOK {
+			return nil, code
+		}
+	}
+
+	if code = alignWithDownSample(downSample, &start, &end); code != protocol.CodeOK {
+		return nil, code
+	}
+
+	tagKeys, code := internalQueryTagKeys(ctx, q.Metric)
+	if code != protocol.CodeOK {
+		return nil, code
+	}
+
+	if strings.EqualFold(q.Aggregator, protocol.OpenTSDBAggregatorNone) {
+		for _, k := range tagKeys {
+			if _, exist := metricReq.Tags[k]; !exist {
+				metricReq.Tags[k] = "*"
+			}
+		}
+	}
+
+	function := ""
+	queryStart := start
+	if q.Rate {
+		function = protocol.FunctionDerivative
+		if q.RateOptions != nil && q.RateOptions.Counter {
+			function = protocol.FunctionRate
+		}
+		queryStart -= downSample * 1000 // query one more data point, so the first data point in range has a previous one
+	}
+
+	curveDataList, code := internalQueryGroupRange(ctx, queryStart, end, 0, aggregator, downSample, metricReq)
+	if code != protocol.CodeOK {
+		return nil, code
+	}
+	expr.ApplyCounterFunction(function, curveDataList)
+
+	results := make([]protocol.OpenTSDBQueryResult, 0, len(curveDataList))
+	for _, curveData := range curveDataList {
+		if len(curveData.DPS) == 0 {
+			continue // OpenTSDB does not return series without data points
+		}
+
+		aggregateTags := make([]string, 0)
+		for _, k := range tagKeys {
+			if _, exist := curveData.Tags[k]; !exist {
+				aggregateTags = append(aggregateTags, k)
+			}
+		}
+		sort.Strings(aggregateTags)
+
+		results = append(results, protocol.OpenTSDBQueryResult{
+			Metric:        q.Metric,
+			Tags:          curveData.Tags,
+			AggregateTags: aggregateTags,
+			DPS: protocol.OpenTSDBDataPoints{
+				Points:       protocol.FillDataPoints(curveData.DPS, start, end, downSample, fill),
+				Milliseconds: ms,
+			},
+		})
+	}
+	return results, protocol.CodeOK
+}
+
+// QueryOpenTSDB OpenTSDB compatible /api/query, so the OpenTSDB datasource of Grafana can query sentry directly
+func QueryOpenTSDB(w http.ResponseWriter, r *http.Request) {
+	defer monitor.AddMonitorStats(time.Now(), "openTSDBQuery")
+	ctx, cancel := queryContext(r)
+	defer cancel()
+
+	var req protocol.OpenTSDBQueryRequest
+	code := decodeOpenTSDBQuery(r, &req)
+	if code != protocol.CodeOK {
+		writeOpenTSDBResp(w, code, nil)
+		return
+	}
+
+	now := time.Now()
+	start, err := protocol.ParseOpenTSDBTime(req.Start, now)
+	if err != nil {
+		newlog.Error("queryOpenTSDB: parse start failed: %v", err)
+		writeOpenTSDBResp(w, protocol.CodeInvalidParamError, nil)
+		return
+	}
+
+	end := now.Unix()
+	if req.End != nil {
+		end, err = protocol.ParseOpenTSDBTime(req.End, now)
+		if err != nil {
+			newlog.Error("queryOpenTSDB: parse end failed: %v", err)
+			writeOpenTSDBResp(w, protocol.CodeInvalidParamError, nil)
+			return
+		}
+	}
+
+	if len(req.Queries) == 0 {
+		writeOpenTSDBResp(w, protocol.CodeMetricError, nil)
+		return
+	}
+
+	results := make([]protocol.OpenTSDBQueryResult, 0)
+	for i := range req.Queries {
+		subResults, code := internalQueryOpenTSDB(ctx, start, end, &req.Queries[i], req.MsResolution)
+		if code != protocol.CodeOK {
+			writeOpenTSDBResp(w, code, nil)
+			return
+		}
+		results = append(results, subResults...)
+	}
+	writeOpenTSDBResp(w, protocol.CodeOK, results)
+}
+
+// internalSuggestOpenTSDB suggest metrics, tag keys or tag values that start with q, tag keys and values are of all metrics
+func internalSuggestOpenTSDB(ctx context.Context, req *protocol.OpenTSDBSuggestRequest) ([]string, int) {
+	if req.Max <= 0 {
+		req.Max = DefaultSuggestLimit
+	} else if req.Max > MaxSuggestLimit {
+		req.Max = MaxSuggestLimit
+	}
+
+	var suggestions []string
+	switch req.Type {
+	case "metrics":
+		metrics, code := internalQueryMetrics(ctx, req.Q)
+		if code != protocol.CodeOK {
+			return nil, code
+		}
+
+		for _, metric := range metrics {
+			if strings.HasPrefix(metric, req.Q) {
+				suggestions = append(suggestions, metric)
+			}
+		}
+	case "tagk", "tagv":
+		column := "tag_name"
+		if req.Type == "tagv" {
+			column = "tag_value"
+		}
+
+		tags, code := internalQueryTagsOfAllMetrics(ctx, column, "", req.Q, req.Max)
+		if code != protocol.CodeOK {
+			return nil, code
+		}
+		suggestions = tags
+	default:
+		newlog.Error("suggestOpenTSDB: no such type: %s", req.Type)
+		return nil, protocol.CodeInvalidParamError
+	}
+
+	sort.Strings(suggestions)
+	if len(suggestions) > req.Max {
+		suggestions = suggestions[:req.Max]
+	}
+	return suggestions, protocol.CodeOK
+}
+
+// SuggestOpenTSDB OpenTSDB compatible /api/suggest
+func SuggestOpenTSDB(w http.ResponseWriter, r *http.Request) {
+	defer monitor.AddMonitorStats(time.Now(), "openTSDBSuggest")
+	ctx, cancel := queryContext(r)
+	defer cancel()
+
+	var req protocol.OpenTSDBSuggestRequest
+	if r.Method == http.MethodPost {
+		if err := protocol.DecodeRequest(r, &req); err != nil {
+			writeOpenTSDBResp(w, protocol.CodeJsonDecodeError, nil)
+			return
+		}
+	} else {
+		req.Type = r.URL.Query().Get("type")
+		req.Q = r.URL.Query().Get("q")
+		req.Max = queryIntParam(r, "max", DefaultSuggestLimit)
+	}
+
+	suggestions, code := internalSuggestOpenTSDB(ctx, &req)
+	if suggestions == nil {
+		suggestions = []string{}
+	}
+	writeOpenTSDBResp(w, code, suggestions)
+}
+
+// internalLookupOpenTSDB lookup series of a metric, tag keys not in the request are star tags, so all series are returned
+func internalLookupOpenTSDB(ctx context.Context, req *protocol.OpenTSDBLookupRequest) (*protocol.OpenTSDBLookupResult, int) {
+	begin := time.Now()
+	if len(req.Metric) == 0 {
+		return nil, protocol.CodeMetricError
+	}
+
+	if req.Limit <= 0 {
+		req.Limit = DefaultSuggestLimit
+	}
+
+	tagKeys, code := internalQueryTagKeys(ctx, req.Metric)
+	if code != protocol.CodeOK {
+		return nil, code
+	}
+
+	if req.Tags == nil {
+		req.Tags = make([]protocol.OpenTSDBLookupTag, 0)
+	}
+
+	result := &protocol.OpenTSDBLookupResult{Type: "LOOKUP", Metric: req.Metric, Tags: req.Tags, Limit: req.Limit,
+		Results: make([]protocol.OpenTSDBLookupSeries, 0)}
+	tags := make(map[string]string)
+	for _, k := range tagKeys {
+		tags[k] = "*"
+	}
+
+	for _, tag := range req.Tags {
+		if _, exist := tags[tag.Key]; !exist {
+			return result, protocol.CodeOK // no series has the tag key
+		}
+
+		if tag.Value != "*" && len(tag.Value) > 0 {
+			tags[tag.Key] = tag.Value
+		}
+	}
+
+	curves, code := internalQueryCurves(ctx, &protocol.MetricReq{Metric: req.Metric, Tags: tags})
+	if code != protocol.CodeOK {
+		return nil, code
+	}
+
+	result.TotalResults = len(curves)
+	if len(curves) > req.Limit {
+		curves = curves[:req.Limit]
+	}
+
+	for _, curve := range curves {
+		result.Results = append(result.Results, protocol.OpenTSDBLookupSeries{Metric: req.Metric, Tags: curve})
+	}
+	result.Time = time.Since(begin).Milliseconds()
+	return result, protocol.CodeOK
+}
+
+// LookupOpenTSDB OpenTSDB compatible /api/search/lookup, GET request is like: m=metric{host=*}&limit=100
+func LookupOpenTSDB(w http.ResponseWriter, r *http.Request) {
+	defer monitor.AddMonitorStats(time.Now(), "openTSDBLookup")
+	ctx, cancel := queryContext(r)
+	defer cancel()
+
+	var req protocol.OpenTSDBLookupRequest
+	if r.Method == http.MethodPost {
+		if err := protocol.DecodeRequest(r, &req); err != nil {
+			writeOpenTSDBResp(w, protocol.CodeJsonDecodeError, nil)
+			return
+		}
+	} else {
+		metric, filters, err := protocol.ParseOpenTSDBMetric(r.URL.Query().Get("m"))
+		if err != nil {
+			newlog.Error("lookupOpenTSDB: parse metric failed: %v", err)
+			writeOpenTSDBResp(w, protocol.CodeInvalidParamError, nil)
+			return
+		}
+
+		req.Metric = metric
+		req.Limit = queryIntParam(r, "limit", DefaultSuggestLimit)
+		for _, f := range filters {
+			req.Tags = append(req.Tags, protocol.OpenTSDBLookupTag{Key: f.TagK, Value: f.Filter})
+		}
+	}
+
+	result, code := internalLookupOpenTSDB(ctx, &req)
+	writeOpenTSDBResp(w, code, result)
+}
+
+// QueryOpenTSDBAggregators OpenTSDB compatible /api/aggregators
+func QueryOpenTSDBAggregators(w http.ResponseWriter, r *http.Request) {
+	writeOpenTSDBResp(w, protocol.CodeOK, protocol.OpenTSDBAggregators)
+}
+
+// QueryOpenTSDBFilters OpenTSDB compatible /api/config/filters
+func QueryOpenTSDBFilters(w http.ResponseWriter, r *http.Request) {
+	writeOpenTSDBResp(w, protocol.CodeOK, protocol.OpenTSDBFilterTypes)
+}
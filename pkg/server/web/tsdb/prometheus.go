@@ -0,0 +1,369 @@
+package tsdb
+
+import (
+	"context"
+	"github.com/sentrycloud/sentry/pkg/newlog"
+	"github.com/sentrycloud/sentry/pkg/protocol"
+	"github.com/sentrycloud/sentry/pkg/server/expr"
+	"github.com/sentrycloud/sentry/pkg/server/monitor"
+	"github.com/sentrycloud/sentry/pkg/server/promql"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	PromLookBack    = 300   // instant query evaluate the last data point in 5 minutes, like lookback delta of Prometheus
+	PromInstantStep = 60    // step of instant query, rate is calculated between data points of adjacent steps
+	MaxLabelValues  = 10000 // max label names or values of all metrics
+)
+
+// writePromResp write response in the format of Prometheus HTTP API, error type and http status are mapped from code
+func writePromResp(w http.ResponseWriter, code int, data interface{}) {
+	httpStatus := http.StatusOK
+	resp := protocol.PromResponse{Status: protocol.PromStatusSuccess, Data: data}
+	if code != protocol.CodeOK {
+		resp = protocol.PromResponse{Status: protocol.PromStatusError, ErrorType: "bad_data", Error: protocol.CodeMsg[code]}
+		httpStatus = http.StatusBadRequest
+		switch code {
+		case protocol.CodeExecTSDBSqlError, protocol.CodeGetConnPoolError:
+			resp.ErrorType, httpStatus = "execution", http.StatusUnprocessableEntity
+		case protocol.CodeQueryTimeoutError:
+			resp.ErrorType, httpStatus = "timeout", http.StatusServiceUnavailable
+		case protocol.CodeQueryCanceledError:
+			resp.ErrorType, httpStatus = "canceled", http.StatusServiceUnavailable
+		case protocol.CodeTooManyQueriesError:
+			resp.ErrorType, httpStatus = "unavailable", http.StatusServiceUnavailable
+		}
+	}
+
+	jsonData, err := protocol.Json.Marshal(&resp)
+	if err != nil {
+		newlog.Error("marshal Prometheus response failed: %v", err)
+		httpStatus = http.StatusInternalServerError
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(httpStatus)
+	w.Write(jsonData)
+}
+
+// promSelector return a copy of the selector that select every series like PromQL: all tag keys are star tags.
+// matchers on tag keys that the metric does not have are removed if an empty value matches, otherwise nothing matches
+func promSelector(ctx context.Context, selector *expr.SelectorNode) (*expr.SelectorNode, bool, int) {
+	tagKeys, code := internalQueryTagKeys(ctx, selector.Metric)
+	if code != protocol.CodeOK {
+		return nil, false, code
+	}
+
+	result := &expr.SelectorNode{Metric: selector.Metric, Tags: make(map[string]string), Aggregator: selector.Aggregator}
+	for _, m := range selector.Matchers {
+		if contains(tagKeys, m.Key) {
+			result.Matchers = append(result.Matchers, m)
+		} else if m.Op != protocol.MatchAbsent && m.Op != protocol.MatchNotEqual && m.Op != protocol.MatchNotRegex {
+			return nil, false, protocol.CodeOK
+		}
+	}
+
+	for _, k := range tagKeys {
+		absent := false
+		for _, m := range result.Matchers {
+			absent = absent || (m.Key == k && m.Op == protocol.MatchAbsent)
+		}
+
+		if !absent {
+			result.Tags[k] = "*"
+		}
+	}
+	return result, true, protocol.CodeOK
+}
+
+// newPromFetcher query every series of a selector, start and end are in milliseconds
+func newPromFetcher(ctx context.Context, start int64, end int64, aggregator string, step int64) expr.Fetcher {
+	fetch := newExpressionFetcher(ctx, start, end, 0, aggregator, step, nil)
+	return func(selector *expr.SelectorNode) ([]*protocol.CurveData, error) {
+		promSel, ok, code := promSelector(ctx, selector)
+		if code != protocol.CodeOK {
+			return nil, codeError(code)
+		}
+
+		if !ok {
+			return nil, nil
+		}
+		return fetch(promSel)
+	}
+}
+
+// internalQueryPromRange evaluate PromQL in [start, end] with step, all in seconds, timestamps of the result are in seconds
+func internalQueryPromRange(ctx context.Context, node expr.Node, start int64, end int64, step int64) ([]*protocol.CurveData, int) {
+	if step <= 0 || step > MaxDownSample || (end-start)/step > protocol.MaxPointsLimit {
+		return nil, protocol.CodeDownSampleError
+	}
+
+	code := checkAndTransferTimeWithLimit(0, &start, &end, maxQueryRange())
+	if code != protocol.CodeOK {
+		return nil, code
+	}
+
+	// PromQL use the last value at each step, rollup tables do not have last value, so long range queries use avg
+	aggregator := "last"
+	if end-start > MaxQueryRange {
+		aggregator = "avg"
+		if code = checkRollupQuery(aggregator, step); code != protocol.CodeOK {
+			return nil, code
+		}
+	}
+
+	end++ // end is included in PromQL
+	if code = alignWithDownSample(step, &start, &end); code != protocol.CodeOK {
+		return nil, code
+	}
+
+	// query one more step, so rate of the first step has a previous data point
+	curveDataList, code := evalExpression(node, newPromFetcher(ctx, start-step*1000, end, aggregator, step))
+	if code != protocol.CodeOK {
+		return nil, code
+	}
+
+	for _, curveData := range curveDataList {
+		i := sort.Search(len(curveData.DPS), func(i int) bool { return curveData.DPS[i].TimeStamp*1000 >= start })
+		curveData.DPS = curveData.DPS[i:]
+	}
+	return curveDataList, protocol.CodeOK
+}
+
+// promLabels return tags of the curve as labels, __name__ is set if the query is a selector
+func promLabels(node expr.Node, curveData *protocol.CurveData) map[string]string {
+	labels := make(map[string]string)
+	for k, v := range curveData.Tags {
+		labels[k] = v
+	}
+
+	if _, ok := node.(*expr.SelectorNode); ok {
+		labels["__name__"] = curveData.Metric
+	}
+	return labels
+}
+
+// QueryPromRange Prometheus compatible /api/v1/query_range, parameters can be in url query or form
+func QueryPromRange(w http.ResponseWriter, r *http.Request) {
+	defer monitor.AddMonitorStats(time.Now(), "promQueryRange")
+	ctx, cancel := queryContext(r)
+	defer cancel()
+
+	start, startErr := promql.ParseTime(r.FormValue("start"))
+	end, endErr := promql.ParseTime(r.FormValue("end"))
+	step, stepErr := promql.ParseDuration(r.FormValue("step"))
+	if startErr != nil || endErr != nil || stepErr != nil {
+		newlog.Error("queryPromRange: invalid start, end or step: %v %v %v", startErr, endErr, stepErr)
+		writePromResp(w, protocol.CodeInvalidParamError, nil)
+		return
+	}
+
+	node, err := promql.Parse(r.FormValue("query"))
+	if err != nil {
+		newlog.Error("queryPromRange: parse query failed: %v", err)
+		writePromResp(w, protocol.CodeExpressionError, nil)
+		return
+	}
+
+	curveDataList, code := internalQueryPromRange(ctx, node, start, end, int64(math.Ceil(step)))
+	if code != protocol.CodeOK {
+		writePromResp(w, code, nil)
+		return
+	}
+
+	matrix := make([]protocol.PromSeries, 0, len(curveDataList))
+	for _, curveData := range curveDataList {
+		if len(curveData.DPS) == 0 {
+			continue
+		}
+
+		series := protocol.PromSeries{Metric: promLabels(node, curveData)}
+		for _, dp := range curveData.DPS {
+			series.Values = append(series.Values, protocol.PromSample(dp))
+		}
+		matrix = append(matrix, series)
+	}
+	writePromResp(w, protocol.CodeOK, protocol.PromQueryData{ResultType: protocol.PromResultMatrix, Result: matrix})
+}
+
+// QueryProm Prometheus compatible /api/v1/query, the result is the last data point of each series in PromLookBack
+func QueryProm(w http.ResponseWriter, r *http.Request) {
+	defer monitor.AddMonitorStats(time.Now(), "promQuery")
+	ctx, cancel := queryContext(r)
+	defer cancel()
+
+	ts := time.Now().Unix()
+	if t := r.FormValue("time"); len(t) > 0 {
+		var err error
+		if ts, err = promql.ParseTime(t); err != nil {
+			newlog.Error("queryProm: %v", err)
+			writePromResp(w, protocol.CodeInvalidParamError, nil)
+			return
+		}
+	}
+
+	node, err := promql.Parse(r.FormValue("query"))
+	if err != nil {
+		newlog.Error("queryProm: parse query failed: %v", err)
+		writePromResp(w, protocol.CodeExpressionError, nil)
+		return
+	}
+
+	if number, ok := node.(*expr.NumberNode); ok {
+		sample := protocol.PromSample{TimeStamp: ts, Value: number.Value}
+		writePromResp(w, protocol.CodeOK, protocol.PromQueryData{ResultType: protocol.PromResultScalar, Result: sample})
+		return
+	}
+
+	curveDataList, code := internalQueryPromRange(ctx, node, ts-PromLookBack, ts, PromInstantStep)
+	if code != protocol.CodeOK {
+		writePromResp(w, code, nil)
+		return
+	}
+
+	vector := make([]protocol.PromSeries, 0, len(curveDataList))
+	for _, curveData := range curveDataList {
+		if len(curveData.DPS) == 0 {
+			continue
+		}
+
+		sample := protocol.PromSample{TimeStamp: ts, Value: curveData.DPS[len(curveData.DPS)-1].Value}
+		vector = append(vector, protocol.PromSeries{Metric: promLabels(node, curveData), Value: &sample})
+	}
+	writePromResp(w, protocol.CodeOK, protocol.PromQueryData{ResultType: protocol.PromResultVector, Result: vector})
+}
+
+// promMatchSelectors parse match[] parameters to selectors
+func promMatchSelectors(r *http.Request) ([]*expr.SelectorNode, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+
+	var selectors []*expr.SelectorNode
+	for _, match := range r.Form["match[]"] {
+		node, err := promql.Parse(match)
+		if err != nil {
+			return nil, err
+		}
+		selectors = append(selectors, expr.Selectors(node)...)
+	}
+	return selectors, nil
+}
+
+func sortedUnique(values []string) []string {
+	sort.Strings(values)
+	result := make([]string, 0, len(values))
+	for i, v := range values {
+		if i == 0 || v != values[i-1] {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// QueryPromLabels Prometheus compatible /api/v1/labels, labels are tag keys of metrics in match[], or of all metrics
+func QueryPromLabels(w http.ResponseWriter, r *http.Request) {
+	defer monitor.AddMonitorStats(time.Now(), "promLabels")
+	ctx, cancel := queryContext(r)
+	defer cancel()
+
+	selectors, err := promMatchSelectors(r)
+	if err != nil {
+		newlog.Error("queryPromLabels: parse match[] failed: %v", err)
+		writePromResp(w, protocol.CodeExpressionError, nil)
+		return
+	}
+
+	labels := []string{"__name__"}
+	if len(selectors) == 0 {
+		tagKeys, code := internalQueryTagsOfAllMetrics(ctx, "tag_name", "", "", MaxLabelValues)
+		if code != protocol.CodeOK {
+			writePromResp(w, code, nil)
+			return
+		}
+		labels = append(labels, tagKeys...)
+	}
+
+	for _, selector := range selectors {
+		tagKeys, code := internalQueryTagKeys(ctx, selector.Metric)
+		if code != protocol.CodeOK {
+			writePromResp(w, code, nil)
+			return
+		}
+		labels = append(labels, tagKeys...)
+	}
+	writePromResp(w, protocol.CodeOK, sortedUnique(labels))
+}
+
+// QueryPromLabelValues Prometheus compatible /api/v1/label/<name>/values, values of __name__ are metrics
+func QueryPromLabelValues(w http.ResponseWriter, r *http.Request) {
+	defer monitor.AddMonitorStats(time.Now(), "promLabelValues")
+	ctx, cancel := queryContext(r)
+	defer cancel()
+
+	name := strings.TrimPrefix(r.URL.Path, protocol.PromLabelValuesUrl)
+	if !strings.HasSuffix(name, "/values") || strings.Count(name, "/") != 1 {
+		writePromResp(w, protocol.CodeApiNotFound, nil)
+		return
+	}
+	name = strings.TrimSuffix(name, "/values")
+
+	selectors, err := promMatchSelectors(r)
+	if err != nil {
+		newlog.Error("queryPromLabelValues: parse match[] failed: %v", err)
+		writePromResp(w, protocol.CodeExpressionError, nil)
+		return
+	}
+
+	var values []string
+	var code int
+	switch {
+	case name == "__name__":
+		values, code = internalQueryMetrics(ctx, "")
+	case len(selectors) == 0:
+		values, code = internalQueryTagsOfAllMetrics(ctx, "tag_value", name, "", MaxLabelValues)
+	default:
+		values, code = queryPromLabelValues(ctx, name, selectors)
+	}
+
+	if code != protocol.CodeOK {
+		writePromResp(w, code, nil)
+		return
+	}
+	writePromResp(w, protocol.CodeOK, sortedUnique(values))
+}
+
+// queryPromLabelValues query values of the label in series selected by selectors
+func queryPromLabelValues(ctx context.Context, name string, selectors []*expr.SelectorNode) ([]string, int) {
+	var values []string
+	for _, selector := range selectors {
+		promSel, ok, code := promSelector(ctx, selector)
+		if code != protocol.CodeOK {
+			return nil, code
+		}
+
+		if !ok {
+			continue // the selector can not match any series of the metric
+		}
+
+		if _, exist := promSel.Tags[name]; !exist {
+			continue // the metric does not have the label
+		}
+
+		curves, code := internalQueryCurves(ctx, &protocol.MetricReq{Metric: promSel.Metric,
+			Tags: map[string]string{name: "*"}, Matchers: promSel.Matchers})
+		if code != protocol.CodeOK {
+			return nil, code
+		}
+
+		for _, curve := range curves {
+			values = append(values, curve[name])
+		}
+	}
+	return values, protocol.CodeOK
+}
@@ -1,23 +1,37 @@
 package tsdb
 
 import (
+	"context"
+	"database/sql/driver"
 	"github.com/sentrycloud/sentry/pkg/newlog"
 	"github.com/sentrycloud/sentry/pkg/protocol"
+	"github.com/sentrycloud/sentry/pkg/server/expr"
+	"github.com/sentrycloud/sentry/pkg/server/metadata"
 	"github.com/sentrycloud/sentry/pkg/server/monitor"
 	"net/http"
+	"sort"
 	"time"
 )
 
-func internalQueryRange(start int64, end int64, offset int64, aggregator string, downSample int64,
+func internalQueryRange(ctx context.Context, start int64, end int64, offset int64, aggregator string, downSample int64,
 	metricReq *protocol.MetricReq) (*protocol.CurveData, int) {
-	sql := buildRangeQuerySql(start+offset, end+offset, aggregator, downSample, metricReq)
-	results, e := QueryTSDB(sql, 2)
+	sql, e := buildRangeQuerySql(start+offset, end+offset, aggregator, downSample, metricReq)
 	if e != nil {
-		return nil, protocol.CodeExecTSDBSqlError
+		newlog.Error("build range query sql failed: %v", e)
+		return nil, protocol.CodeSqlParamError
+	}
+
+	results, e := QueryTSDB(ctx, sql, 2)
+	if e != nil {
+		return nil, queryErrorCode(e)
 	}
 
 	var dataPoints []protocol.TimeValuePoint
 	for _, row := range results {
+		if row[1] == nil {
+			continue // aggregator like stddev may return null for some interval
+		}
+
 		point := protocol.TimeValuePoint{
 			TimeStamp: (row[0].(int64) - offset) / 1000, // add back offset, so multiple line can be displayed on the same axis
 			Value:     row[1].(float64),
@@ -35,8 +49,144 @@ func internalQueryRange(start int64, end int64, offset int64, aggregator string,
 	return &curveData, protocol.CodeOK
 }
 
+// internalQueryCounterRange apply the counter function to each child table of the metric, then aggregate them into one curve,
+// so a counter reset or a new child table is not taken as a drop or a jump of the aggregated counter
+func internalQueryCounterRange(ctx context.Context, start int64, end int64, function string, aggregator string, downSample int64,
+	metricReq *protocol.MetricReq) (*protocol.CurveData, int) {
+	queryStart := start
+	if function != protocol.FunctionIRate {
+		queryStart -= downSample * 1000 // query one more interval, so the first interval in range has a previous one
+	}
+
+	sql, e := buildCounterRangeQuerySql(queryStart, end, function, downSample, metricReq)
+	if e != nil {
+		newlog.Error("build counter range query sql failed: %v", e)
+		return nil, protocol.CodeSqlParamError
+	}
+
+	results, e := QueryTSDB(ctx, sql, 3)
+	if e != nil {
+		return nil, queryErrorCode(e)
+	}
+
+	dataPoints, e := aggregateCounterRows(results, function, aggregator, start)
+	if e != nil {
+		newlog.Error("aggregate counter of metric=%s failed: %v", metricReq.Metric, e)
+		return nil, protocol.CodeAggregatorError
+	}
+
+	curveData := protocol.CurveData{
+		Metric: metricReq.Metric,
+		Tags:   metricReq.Tags,
+		DPS:    dataPoints,
+	}
+	return &curveData, protocol.CodeOK
+}
+
+// aggregateCounterRows split rows of the counter range query to a series for each child table, apply the counter function
+// to each series, then aggregate the series, a row is [interval start in milliseconds, value, child table name],
+// and data points before start are dropped
+func aggregateCounterRows(results [][]driver.Value, function string, aggregator string, start int64) ([]protocol.TimeValuePoint, error) {
+	var series []*protocol.CurveData
+	tables := make(map[string]*protocol.CurveData)
+	for _, row := range results {
+		if row[1] == nil {
+			continue
+		}
+
+		table, _ := row[2].(string)
+		curveData, exist := tables[table]
+		if !exist {
+			curveData = &protocol.CurveData{}
+			tables[table] = curveData
+			series = append(series, curveData)
+		}
+
+		curveData.DPS = append(curveData.DPS, protocol.TimeValuePoint{TimeStamp: row[0].(int64) / 1000, Value: row[1].(float64)})
+	}
+
+	// data points of a partition may not be returned in order
+	for _, curveData := range series {
+		sort.Slice(curveData.DPS, func(i, j int) bool {
+			return curveData.DPS[i].TimeStamp < curveData.DPS[j].TimeStamp
+		})
+	}
+	expr.ApplyCounterFunction(function, series)
+
+	dps, err := expr.AggregateSeries(aggregator, series)
+	if err != nil {
+		return nil, err
+	}
+
+	var dataPoints []protocol.TimeValuePoint
+	for _, dp := range dps {
+		if dp.TimeStamp*1000 >= start {
+			dataPoints = append(dataPoints, dp)
+		}
+	}
+	return dataPoints, nil
+}
+
+// internalQueryTimeSeriesData query curves of metrics and expressions in a transferred request
+func internalQueryTimeSeriesData(ctx context.Context, req *protocol.TimeSeriesDataRequest) ([]*protocol.CurveData, int) {
+	queryType := "range"
+	if len(req.Function) > 0 {
+		queryType = req.Function
+	}
+
+	var curveDataList []*protocol.CurveData
+	for _, m := range req.Metrics {
+		key := rangeCacheKey(queryType, &m, req.Aggregator, req.DownSample, 0)
+		curves, retCode := cachedQueryCurves(ctx, key, req.Start, req.End, 0, req.DownSample, func(start int64, end int64) ([]*protocol.CurveData, int) {
+			metricReq := protocol.MetricReq{Metric: m.Metric, Tags: copyTags(m.Tags), Filters: m.Filters, Matchers: m.Matchers}
+			var curveData *protocol.CurveData
+			var code int
+			if len(req.Function) > 0 {
+				curveData, code = internalQueryCounterRange(ctx, start, end, req.Function, req.Aggregator, req.DownSample, &metricReq)
+			} else {
+				curveData, code = internalQueryRange(ctx, start, end, 0, req.Aggregator, req.DownSample, &metricReq)
+			}
+
+			if code != protocol.CodeOK {
+				return nil, code
+			}
+			return []*protocol.CurveData{curveData}, protocol.CodeOK
+		})
+
+		if retCode != protocol.CodeOK {
+			return nil, retCode
+		}
+		curveDataList = append(curveDataList, curves...)
+	}
+
+	fetch := newExpressionFetcher(ctx, req.Start, req.End, 0, req.Aggregator, req.DownSample, nil)
+	for _, expression := range req.Expressions {
+		expressionCurves, retCode := queryExpression(expression, fetch)
+		if retCode != protocol.CodeOK {
+			return nil, retCode
+		}
+
+		for _, curveData := range expressionCurves {
+			curveData.Metric = expression
+			curveDataList = append(curveDataList, curveData)
+		}
+	}
+
+	_, window, _ := protocol.CheckSmooth(req.Smooth, req.SmoothWindow)
+	expr.ApplySmoothFunction(req.Smooth, window, curveDataList)
+
+	for _, curveData := range curveDataList {
+		curveData.DPS = protocol.FillDataPoints(curveData.DPS, req.Start, req.End, req.DownSample, req.Fill)
+		curveData.DownSample = req.DownSample
+		curveData.Meta = metadata.Get(curveData.Metric)
+	}
+	return curveDataList, protocol.CodeOK
+}
+
 func QueryTimeSeriesDataForRange(w http.ResponseWriter, r *http.Request) {
 	defer monitor.AddMonitorStats(time.Now(), "range")
+	ctx, cancel := queryContext(r)
+	defer cancel()
 
 	var req protocol.TimeSeriesDataRequest
 	err := protocol.DecodeRequest(r, &req)
@@ -46,22 +196,18 @@ func QueryTimeSeriesDataForRange(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	code := transferTimeSeriesDataRequest(&req)
+	code := transferTimeSeriesDataRequest(&req, false)
 	if code != protocol.CodeOK {
 		newlog.Error("queryTimeSeriesDataForRange: transferTimeSeriesDataRequest failed: %s", protocol.CodeMsg[code])
 		protocol.WriteQueryResp(w, code, nil)
 		return
 	}
 
-	var curveDataList []*protocol.CurveData
-	for _, m := range req.Metrics {
-		curveData, retCode := internalQueryRange(req.Start, req.End, 0, req.Aggregator, req.DownSample, &m)
-		if retCode != protocol.CodeOK {
-			protocol.WriteQueryResp(w, retCode, nil)
-			return
-		}
-		curveDataList = append(curveDataList, curveData)
+	ctx, debug := withQueryDebug(ctx, req.Debug)
+	debug.setAlignment(req.Start, req.End, req.DownSample)
+	curveDataList, code := internalQueryTimeSeriesData(ctx, &req)
+	for _, curveData := range curveDataList {
+		debug.addCurve(curveData)
 	}
-
-	protocol.WriteQueryResp(w, protocol.CodeOK, curveDataList)
+	protocol.WriteDebugQueryResp(w, code, curveDataList, debug.result())
 }
@@ -0,0 +1,37 @@
+package tsdb
+
+import (
+	"database/sql/driver"
+	"github.com/sentrycloud/sentry/pkg/protocol"
+	"testing"
+)
+
+func TestAggregateCounterRows(t *testing.T) {
+	results := [][]driver.Value{
+		{int64(10000), 110.0, "t2"},
+		{int64(0), 10.0, "t1"},
+		{int64(10000), 20.0, "t1"},
+		{int64(20000), 5.0, "t1"}, // counter of t1 is reset
+		{int64(0), 100.0, "t2"},
+		{int64(30000), 15.0, "t1"},
+		{int64(20000), 120.0, "t2"},
+		{int64(30000), 130.0, "t2"},
+	}
+
+	// rate of each child table is summed, the reset of t1 does not make the sum drop
+	dps, err := aggregateCounterRows(results, protocol.FunctionRate, "sum", 20000)
+	expect := []protocol.TimeValuePoint{{TimeStamp: 20, Value: 1.5}, {TimeStamp: 30, Value: 2}}
+	if err != nil || !equalPoints(dps, expect) {
+		t.Errorf("expect %v, got %v, err=%v", expect, dps, err)
+	}
+
+	dps, err = aggregateCounterRows(results, protocol.FunctionIncrease, "max", 10000)
+	expect = []protocol.TimeValuePoint{{TimeStamp: 10, Value: 10}, {TimeStamp: 20, Value: 10}, {TimeStamp: 30, Value: 10}}
+	if err != nil || !equalPoints(dps, expect) {
+		t.Errorf("expect %v, got %v, err=%v", expect, dps, err)
+	}
+
+	if _, err = aggregateCounterRows(results, protocol.FunctionRate, "last", 0); err == nil {
+		t.Errorf("aggregator last should not be supported for counter function")
+	}
+}
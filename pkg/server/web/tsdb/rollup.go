@@ -0,0 +1,108 @@
+package tsdb
+
+import (
+	"github.com/sentrycloud/sentry/pkg/protocol"
+	"github.com/sentrycloud/sentry/pkg/server/taos"
+)
+
+const MaxRollupQueryRange = 3600 * 24 * 90 // query range longer than MaxQueryRange use rollup tables, up to 90 days
+
+var rollupEnabled bool
+
+// InitRollup enable routing long range queries to rollup tables, rollup tables are created by taos.StartRollup
+func InitRollup(enable bool) {
+	rollupEnabled = enable
+}
+
+func maxQueryRange() int64 {
+	if rollupEnabled {
+		return MaxRollupQueryRange
+	}
+	return MaxQueryRange
+}
+
+// rollupAggregatorFunction map aggregator to function on sum/count/min/max columns of rollup tables
+func rollupAggregatorFunction(aggregator string) (string, bool) {
+	switch aggregator {
+	case "sum":
+		return "SUM(_sum)", true
+	case "count":
+		return "CAST(SUM(_count) as DOUBLE)", true
+	case "avg":
+		return "SUM(_sum)/SUM(_count)", true
+	case "max":
+		return "MAX(_max)", true
+	case "min":
+		return "MIN(_min)", true
+	case "spread":
+		return "MAX(_max)-MIN(_min)", true
+	}
+	return "", false
+}
+
+// chooseRollup return the coarsest rollup that the down sample is a multiple of, nil if the aggregator is not supported
+func chooseRollup(aggregator string, downSample int64) *taos.Rollup {
+	if _, ok := rollupAggregatorFunction(aggregator); !ok {
+		return nil
+	}
+
+	for i := range taos.Rollups {
+		if downSample%taos.Rollups[i].Interval == 0 {
+			return &taos.Rollups[i]
+		}
+	}
+	return nil
+}
+
+// checkRollupQuery check whether a query longer than MaxQueryRange can be answered by rollup tables
+func checkRollupQuery(aggregator string, downSample int64) int {
+	if _, ok := rollupAggregatorFunction(aggregator); !ok {
+		return protocol.CodeAggregatorError
+	}
+
+	if chooseRollup(aggregator, downSample) == nil {
+		return protocol.CodeDownSampleError
+	}
+	return protocol.CodeOK
+}
+
+// querySource return the table and aggregate function of a range query, and whether it's a rollup table,
+// start and end are in milliseconds. rollup rows are at the start of their interval, so start is included for rollup tables
+func querySource(metric string, aggregator string, start int64, end int64, downSample int64) (string, string, bool) {
+	if rollupEnabled && end-start > MaxQueryRange*1000 {
+		if rollup := chooseRollup(aggregator, downSample); rollup != nil {
+			aggregate, _ := rollupAggregatorFunction(aggregator)
+			return taos.RollupTableName(metric, *rollup), aggregate, true
+		}
+	}
+	return metric, protocol.AggregatorFunction(aggregator, "_value"), false
+}
+
+// counterRollupAggregator return the aggregator of each child table in an interval for the counter function,
+// the max of a counter is its last value unless the counter is reset, and the avg of a gauge is used for derivative.
+// rollup tables do not keep what irate needs, so irate is not supported for them
+func counterRollupAggregator(function string) string {
+	switch function {
+	case protocol.FunctionIRate:
+		return protocol.FunctionIRate
+	case protocol.FunctionDerivative:
+		return "avg"
+	}
+	return "max"
+}
+
+// counterSource return the table and the function on each child table of a counter function query, and whether it's a rollup table
+func counterSource(metric string, function string, start int64, end int64, downSample int64) (string, string, bool) {
+	if function == protocol.FunctionIRate {
+		return metric, "IRATE(_value)", false
+	}
+
+	if rollupEnabled && end-start > MaxQueryRange*1000 {
+		aggregator := counterRollupAggregator(function)
+		if rollup := chooseRollup(aggregator, downSample); rollup != nil {
+			aggregate, _ := rollupAggregatorFunction(aggregator)
+			return taos.RollupTableName(metric, *rollup), aggregate, true
+		}
+	}
+	return metric, "LAST(_value)", false
+}
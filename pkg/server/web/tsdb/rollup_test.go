@@ -0,0 +1,48 @@
+package tsdb
+
+import (
+	"github.com/sentrycloud/sentry/pkg/protocol"
+	"github.com/sentrycloud/sentry/pkg/server/taos"
+	"strings"
+	"testing"
+)
+
+func TestRollupQuery(t *testing.T) {
+	InitRollup(true)
+	defer InitRollup(false)
+
+	day := int64(24 * 3600 * 1000)
+	req := protocol.MetricReq{Metric: "m", Tags: map[string]string{"ip": "1"}}
+
+	// short range query the raw table
+	sql, _ := buildRangeQuerySql(0, day, "avg", 300, &req)
+	if !strings.Contains(sql, "AVG(_value) FROM `m` WHERE _ts > 0") {
+		t.Errorf("short range should query raw table: %s", sql)
+	}
+
+	// long range use the coarsest rollup that down sample is a multiple of
+	sql, _ = buildRangeQuerySql(0, 30*day, "avg", 7200, &req)
+	if !strings.Contains(sql, "SUM(_sum)/SUM(_count) FROM `m__rollup_1h` WHERE _ts >= 0") {
+		t.Errorf("long range should query 1h rollup: %s", sql)
+	}
+
+	sql, _ = buildRangeQuerySql(0, 30*day, "max", 1800, &req)
+	if !strings.Contains(sql, "MAX(_max) FROM `m__rollup_5m`") {
+		t.Errorf("long range should query 5m rollup: %s", sql)
+	}
+
+	// not supported by rollup, fallback to raw table
+	sql, _ = buildRangeQuerySql(0, 30*day, "stddev", 3600, &req)
+	if !strings.Contains(sql, "FROM `m` WHERE") {
+		t.Errorf("stddev should query raw table: %s", sql)
+	}
+
+	if checkRollupQuery("last", 3600) != protocol.CodeAggregatorError || checkRollupQuery("sum", 60) != protocol.CodeDownSampleError ||
+		checkRollupQuery("count", 600) != protocol.CodeOK {
+		t.Errorf("check rollup query failed")
+	}
+
+	if !taos.IsRollupTable(taos.RollupTableName("m", taos.Rollups[0])) {
+		t.Errorf("rollup table name not recognized")
+	}
+}
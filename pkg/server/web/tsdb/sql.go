@@ -0,0 +1,422 @@
+package tsdb
+
+import (
+	"fmt"
+	"github.com/sentrycloud/sentry/pkg/protocol"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const maxIdentifierLength = 192 // max table name length of TDengine
+
+var (
+	literalReplacer = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	likeReplacer    = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+)
+
+// checkIdentifier check metric or tag key, backtick, quotes, backslash and control characters are not allowed,
+// so the identifier can be quoted by backtick safely
+func checkIdentifier(name string) error {
+	if len(name) == 0 || len(name) > maxIdentifierLength {
+		return fmt.Errorf("invalid identifier length: %q", name)
+	}
+
+	for _, c := range name {
+		if c < 0x20 || c == 0x7f || strings.ContainsRune("`'\"\\", c) {
+			return fmt.Errorf("invalid character in identifier: %q", name)
+		}
+	}
+	return nil
+}
+
+// quoteLiteral quote a string literal with single quote, backslash and single quote in the value are escaped
+func quoteLiteral(v string) string {
+	return "'" + literalReplacer.Replace(v) + "'"
+}
+
+// escapeLike escape wildcards in value, so only wildcards added by the caller take effect in LIKE
+func escapeLike(v string) string {
+	return likeReplacer.Replace(v)
+}
+
+// sqlBuilder build a SELECT statement, identifiers are validated and quoted with backtick, literals are escaped.
+// columns and order by accept expressions built by the server only, such as aggregate functions, never user input.
+// the first error is kept and returned by Build, so calls can be chained
+type sqlBuilder struct {
+	distinct   bool
+	columns    []string
+	from       string
+	conditions []string
+	partitions []string
+	groups     []string
+	interval   int64
+	orderBy    string
+	limit      int
+	hasLimit   bool
+	err        error
+}
+
+func selectFrom(table string) *sqlBuilder {
+	b := &sqlBuilder{}
+	b.from = b.identifier(table)
+	return b
+}
+
+func selectFromQuery(query *sqlBuilder) *sqlBuilder {
+	b := &sqlBuilder{}
+	sql, err := query.Build()
+	b.setError(err)
+	b.from = "(" + sql + ")"
+	return b
+}
+
+func (b *sqlBuilder) setError(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+func (b *sqlBuilder) identifier(name string) string {
+	if err := checkIdentifier(name); err != nil {
+		b.setError(err)
+		return ""
+	}
+	return "`" + name + "`"
+}
+
+func (b *sqlBuilder) literal(v string) string {
+	if strings.ContainsRune(v, 0) {
+		b.setError(fmt.Errorf("invalid character in value: %q", v)) // the C driver stop at \0
+	}
+	return quoteLiteral(v)
+}
+
+func (b *sqlBuilder) Distinct() *sqlBuilder {
+	b.distinct = true
+	return b
+}
+
+// Column add an expression built by the server, like CAST(FIRST(_ts) as BIGINT)
+func (b *sqlBuilder) Column(expression string) *sqlBuilder {
+	b.columns = append(b.columns, expression)
+	return b
+}
+
+func (b *sqlBuilder) TagColumn(keys ...string) *sqlBuilder {
+	for _, key := range keys {
+		b.columns = append(b.columns, b.identifier(key))
+	}
+	return b
+}
+
+// TimeRange add time condition in milliseconds, start is excluded unless includeStart is true
+func (b *sqlBuilder) TimeRange(start int64, end int64, includeStart bool) *sqlBuilder {
+	op := ">"
+	if includeStart {
+		op = ">="
+	}
+	b.conditions = append(b.conditions, fmt.Sprintf("_ts %s %d AND _ts < %d", op, start, end))
+	return b
+}
+
+// Where add a condition of tag key and value, op is one of =, !=, >, LIKE, MATCH and NMATCH
+func (b *sqlBuilder) Where(key string, op string, value string) *sqlBuilder {
+	switch op {
+	case "=", "!=", ">", "LIKE", "MATCH", "NMATCH":
+		b.conditions = append(b.conditions, b.identifier(key)+" "+op+" "+b.literal(value))
+	default:
+		b.setError(fmt.Errorf("invalid operator: %s", op))
+	}
+	return b
+}
+
+// WhereAfter add a condition that the timestamp column is after the time in milliseconds
+func (b *sqlBuilder) WhereAfter(column string, timestamp int64) *sqlBuilder {
+	b.conditions = append(b.conditions, b.identifier(column)+" > "+strconv.FormatInt(timestamp, 10))
+	return b
+}
+
+func (b *sqlBuilder) WhereNull(key string, isNull bool) *sqlBuilder {
+	if isNull {
+		b.conditions = append(b.conditions, b.identifier(key)+" IS NULL")
+	} else {
+		b.conditions = append(b.conditions, b.identifier(key)+" IS NOT NULL")
+	}
+	return b
+}
+
+func (b *sqlBuilder) WherePrefix(key string, prefix string) *sqlBuilder {
+	return b.Where(key, "LIKE", escapeLike(prefix)+"%")
+}
+
+// WhereTags add equal conditions of tags, key with != prefix is a not equal condition,
+// and the not equal key is deleted, so it will not appear in the returned tags map
+func (b *sqlBuilder) WhereTags(tags map[string]string) *sqlBuilder {
+	for _, k := range sortedKeys(tags) {
+		if strings.HasPrefix(k, "!=") {
+			b.Where(k[2:], "!=", tags[k])
+			delete(tags, k)
+		} else {
+			b.Where(k, "=", tags[k])
+		}
+	}
+	return b
+}
+
+// WhereFilters add conditions of tag values split by ||, any value matches for equal key,
+// and none of the values matches for key with != prefix
+func (b *sqlBuilder) WhereFilters(filters map[string][]string) *sqlBuilder {
+	var keys []string
+	for k := range filters {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		op := "="
+		logicalOp := " OR "
+		column := key
+		if strings.HasPrefix(key, "!=") {
+			op = "!="
+			logicalOp = " AND "
+			column = key[2:]
+		}
+
+		var conditions []string
+		for _, v := range filters[key] {
+			conditions = append(conditions, b.identifier(column)+" "+op+" "+b.literal(v))
+		}
+
+		if len(conditions) > 0 {
+			b.conditions = append(b.conditions, "("+strings.Join(conditions, logicalOp)+")")
+		}
+	}
+	return b
+}
+
+func (b *sqlBuilder) WhereMatchers(matchers []protocol.TagMatcher) *sqlBuilder {
+	for _, m := range matchers {
+		switch m.Op {
+		case protocol.MatchEqual, protocol.MatchNotEqual:
+			b.Where(m.Key, m.Op, m.Value)
+		case protocol.MatchRegex:
+			b.Where(m.Key, "MATCH", m.Value)
+		case protocol.MatchNotRegex:
+			b.Where(m.Key, "NMATCH", m.Value)
+		case protocol.MatchContains:
+			b.Where(m.Key, "LIKE", "%"+escapeLike(m.Value)+"%")
+		case protocol.MatchAbsent:
+			b.WhereNull(m.Key, true)
+		default:
+			b.setError(fmt.Errorf("invalid matcher op: %s", m.Op))
+		}
+	}
+	return b
+}
+
+func (b *sqlBuilder) PartitionBy(keys ...string) *sqlBuilder {
+	for _, key := range keys {
+		b.partitions = append(b.partitions, b.identifier(key))
+	}
+	return b
+}
+
+// PartitionByTable partition rows by child table, each child table is a series of the metric
+func (b *sqlBuilder) PartitionByTable() *sqlBuilder {
+	b.partitions = append(b.partitions, "tbname")
+	return b
+}
+
+func (b *sqlBuilder) GroupBy(keys ...string) *sqlBuilder {
+	for _, key := range keys {
+		b.groups = append(b.groups, b.identifier(key))
+	}
+	return b
+}
+
+// Interval set the window of down sample in seconds
+func (b *sqlBuilder) Interval(seconds int64) *sqlBuilder {
+	if seconds <= 0 {
+		b.setError(fmt.Errorf("invalid interval: %d", seconds))
+	}
+	b.interval = seconds
+	return b
+}
+
+// OrderBy order by a column expression built by the server, order is asc or desc
+func (b *sqlBuilder) OrderBy(column string, order string) *sqlBuilder {
+	if order != "asc" && order != "desc" {
+		b.setError(fmt.Errorf("invalid order: %s", order))
+	}
+	b.orderBy = column + " " + order
+	return b
+}
+
+func (b *sqlBuilder) Limit(limit int) *sqlBuilder {
+	if limit < 0 {
+		b.setError(fmt.Errorf("invalid limit: %d", limit))
+	}
+	b.limit = limit
+	b.hasLimit = true
+	return b
+}
+
+func (b *sqlBuilder) Build() (string, error) {
+	if b.err != nil {
+		return "", b.err
+	}
+
+	if len(b.columns) == 0 {
+		return "", fmt.Errorf("no column selected")
+	}
+
+	var sql strings.Builder
+	sql.WriteString("SELECT ")
+	if b.distinct {
+		sql.WriteString("DISTINCT ")
+	}
+	sql.WriteString(strings.Join(b.columns, ","))
+	sql.WriteString(" FROM ")
+	sql.WriteString(b.from)
+
+	if len(b.conditions) > 0 {
+		sql.WriteString(" WHERE ")
+		sql.WriteString(strings.Join(b.conditions, " AND "))
+	}
+
+	if len(b.partitions) > 0 {
+		sql.WriteString(" PARTITION BY ")
+		sql.WriteString(strings.Join(b.partitions, ","))
+	}
+
+	if len(b.groups) > 0 {
+		sql.WriteString(" GROUP BY ")
+		sql.WriteString(strings.Join(b.groups, ","))
+	}
+
+	if b.interval > 0 {
+		sql.WriteString(" INTERVAL(" + strconv.FormatInt(b.interval, 10) + "s)")
+	}
+
+	if len(b.orderBy) > 0 {
+		sql.WriteString(" ORDER BY " + b.orderBy)
+	}
+
+	if b.hasLimit {
+		sql.WriteString(" LIMIT " + strconv.Itoa(b.limit))
+	}
+	return sql.String(), nil
+}
+
+// buildShowMetricsSql query metrics that contain the name
+func buildShowMetricsSql(name string) (string, error) {
+	if strings.ContainsRune(name, 0) {
+		return "", fmt.Errorf("invalid character in metric: %q", name)
+	}
+	return "SHOW STABLES LIKE " + quoteLiteral("%"+escapeLike(name)+"%"), nil
+}
+
+// buildSuggestTagSql query distinct tag keys or values that start with prefix in all metrics of the database,
+// column is tag_name or tag_value of information_schema.ins_tags, tag values are of tagKey if it's not empty
+func buildSuggestTagSql(column string, database string, tagKey string, prefix string, limit int) (string, error) {
+	b := &sqlBuilder{from: "information_schema.ins_tags"}
+	b.Distinct().TagColumn(column).Where("db_name", "=", database)
+	if len(tagKey) > 0 {
+		b.Where("tag_name", "=", tagKey)
+	}
+
+	if len(prefix) > 0 {
+		b.WherePrefix(column, prefix)
+	}
+	return b.Limit(limit).Build()
+}
+
+// buildTagValueSearchSql query distinct values of the key in ascending order after the cursor, star tags of other keys
+// narrow the series like buildCurvesRequest, limit should be one more than the page size to know if there is a next page
+func buildTagValueSearchSql(req *protocol.TagValueSearchRequest, limit int) (string, error) {
+	starTags := make(map[string]string)
+	tags := make(map[string]string)
+	filters := make(map[string][]string)
+	for k, v := range req.Tags {
+		switch {
+		case k == req.Key:
+			continue // the searched key is conditioned by search and cursor
+		case strings.HasSuffix(v, "*"):
+			starTags[k] = v[:len(v)-1]
+		case strings.Contains(v, "||"):
+			filters[k] = strings.Split(v, "||")
+		default:
+			tags[k] = v
+		}
+	}
+
+	b := selectFrom(req.Metric).Distinct().TagColumn(req.Key).WhereNull(req.Key, false)
+	for _, k := range sortedKeys(starTags) {
+		b.WhereNull(k, false)
+		if prefix := starTags[k]; len(prefix) > 0 {
+			b.WherePrefix(k, prefix)
+		}
+	}
+
+	if len(req.Search) > 0 {
+		if req.Match == protocol.SearchContains {
+			b.Where(req.Key, "LIKE", "%"+escapeLike(req.Search)+"%")
+		} else {
+			b.WherePrefix(req.Key, req.Search)
+		}
+	}
+
+	if len(req.Cursor) > 0 {
+		b.Where(req.Key, ">", req.Cursor)
+	}
+
+	b.WhereTags(tags).WhereFilters(filters).WhereMatchers(req.Matchers)
+	return b.OrderBy(b.identifier(req.Key), "asc").Limit(limit).Build()
+}
+
+// buildTableCountSql count child tables of the metric, or tables created after createdAfter if it's not 0
+func buildTableCountSql(database string, metric string, createdAfter int64) (string, error) {
+	b := &sqlBuilder{from: "information_schema.ins_tables"}
+	b.Column("COUNT(*)").Where("db_name", "=", database).Where("stable_name", "=", metric)
+	if createdAfter > 0 {
+		b.WhereAfter("create_time", createdAfter)
+	}
+	return b.Build()
+}
+
+// buildTagCardinalitySql count distinct values of a tag key of the metric
+func buildTagCardinalitySql(metric string, tagKey string) (string, error) {
+	return selectFromQuery(selectFrom(metric).Distinct().TagColumn(tagKey)).Column("COUNT(*)").Build()
+}
+
+// buildNewTablesSql query the latest tables of the metric created after the time in milliseconds
+func buildNewTablesSql(database string, metric string, createdAfter int64, limit int) (string, error) {
+	b := &sqlBuilder{from: "information_schema.ins_tables"}
+	b.TagColumn("table_name").Column("CAST(create_time as BIGINT)").Where("db_name", "=", database).
+		Where("stable_name", "=", metric).WhereAfter("create_time", createdAfter)
+	return b.OrderBy("create_time", "desc").Limit(limit).Build()
+}
+
+// buildTableTagsSql query tags of the tables of the metric
+func buildTableTagsSql(database string, metric string, tables []string) (string, error) {
+	b := &sqlBuilder{from: "information_schema.ins_tags"}
+	b.TagColumn("table_name", "tag_name", "tag_value").Where("db_name", "=", database).Where("stable_name", "=", metric)
+	return b.WhereFilters(map[string][]string{"table_name": tables}).Build()
+}
+
+func buildDescSql(metric string) (string, error) {
+	if err := checkIdentifier(metric); err != nil {
+		return "", err
+	}
+	return "DESC `" + metric + "`", nil
+}
+
+func sortedKeys(tags map[string]string) []string {
+	var keys []string
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,206 @@
+package tsdb
+
+import (
+	"github.com/sentrycloud/sentry/pkg/protocol"
+	"strings"
+	"testing"
+)
+
+func TestMatchersToCondition(t *testing.T) {
+	matchers := []protocol.TagMatcher{
+		{Key: "ip", Op: protocol.MatchRegex, Value: `^10\.0\.`},
+		{Key: "app", Op: protocol.MatchNotRegex, Value: "test"},
+		{Key: "api", Op: protocol.MatchContains, Value: "50%_' OR '1'='1"},
+		{Key: "idc", Op: protocol.MatchAbsent},
+		{Key: "env", Op: protocol.MatchNotEqual, Value: `prod\`},
+	}
+
+	if err := protocol.CheckMatchers(matchers); err != nil {
+		t.Fatalf("check matchers failed: %v", err)
+	}
+
+	expect := "SELECT _value FROM `m` WHERE `ip` MATCH '^10\\\\.0\\\\.' AND `app` NMATCH 'test' AND `api` LIKE '%50\\\\%\\\\_\\' OR \\'1\\'=\\'1%' AND `idc` IS NULL AND `env` != 'prod\\\\'"
+	if sql, err := selectFrom("m").Column("_value").WhereMatchers(matchers).Build(); err != nil || sql != expect {
+		t.Errorf("expect %s, got %s, err=%v", expect, sql, err)
+	}
+
+	invalid := [][]protocol.TagMatcher{
+		{{Key: "ip`", Op: protocol.MatchEqual, Value: "1"}},
+		{{Key: "ip", Op: "like", Value: "1"}},
+		{{Key: "ip", Op: protocol.MatchRegex, Value: "("}},
+		{{Key: "ip", Op: protocol.MatchContains}},
+	}
+	for _, m := range invalid {
+		if protocol.CheckMatchers(m) == nil {
+			t.Errorf("expect invalid matchers: %v", m)
+		}
+	}
+}
+
+func TestHostileInput(t *testing.T) {
+	req := protocol.MetricReq{
+		Metric:  "m",
+		Tags:    map[string]string{"ip": "' OR '1'='1", "!=app": `web\`, "idc": `"bj"`},
+		Filters: map[string][]string{"api": {"/a", "'); DROP DATABASE sentry; --"}},
+	}
+
+	sql, err := buildRangeQuerySql(0, 1000, "sum", 10, &req)
+	expect := "SELECT CAST(FIRST(_ts) as BIGINT),SUM(_value) FROM `m` WHERE _ts > 0 AND _ts < 1000 AND `app` != 'web\\\\' AND " +
+		"`idc` = '\"bj\"' AND `ip` = '\\' OR \\'1\\'=\\'1' AND (`api` = '/a' OR `api` = '\\'); DROP DATABASE sentry; --') INTERVAL(10s)"
+	if err != nil || sql != expect {
+		t.Errorf("expect %s, got %s, err=%v", expect, sql, err)
+	}
+
+	if _, exist := req.Tags["!=app"]; exist {
+		t.Errorf("not equal key should be deleted from tags")
+	}
+
+	// identifiers with backtick, quotes, backslash or control characters are rejected
+	for _, name := range []string{"", "m`; DROP DATABASE sentry; --", "m'", "m\"", `m\`, "m\n", string(make([]byte, 193))} {
+		if _, err = buildDescSql(name); err == nil {
+			t.Errorf("expect invalid identifier: %q", name)
+		}
+
+		if _, err = buildRangeQuerySql(0, 1000, "sum", 10, &protocol.MetricReq{Metric: "m", Tags: map[string]string{name: "v"}}); err == nil {
+			t.Errorf("expect invalid tag key: %q", name)
+		}
+	}
+
+	if _, err = buildRangeQuerySql(0, 1000, "sum", 10, &protocol.MetricReq{Metric: "m", Tags: map[string]string{"ip": "1\x00"}}); err == nil {
+		t.Errorf("expect invalid value with \\0")
+	}
+
+	sql, _ = buildShowMetricsSql("cpu%_'; DROP DATABASE sentry; --")
+	if sql != "SHOW STABLES LIKE '%cpu\\\\%\\\\_\\'; DROP DATABASE sentry; --%'" {
+		t.Errorf("show metrics not escaped: %s", sql)
+	}
+
+	sql, _, err = buildCurvesRequest("m", map[string]string{}, map[string]string{"ip": "10.0_"}, nil)
+	if err != nil || sql != "SELECT DISTINCT `ip` FROM `m` WHERE `ip` IS NOT NULL AND `ip` LIKE '10.0\\\\_%'" {
+		t.Errorf("prefix not escaped: %s, err=%v", sql, err)
+	}
+
+	topn := protocol.TopNRequest{Metric: "m", Fields: []string{"ip"}, Aggregator: "sum", Order: "desc; DROP DATABASE sentry", Limit: 10}
+	if _, err = buildTopnQuerySql(&topn); err == nil {
+		t.Errorf("expect invalid order")
+	}
+
+	topn.Order = "asc"
+	sql, err = buildTopnQuerySql(&topn)
+	if err != nil || sql != "SELECT `ip`,v FROM (SELECT `ip`,SUM(_value) as v FROM `m` WHERE _ts > 0 AND _ts < 0 AND `ip` IS NOT NULL GROUP BY `ip`) ORDER BY v asc LIMIT 10" {
+		t.Errorf("build topn sql failed: %s, err=%v", sql, err)
+	}
+}
+
+func TestBuildTopnGroupKeysSql(t *testing.T) {
+	req := protocol.TopNRequest{
+		Start: 0, End: 10, DownSample: 1, Aggregator: "sum", Limit: 5, Metric: "m",
+		Tags: map[string]string{"ip": "*", "app": "*", "idc": "bj||sh", "env": "prod"}, GroupBy: []string{"ip"}, Others: true,
+	}
+	if code := transferTopnRequest(&req); code != protocol.CodeOK {
+		t.Fatalf("transfer topn request failed: %s", protocol.CodeMsg[code])
+	}
+
+	if strings.Join(req.Fields, ",") != "ip,app" {
+		t.Errorf("expect group keys of group_by first, got %v", req.Fields)
+	}
+
+	group := "SELECT `ip`,`app`,SUM(_value) as v FROM `m` WHERE _ts > 0 AND _ts < 9000 AND `env` = 'prod' AND (`idc` = 'bj' OR `idc` = 'sh') " +
+		"AND `ip` IS NOT NULL AND `app` IS NOT NULL GROUP BY `ip`,`app`"
+	sql, err := buildTopnQuerySql(&req)
+	if expect := "SELECT `ip`,`app`,v FROM (" + group + ") ORDER BY v desc LIMIT 5"; err != nil || sql != expect {
+		t.Errorf("expect %s, got %s, err=%v", expect, sql, err)
+	}
+
+	sql, err = buildTopnTotalSql(&req)
+	if expect := "SELECT SUM(v),COUNT(*) FROM (" + group + ")"; err != nil || sql != expect {
+		t.Errorf("expect %s, got %s, err=%v", expect, sql, err)
+	}
+
+	req = protocol.TopNRequest{Start: 1, End: 2, DownSample: 1, Aggregator: "max", Metric: "m", Tags: map[string]string{"ip": "*"}, Others: true}
+	if code := transferTopnRequest(&req); code != protocol.CodeAggregatorError {
+		t.Errorf("expect others not supported for max, got %s", protocol.CodeMsg[code])
+	}
+}
+
+func TestBuildTagValueSearchSql(t *testing.T) {
+	req := protocol.TagValueSearchRequest{
+		MetricReq: protocol.MetricReq{
+			Metric:   "m",
+			Tags:     map[string]string{"ip": "10.*", "app": "web*", "idc": "bj||sh", "env": "prod", "!=api": "/health"},
+			Matchers: []protocol.TagMatcher{{Key: "host", Op: protocol.MatchRegex, Value: "^a"}},
+		},
+		Key:    "ip",
+		Search: "10.0_",
+		Match:  protocol.SearchContains,
+		Cursor: "10.0_1",
+	}
+
+	expect := "SELECT DISTINCT `ip` FROM `m` WHERE `ip` IS NOT NULL AND `app` IS NOT NULL AND `app` LIKE 'web%' AND " +
+		"`ip` LIKE '%10.0\\\\_%' AND `ip` > '10.0_1' AND `api` != '/health' AND `env` = 'prod' AND " +
+		"(`idc` = 'bj' OR `idc` = 'sh') AND `host` MATCH '^a' ORDER BY `ip` asc LIMIT 11"
+	if sql, err := buildTagValueSearchSql(&req, 11); err != nil || sql != expect {
+		t.Errorf("expect %s, got %s, err=%v", expect, sql, err)
+	}
+
+	req = protocol.TagValueSearchRequest{MetricReq: protocol.MetricReq{Metric: "m"}, Key: "ip", Search: "10.", Match: protocol.SearchPrefix}
+	expect = "SELECT DISTINCT `ip` FROM `m` WHERE `ip` IS NOT NULL AND `ip` LIKE '10.%' ORDER BY `ip` asc LIMIT 101"
+	if sql, err := buildTagValueSearchSql(&req, 101); err != nil || sql != expect {
+		t.Errorf("expect %s, got %s, err=%v", expect, sql, err)
+	}
+}
+
+func TestBuildCardinalitySql(t *testing.T) {
+	sql, err := buildTableCountSql("sentry", "m", 1700000000000)
+	expect := "SELECT COUNT(*) FROM information_schema.ins_tables WHERE `db_name` = 'sentry' AND `stable_name` = 'm' AND `create_time` > 1700000000000"
+	if err != nil || sql != expect {
+		t.Errorf("expect %s, got %s, err=%v", expect, sql, err)
+	}
+
+	sql, err = buildTagCardinalitySql("m", "ip")
+	if err != nil || sql != "SELECT COUNT(*) FROM (SELECT DISTINCT `ip` FROM `m`)" {
+		t.Errorf("build tag cardinality sql failed: %s, err=%v", sql, err)
+	}
+
+	sql, err = buildTableTagsSql("sentry", "m", []string{"t1", "t'2"})
+	expect = "SELECT `table_name`,`tag_name`,`tag_value` FROM information_schema.ins_tags WHERE `db_name` = 'sentry' AND " +
+		"`stable_name` = 'm' AND (`table_name` = 't1' OR `table_name` = 't\\'2')"
+	if err != nil || sql != expect {
+		t.Errorf("expect %s, got %s, err=%v", expect, sql, err)
+	}
+
+	if _, err = buildTagCardinalitySql("m", "ip`"); err == nil {
+		t.Errorf("expect invalid tag key")
+	}
+}
+
+func TestBuildGroupRangeQuerySql(t *testing.T) {
+	sql, starKeys, err := buildGroupRangeQuerySql(1000, 9000, "sum", 10, "m", map[string]string{"env": "prod"},
+		map[string][]string{"idc": {"bj", "sh"}}, nil, map[string]string{"ip": "10.0.", "app": ""})
+	expect := "SELECT CAST(FIRST(_ts) as BIGINT),SUM(_value),`app`,`ip` FROM `m` WHERE _ts > 1000 AND _ts < 9000 AND `env` = 'prod' AND " +
+		"(`idc` = 'bj' OR `idc` = 'sh') AND `app` IS NOT NULL AND `ip` IS NOT NULL AND `ip` LIKE '10.0.%' PARTITION BY `app`,`ip` INTERVAL(10s)"
+	if err != nil || sql != expect {
+		t.Errorf("expect %s, got %s, err=%v", expect, sql, err)
+	}
+
+	if strings.Join(starKeys, ",") != "app,ip" {
+		t.Errorf("expect sorted star keys, got %v", starKeys)
+	}
+}
+
+func TestBuildCounterRangeQuerySql(t *testing.T) {
+	req := &protocol.MetricReq{Metric: "m", Tags: map[string]string{"app": "web"}}
+	sql, err := buildCounterRangeQuerySql(1000, 9000, protocol.FunctionRate, 10, req)
+	expect := "SELECT CAST(_wstart as BIGINT),LAST(_value),tbname FROM `m` WHERE _ts > 1000 AND _ts < 9000 AND `app` = 'web' " +
+		"PARTITION BY tbname INTERVAL(10s)"
+	if err != nil || sql != expect {
+		t.Errorf("expect %s, got %s, err=%v", expect, sql, err)
+	}
+
+	sql, err = buildCounterRangeQuerySql(1000, 9000, protocol.FunctionIRate, 10, req)
+	expect = "SELECT CAST(_wstart as BIGINT),IRATE(_value),tbname FROM `m` WHERE _ts > 1000 AND _ts < 9000 AND `app` = 'web' " +
+		"PARTITION BY tbname INTERVAL(10s)"
+	if err != nil || sql != expect {
+		t.Errorf("expect %s, got %s, err=%v", expect, sql, err)
+	}
+}
@@ -1,18 +1,24 @@
 package tsdb
 
 import (
-	"fmt"
+	"context"
+	"github.com/sentrycloud/sentry/pkg/newlog"
 	"github.com/sentrycloud/sentry/pkg/protocol"
 	"github.com/sentrycloud/sentry/pkg/server/monitor"
 	"net/http"
 	"time"
 )
 
-func internalQueryTagKeys(metric string) ([]string, int) {
-	sql := fmt.Sprintf("desc `%s`", metric)
-	results, err := QueryTSDB(sql, 4)
+func internalQueryTagKeys(ctx context.Context, metric string) ([]string, int) {
+	sql, err := buildDescSql(metric)
 	if err != nil {
-		return nil, protocol.CodeExecTSDBSqlError
+		newlog.Error("queryTagKeys: build sql failed: %v", err)
+		return nil, protocol.CodeSqlParamError
+	}
+
+	results, err := QueryTSDB(ctx, sql, 4)
+	if err != nil {
+		return nil, queryErrorCode(err)
 	}
 
 	var tags []string
@@ -28,6 +34,8 @@ func internalQueryTagKeys(metric string) ([]string, int) {
 // QueryTagKeys query all tags of a metric
 func QueryTagKeys(w http.ResponseWriter, r *http.Request) {
 	defer monitor.AddMonitorStats(time.Now(), "tagKey")
+	ctx, cancel := queryContext(r)
+	defer cancel()
 
 	var m protocol.MetricReq
 	err := protocol.DecodeRequest(r, &m)
@@ -36,6 +44,6 @@ func QueryTagKeys(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tags, code := internalQueryTagKeys(m.Metric)
+	tags, code := internalQueryTagKeys(ctx, m.Metric)
 	protocol.WriteQueryResp(w, code, tags)
 }
@@ -1,15 +1,122 @@
 package tsdb
 
 import (
+	"context"
 	"github.com/sentrycloud/sentry/pkg/newlog"
 	"github.com/sentrycloud/sentry/pkg/protocol"
 	"github.com/sentrycloud/sentry/pkg/server/monitor"
 	"net/http"
+	"strings"
 	"time"
 )
 
+// internalQueryTagsOfAllMetrics query distinct tag keys or tag values of all metrics, see buildSuggestTagSql
+func internalQueryTagsOfAllMetrics(ctx context.Context, column string, tagKey string, prefix string, limit int) ([]string, int) {
+	sql, err := buildSuggestTagSql(column, taosDatabase, tagKey, prefix, limit)
+	if err != nil {
+		newlog.Error("queryTagsOfAllMetrics: build sql failed: %v", err)
+		return nil, protocol.CodeSqlParamError
+	}
+
+	results, err := QueryTSDB(ctx, sql, 1)
+	if err != nil {
+		return nil, queryErrorCode(err)
+	}
+
+	var tags []string
+	for _, row := range results {
+		if v, ok := row[0].(string); ok {
+			tags = append(tags, v)
+		}
+	}
+	return tags, protocol.CodeOK
+}
+
+const (
+	DefaultTagValueLimit = 100
+	MaxTagValueLimit     = 1000
+)
+
+// internalSearchTagValues query a page of tag values, the last value is the cursor of the next page
+func internalSearchTagValues(ctx context.Context, req *protocol.TagValueSearchRequest) (*protocol.TagValueSearchResp, int) {
+	if len(req.Metric) == 0 {
+		return nil, protocol.CodeMetricError
+	}
+
+	if len(req.Key) == 0 || strings.Contains(req.Key, "*") {
+		return nil, protocol.CodeInvalidParamError
+	}
+
+	if len(req.Tags) > MaxTagCount {
+		return nil, protocol.CodeTagCountError
+	}
+
+	if err := protocol.CheckMatchers(req.Matchers); err != nil {
+		newlog.Error("searchTagValues: check matchers failed: %v", err)
+		return nil, protocol.CodeTagMatcherError
+	}
+
+	req.Match = strings.ToLower(req.Match)
+	if len(req.Match) == 0 {
+		req.Match = protocol.SearchPrefix
+	} else if req.Match != protocol.SearchPrefix && req.Match != protocol.SearchContains {
+		newlog.Error("searchTagValues: invalid match: %s", req.Match)
+		return nil, protocol.CodeInvalidParamError
+	}
+
+	if req.Limit <= 0 {
+		req.Limit = DefaultTagValueLimit
+	} else if req.Limit > MaxTagValueLimit {
+		req.Limit = MaxTagValueLimit
+	}
+
+	sql, err := buildTagValueSearchSql(req, req.Limit+1)
+	if err != nil {
+		newlog.Error("searchTagValues: build sql failed: %v", err)
+		return nil, protocol.CodeSqlParamError
+	}
+
+	results, err := QueryTSDB(ctx, sql, 1)
+	if err != nil {
+		return nil, queryErrorCode(err)
+	}
+
+	resp := &protocol.TagValueSearchResp{Values: []string{}}
+	for _, row := range results {
+		if v, ok := row[0].(string); ok {
+			resp.Values = append(resp.Values, v)
+		}
+	}
+
+	if len(resp.Values) > req.Limit {
+		resp.Values = resp.Values[:req.Limit]
+		resp.NextCursor = resp.Values[req.Limit-1]
+	}
+	return resp, protocol.CodeOK
+}
+
+// SearchTagValues search values of a tag key by pages, so the dashboard filter need not load all values of a large fleet
+func SearchTagValues(w http.ResponseWriter, r *http.Request) {
+	defer monitor.AddMonitorStats(time.Now(), "tagValueSearch")
+	ctx, cancel := queryContext(r)
+	defer cancel()
+
+	var req protocol.TagValueSearchRequest
+	err := protocol.DecodeRequest(r, &req)
+	if err != nil {
+		protocol.WriteQueryResp(w, protocol.CodeJsonDecodeError, nil)
+		return
+	}
+
+	resp, code := internalSearchTagValues(ctx, &req)
+	protocol.WriteQueryResp(w, code, resp)
+}
+
 func QueryTagValues(w http.ResponseWriter, r *http.Request) {
 	defer monitor.AddMonitorStats(time.Now(), "tagValue")
+	ctx, cancel := queryContext(r)
+	defer cancel()
+
 	var req protocol.MetricReq
 	err := protocol.DecodeRequest(r, &req)
 	if err != nil {
@@ -24,16 +131,28 @@ func QueryTagValues(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err = protocol.CheckMatchers(req.Matchers); err != nil {
+		newlog.Error("queryTagValues: check matchers failed: %v", err)
+		protocol.WriteQueryResp(w, protocol.CodeTagMatcherError, nil)
+		return
+	}
+
 	if len(starTags) != 1 {
 		newlog.Error("queryTagValues: too many or no star tags ")
 		protocol.WriteQueryResp(w, protocol.CodeStarKeysError, nil)
 		return
 	}
 
-	sql, _ := buildCurvesRequest(req.Metric, noStarTags, starTags)
-	results, err := QueryTSDB(sql, 1)
+	sql, _, err := buildCurvesRequest(req.Metric, noStarTags, starTags, req.Matchers)
+	if err != nil {
+		newlog.Error("queryTagValues: build sql failed: %v", err)
+		protocol.WriteQueryResp(w, protocol.CodeSqlParamError, nil)
+		return
+	}
+
+	results, err := QueryTSDB(ctx, sql, 1)
 	if err != nil {
-		protocol.WriteQueryResp(w, protocol.CodeExecTSDBSqlError, nil)
+		protocol.WriteQueryResp(w, queryErrorCode(err), nil)
 		return
 	}
 
@@ -1,43 +1,106 @@
 package tsdb
 
 import (
+	"context"
 	"github.com/sentrycloud/sentry/pkg/newlog"
 	"github.com/sentrycloud/sentry/pkg/protocol"
 	"github.com/sentrycloud/sentry/pkg/server/monitor"
 	"net/http"
+	"strings"
 	"time"
 )
 
-func internalQueryTopN(req *protocol.TopNRequest) ([]protocol.TopNData, int) {
+func internalQueryTopN(ctx context.Context, req *protocol.TopNRequest) ([]protocol.TopNData, int) {
 	code := transferTopnRequest(req)
 	if code != protocol.CodeOK {
 		newlog.Error("queryTopN: transferTopNRequest failed: %s", protocol.CodeMsg[code])
 		return nil, code
 	}
+	getQueryDebug(ctx).setAlignment(req.Start, req.End, req.DownSample)
 
-	sql := buildTopnQuerySql(req)
-	results, err := QueryTSDB(sql, 2)
+	sql, err := buildTopnQuerySql(req)
 	if err != nil {
-		return nil, protocol.CodeExecTSDBSqlError
+		newlog.Error("queryTopN: build sql failed: %v", err)
+		return nil, protocol.CodeSqlParamError
+	}
+
+	fieldCount := len(req.Fields)
+	results, err := QueryTSDB(ctx, sql, fieldCount+1)
+	if err != nil {
+		return nil, queryErrorCode(err)
 	}
 
 	var topNDataList []protocol.TopNData
+	var topSum float64
 	for _, row := range results {
 		data := protocol.TopNData{
 			Metric: req.Metric,
-			Name:   row[0].(string),
-			Tags:   req.Tags,
-			Value:  row[1].(float64),
+			Tags:   copyTags(req.Tags),
+			Value:  row[fieldCount].(float64),
 		}
-		data.Tags[req.Field] = row[0].(string)
+
+		values := make([]string, fieldCount)
+		for i, field := range req.Fields {
+			values[i] = row[i].(string)
+			data.Tags[field] = values[i]
+		}
+		data.Name = strings.Join(values, "-")
+
+		topSum += data.Value
 		topNDataList = append(topNDataList, data)
 	}
 
+	if req.Others {
+		others, code := queryTopNOthers(ctx, req, len(topNDataList), topSum)
+		if code != protocol.CodeOK {
+			return nil, code
+		}
+
+		if others != nil {
+			topNDataList = append(topNDataList, *others)
+		}
+	}
+
 	return topNDataList, protocol.CodeOK
 }
 
+// queryTopNOthers return the remainder of groups beyond topN, nil if there is no more group
+func queryTopNOthers(ctx context.Context, req *protocol.TopNRequest, topCount int, topSum float64) (*protocol.TopNData, int) {
+	sql, err := buildTopnTotalSql(req)
+	if err != nil {
+		newlog.Error("queryTopN: build total sql failed: %v", err)
+		return nil, protocol.CodeSqlParamError
+	}
+
+	results, err := QueryTSDB(ctx, sql, 2)
+	if err != nil {
+		return nil, queryErrorCode(err)
+	}
+
+	if len(results) == 0 {
+		return nil, protocol.CodeOK
+	}
+
+	groups, _ := results[0][1].(int64)
+	if groups <= int64(topCount) {
+		return nil, protocol.CodeOK
+	}
+
+	total, _ := results[0][0].(float64)
+	return &protocol.TopNData{
+		Metric: req.Metric,
+		Name:   protocol.TopNOthers,
+		Tags:   copyTags(req.Tags),
+		Value:  total - topSum,
+		Others: true,
+	}, protocol.CodeOK
+}
+
 func QueryTopN(w http.ResponseWriter, r *http.Request) {
 	defer monitor.AddMonitorStats(time.Now(), "topN")
+	ctx, cancel := queryContext(r)
+	defer cancel()
+
 	var req protocol.TopNRequest
 	err := protocol.DecodeRequest(r, &req)
 	if err != nil {
@@ -46,6 +109,7 @@ func QueryTopN(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	topNDataList, code := internalQueryTopN(&req)
-	protocol.WriteQueryResp(w, code, topNDataList)
+	ctx, debug := withQueryDebug(ctx, req.Debug)
+	topNDataList, code := internalQueryTopN(ctx, &req)
+	protocol.WriteDebugQueryResp(w, code, topNDataList, debug.result())
 }
@@ -2,16 +2,18 @@ package tsdb
 
 import (
 	"errors"
-	"fmt"
+	"github.com/sentrycloud/sentry/pkg/newlog"
 	"github.com/sentrycloud/sentry/pkg/protocol"
+	"sort"
 	"strings"
 	"time"
 )
 
 const (
-	MaxQueryRange = 3600 * 24 * 5 // max time series data query range is 5 days
-	MaxDownSample = 3600 * 24     // max down sample is 1 day
-	MaxTagCount   = 16
+	MaxQueryRange  = 3600 * 24 * 5  // max time series data query range is 5 days
+	MaxExportRange = 3600 * 24 * 31 // export is in streaming mode, so it can take a larger range
+	MaxDownSample  = 3600 * 24      // max down sample is 1 day
+	MaxTagCount    = 16
 )
 
 func splitTags(tags map[string]string) (map[string]string, map[string]string, error) {
@@ -38,47 +40,31 @@ func splitTags(tags map[string]string) (map[string]string, map[string]string, er
 	return starTags, noStarTags, nil
 }
 
-// all metric and tag key will be included in “ to use the original name
-func buildCurvesRequest(metric string, tags map[string]string, starTags map[string]string) (string, []string) {
-	sqlFormat := "SELECT DISTINCT `%s` FROM `%s` WHERE %s;"
-
-	var starKeys []string
-	var starKeysCondition []string
-	var prefixValueCondition []string
-	for k, v := range starTags {
-		starKeys = append(starKeys, k)
-		starKeysCondition = append(starKeysCondition, "`"+k+"` IS NOT NULL")
+// all metric and tag key are quoted by backtick to use the original name
+func buildCurvesRequest(metric string, tags map[string]string, starTags map[string]string, matchers []protocol.TagMatcher) (string, []string, error) {
+	starKeys := sortedKeys(starTags)
+	builder := selectFrom(metric).Distinct().TagColumn(starKeys...)
+	for _, k := range starKeys {
+		builder.WhereNull(k, false)
 
 		// prefix search
-		if len(v) > 0 {
-			prefix := fmt.Sprintf("`%s` like \"%s%%\"", k, v)
-			prefixValueCondition = append(prefixValueCondition, prefix)
-		}
-	}
-	selectKeys := strings.Join(starKeys, "`,`")
-
-	var condition strings.Builder
-	condition.WriteString(strings.Join(starKeysCondition, " AND "))
-	for k, v := range tags {
-		if strings.Contains(v, "'") {
-			condition.WriteString(fmt.Sprintf(" AND `%s`=\"%s\"", k, v))
-		} else {
-			condition.WriteString(fmt.Sprintf(" AND `%s`='%s'", k, v))
+		if prefix := starTags[k]; len(prefix) > 0 {
+			builder.WherePrefix(k, prefix)
 		}
 	}
 
-	if len(prefixValueCondition) > 0 {
-		condition.WriteString(" AND ")
-		condition.WriteString(strings.Join(prefixValueCondition, " AND "))
-	}
-
-	return fmt.Sprintf(sqlFormat, selectKeys, metric, condition.String()), starKeys
+	sql, err := builder.WhereTags(tags).WhereMatchers(matchers).Build()
+	return sql, starKeys, err
 }
 
 func checkAndTransferTime(last int64, start *int64, end *int64) int {
+	return checkAndTransferTimeWithLimit(last, start, end, MaxQueryRange)
+}
+
+func checkAndTransferTimeWithLimit(last int64, start *int64, end *int64, maxRange int64) int {
 	if last != 0 {
 		// request with last field, so time range will be [now - last, now)
-		if last > MaxQueryRange {
+		if last > maxRange {
 			return protocol.CodeMaxQueryRangeError
 		}
 
@@ -90,7 +76,7 @@ func checkAndTransferTime(last int64, start *int64, end *int64) int {
 			*start, *end = *end, *start // swap the start and end time when start > end
 		}
 
-		if *end-*start > MaxQueryRange {
+		if *end-*start > maxRange {
 			return protocol.CodeMaxQueryRangeError
 		}
 	}
@@ -111,25 +97,26 @@ func alignWithDownSample(downSample int64, start *int64, end *int64) int {
 	return protocol.CodeOK
 }
 
-func splitTagFilters(metric string, reqTags map[string]string) (string, map[string]string, map[string][]string, int) {
+// splitTagFilters split tags to star keys in sorted order, fixed tags and filters with "||" in the value
+func splitTagFilters(metric string, reqTags map[string]string) ([]string, map[string]string, map[string][]string, int) {
 	if len(metric) == 0 {
-		return "", nil, nil, protocol.CodeMetricError
+		return nil, nil, nil, protocol.CodeMetricError
 	}
 
 	if len(reqTags) > MaxTagCount {
-		return "", nil, nil, protocol.CodeTagCountError
+		return nil, nil, nil, protocol.CodeTagCountError
 	}
 
-	var starKey string
+	var starKeys []string
 	tags := make(map[string]string)
 	filters := make(map[string][]string)
 	for k, v := range reqTags {
 		if strings.Contains(k, "*") {
-			return "", nil, nil, protocol.CodeStarKeysError
+			return nil, nil, nil, protocol.CodeStarKeysError
 		}
 
 		if strings.Contains(v, "*") {
-			starKey = k
+			starKeys = append(starKeys, k)
 			continue
 		}
 
@@ -140,15 +127,28 @@ func splitTagFilters(metric string, reqTags map[string]string) (string, map[stri
 		}
 	}
 
-	return starKey, tags, filters, protocol.CodeOK
+	sort.Strings(starKeys)
+	return starKeys, tags, filters, protocol.CodeOK
 }
 
-func transferTimeSeriesDataRequest(req *protocol.TimeSeriesDataRequest) int {
-	code := checkAndTransferTime(req.Last, &req.Start, &req.End)
+// transferTimeSeriesDataRequest check and transfer the request, export can take a larger range,
+// and it's not limited to rollup tables, the query use raw data if rollup can not be used
+func transferTimeSeriesDataRequest(req *protocol.TimeSeriesDataRequest, export bool) int {
+	maxRange := maxQueryRange()
+	if export && maxRange < MaxExportRange {
+		maxRange = MaxExportRange
+	}
+
+	code := checkAndTransferTimeWithLimit(req.Last, &req.Start, &req.End, maxRange)
 	if code != protocol.CodeOK {
 		return code
 	}
 
+	if req.DownSample == protocol.DownSampleAuto || req.MaxPoints > 0 {
+		req.DownSample = protocol.ChooseDownSample(req.End-req.Start, req.MaxPoints, req.DownSample)
+	}
+	longRange := !export && req.End-req.Start > MaxQueryRange
+
 	code = alignWithDownSample(req.DownSample, &req.Start, &req.End)
 	if code != protocol.CodeOK {
 		return code
@@ -160,14 +160,53 @@ func transferTimeSeriesDataRequest(req *protocol.TimeSeriesDataRequest) int {
 		return protocol.CodeAggregatorError
 	}
 
+	req.Function, err = protocol.CheckFunction(req.Function)
+	if err != nil {
+		return protocol.CodeFunctionError
+	}
+
+	if err = protocol.CheckFunctionAggregator(req.Function, req.Aggregator); err != nil {
+		newlog.Error("check function aggregator failed: %v", err)
+		return protocol.CodeAggregatorError
+	}
+
+	if longRange {
+		// only rollup tables can be queried for long range
+		aggregator := req.Aggregator
+		if len(req.Function) > 0 {
+			aggregator = counterRollupAggregator(req.Function)
+		}
+
+		code = checkRollupQuery(aggregator, req.DownSample)
+		if code != protocol.CodeOK {
+			return code
+		}
+	}
+
+	req.Fill, err = protocol.CheckFill(req.Fill)
+	if err != nil {
+		return protocol.CodeFillError
+	}
+
+	req.Smooth, _, err = protocol.CheckSmooth(req.Smooth, req.SmoothWindow)
+	if err != nil {
+		newlog.Error("check smooth failed: %v", err)
+		return protocol.CodeSmoothError
+	}
+
 	for _, m := range req.Metrics {
-		var starKey string
-		starKey, m.Tags, m.Filters, code = splitTagFilters(m.Metric, m.Tags)
+		if err = protocol.CheckMatchers(m.Matchers); err != nil {
+			newlog.Error("check matchers of metric=%s failed: %v", m.Metric, err)
+			return protocol.CodeTagMatcherError
+		}
+
+		var starKeys []string
+		starKeys, m.Tags, m.Filters, code = splitTagFilters(m.Metric, m.Tags)
 		if code != protocol.CodeOK {
 			return code
 		}
 
-		if len(starKey) > 0 {
+		if len(starKeys) > 0 {
 			return protocol.CodeStarKeysError
 		}
 	}
@@ -197,113 +236,68 @@ func transferTopnRequest(req *protocol.TopNRequest) int {
 		return protocol.CodeOrderError
 	}
 
-	req.Field, req.Tags, req.Filters, code = splitTagFilters(req.Metric, req.Tags)
+	var starKeys []string
+	starKeys, req.Tags, req.Filters, code = splitTagFilters(req.Metric, req.Tags)
 	if code != protocol.CodeOK {
 		return code
 	}
 
-	if len(req.Field) == 0 {
+	req.Fields = topnGroupKeys(req.GroupBy, starKeys)
+	if len(req.Fields) == 0 || len(req.Fields) > protocol.MaxTopNGroupKeys {
 		return protocol.CodeStarKeysError
 	}
-	return protocol.CodeOK
-}
 
-func tagsToCondition(tags map[string]string) string {
-	var condition strings.Builder
-	firstKey := true
-	for k, v := range tags {
-		v = quoteValue(v)
-
-		// if key starts with !=, use `key` != 'value` as condition
-		op := "="
-		if strings.HasPrefix(k, "!=") {
-			delete(tags, k) // delete the not equal key so it will not appear in the returned tags map
-			op = "!="
-			k = k[2:]
-		}
-
-		if firstKey {
-			firstKey = false
-			condition.WriteString(fmt.Sprintf("`%s` %s %s", k, op, v))
-		} else {
-			condition.WriteString(fmt.Sprintf(" AND `%s` %s %s", k, op, v))
-		}
+	if req.Others && req.Aggregator != "sum" && req.Aggregator != "count" {
+		// the remainder is total minus the sum of topN, so only additive aggregators can have others
+		newlog.Error("topN others is not supported for aggregator=%s", req.Aggregator)
+		return protocol.CodeAggregatorError
 	}
-
-	return condition.String()
+	return protocol.CodeOK
 }
 
-func filterTagsToCondition(filterTags map[string][]string) string {
-	var condition strings.Builder
-	firstKey := true
-	for key, values := range filterTags {
-		if firstKey {
-			firstKey = false
-			condition.WriteString("(")
-		} else {
-			condition.WriteString(" AND (")
-		}
-
-		// if key starts with !=, use `key` != 'value` as condition
-		op := "="
-		logicalOp := "OR"
-		if strings.HasPrefix(key, "!=") {
-			op = "!="
-			logicalOp = "AND"
-			key = key[2:]
-		}
-
-		firstFilter := true
-		for _, v := range values {
-			v = quoteValue(v)
-
-			if firstFilter {
-				firstFilter = false
-				condition.WriteString(fmt.Sprintf("`%s` %s %s", key, op, v))
-			} else {
-				condition.WriteString(fmt.Sprintf(" %s `%s` %s %s", logicalOp, key, op, v))
+// topnGroupKeys return keys of group_by in order, then star keys not in group_by
+func topnGroupKeys(groupBy []string, starKeys []string) []string {
+	var keys []string
+	for _, list := range [][]string{groupBy, starKeys} {
+		for _, k := range list {
+			if !contains(keys, k) {
+				keys = append(keys, k)
 			}
 		}
-		condition.WriteString(")")
 	}
-
-	return condition.String()
+	return keys
 }
 
-func quoteValue(v string) string {
-	// if tag value contains single quote, use double quote to query, otherwise use single quote to query
-	if strings.Contains(v, "'") {
-		v = "\"" + v + "\""
-	} else {
-		v = "'" + v + "'"
-	}
-	return v
+func buildRangeQuerySql(start int64, end int64, aggregator string, downSample int64, req *protocol.MetricReq) (string, error) {
+	table, aggregate, rollup := querySource(req.Metric, aggregator, start, end, downSample)
+	return selectFrom(table).Column("CAST(FIRST(_ts) as BIGINT)").Column(aggregate).TimeRange(start, end, rollup).
+		WhereTags(req.Tags).WhereFilters(req.Filters).WhereMatchers(req.Matchers).Interval(downSample).Build()
 }
 
-func buildRangeQuerySql(start int64, end int64, aggregator string, downSample int64, req *protocol.MetricReq) string {
-	tagsCondition := tagsToCondition(req.Tags)
-	if len(req.Filters) > 0 {
-		tagsCondition += " AND " + filterTagsToCondition(req.Filters)
-	}
-
-	if len(tagsCondition) > 0 {
-		tagsCondition = " AND " + tagsCondition
-	}
-
-	sqlFormat := "SELECT CAST(FIRST(_ts) as BIGINT),%s(_value) FROM `%s` WHERE _ts > %d AND _ts < %d %s INTERVAL(%ds)"
-	return fmt.Sprintf(sqlFormat, aggregator, req.Metric, start, end, tagsCondition, downSample)
+// buildCounterRangeQuerySql query a data point of each child table in each interval, the timestamp is the interval start,
+// so data points of child tables can be aggregated after the counter function:
+// SELECT CAST(_wstart as BIGINT),LAST(_value),tbname FROM `m` WHERE ... PARTITION BY tbname INTERVAL(10s)
+func buildCounterRangeQuerySql(start int64, end int64, function string, downSample int64, req *protocol.MetricReq) (string, error) {
+	table, aggregate, rollup := counterSource(req.Metric, function, start, end, downSample)
+	return selectFrom(table).Column("CAST(_wstart as BIGINT)").Column(aggregate).Column("tbname").TimeRange(start, end, rollup).
+		WhereTags(req.Tags).WhereFilters(req.Filters).WhereMatchers(req.Matchers).PartitionByTable().Interval(downSample).Build()
 }
 
-func buildTopnQuerySql(req *protocol.TopNRequest) string {
-	tagsCondition := tagsToCondition(req.Tags)
-	if len(req.Filters) > 0 {
-		tagsCondition += " AND " + filterTagsToCondition(req.Filters)
+// buildTopnGroupQuery aggregate values of each combination of group keys
+func buildTopnGroupQuery(req *protocol.TopNRequest) *sqlBuilder {
+	query := selectFrom(req.Metric).TagColumn(req.Fields...).Column(protocol.AggregatorFunction(req.Aggregator, "_value")+" as v").
+		TimeRange(req.Start, req.End, false).WhereTags(req.Tags).WhereFilters(req.Filters)
+	for _, field := range req.Fields {
+		query.WhereNull(field, false)
 	}
+	return query.GroupBy(req.Fields...)
+}
 
-	if len(tagsCondition) > 0 {
-		tagsCondition = " AND " + tagsCondition
-	}
+func buildTopnQuerySql(req *protocol.TopNRequest) (string, error) {
+	return selectFromQuery(buildTopnGroupQuery(req)).TagColumn(req.Fields...).Column("v").OrderBy("v", req.Order).Limit(req.Limit).Build()
+}
 
-	sqlFormat := "SELECT `%s`,v FROM (SELECT `%s`,%s(_value) as v FROM `%s` WHERE _ts > %d AND _ts < %d %s AND `%s` IS NOT NULL GROUP BY `%s`) order by v %s limit %d;"
-	return fmt.Sprintf(sqlFormat, req.Field, req.Field, req.Aggregator, req.Metric, req.Start, req.End, tagsCondition, req.Field, req.Field, req.Order, req.Limit)
+// buildTopnTotalSql sum values of all groups and count the groups, the remainder beyond topN is computed from them
+func buildTopnTotalSql(req *protocol.TopNRequest) (string, error) {
+	return selectFromQuery(buildTopnGroupQuery(req)).Column("SUM(v)").Column("COUNT(*)").Build()
 }
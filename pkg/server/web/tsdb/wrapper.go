@@ -1,44 +1,183 @@
 package tsdb
 
 import (
+	"context"
 	"database/sql/driver"
 	"errors"
 	"github.com/sentrycloud/sentry/pkg/newlog"
+	"github.com/sentrycloud/sentry/pkg/protocol"
 	"github.com/sentrycloud/sentry/pkg/server/config"
 	"github.com/sentrycloud/sentry/pkg/server/taos"
 	"io"
+	"net/http"
+	"time"
+)
+
+var (
+	ErrQueryTimeout   = errors.New("query timeout")
+	ErrQueryCanceled  = errors.New("query canceled")
+	ErrTooManyQueries = errors.New("too many concurrent queries")
 )
 
 // separate connection pool for query
 var connPool *taos.ConnPool
 
+var taosDatabase string // database name to query information_schema
+
+var (
+	queryTimeout     = 30 * time.Second
+	querySemaphore   = make(chan struct{}, 64) // limit in-flight queries on TDengine
+	dashboardWorkers = 8                       // charts of a dashboard data request that are queried concurrently
+)
+
 func Init(taosServer config.TaosConfig) {
 	connPool = taos.CreateConnPool(taosServer)
+	taosDatabase = taosServer.Database
+}
+
+// InitQueryLimit set the timeout of a query request and the max count of in-flight queries
+func InitQueryLimit(queryConfig config.QueryConfig) {
+	if queryConfig.Timeout > 0 {
+		queryTimeout = time.Duration(queryConfig.Timeout) * time.Second
+	}
+
+	if queryConfig.MaxConcurrent > 0 {
+		querySemaphore = make(chan struct{}, queryConfig.MaxConcurrent)
+	}
+
+	if queryConfig.DashboardWorkers > 0 {
+		dashboardWorkers = queryConfig.DashboardWorkers
+	}
+}
+
+// queryContext return a context that is canceled when the client disconnects or the query timeout
+func queryContext(r *http.Request) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(r.Context(), queryTimeout)
+}
+
+func contextError(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return ErrQueryTimeout
+	}
+	return ErrQueryCanceled
+}
+
+// queryErrorCode map query error to error code, so the client can tell timeout and overload from sql error
+func queryErrorCode(err error) int {
+	switch {
+	case errors.Is(err, ErrQueryTimeout):
+		return protocol.CodeQueryTimeoutError
+	case errors.Is(err, ErrQueryCanceled):
+		return protocol.CodeQueryCanceledError
+	case errors.Is(err, ErrTooManyQueries):
+		return protocol.CodeTooManyQueriesError
+	}
+	return protocol.CodeExecTSDBSqlError
+}
+
+// isAbortCode return true if the query can not continue, other queries of the same request should be stopped too
+func isAbortCode(code int) bool {
+	return code == protocol.CodeQueryTimeoutError || code == protocol.CodeQueryCanceledError || code == protocol.CodeTooManyQueriesError
 }
 
 // QueryTSDB no reflection version, user need to parse value, but no need to open conn, query, parse each row
-func QueryTSDB(sql string, totalColumn int) ([][]driver.Value, error) {
+func QueryTSDB(ctx context.Context, sql string, totalColumn int) ([][]driver.Value, error) {
+	var result [][]driver.Value
+	err := QueryTSDBStream(ctx, sql, totalColumn, func(values []driver.Value) error {
+		result = append(result, values)
+		return nil
+	})
+	return result, err
+}
+
+type queryResult struct {
+	rows driver.Rows
+	err  error
+}
+
+// QueryTSDBStream call handleRow for each row instead of holding all rows in memory,
+// it's used for large result set, such as exporting raw data points. if handleRow return error, the query stops.
+// the query returns when ctx is done, the driver can not cancel a running query, so the connection and
+// the in-flight slot are released after TDengine returns, and rows are not fetched any more
+func QueryTSDBStream(ctx context.Context, sql string, totalColumn int, handleRow func(values []driver.Value) error) error {
+	debug := getQueryDebug(ctx)
+	if debug == nil {
+		return queryTSDBStream(ctx, sql, totalColumn, handleRow)
+	}
+
+	startTime := time.Now()
+	rows := 0
+	err := queryTSDBStream(ctx, sql, totalColumn, func(values []driver.Value) error {
+		rows++
+		return handleRow(values)
+	})
+	debug.addQuery(sql, startTime, rows, err)
+	return err
+}
+
+func queryTSDBStream(ctx context.Context, sql string, totalColumn int, handleRow func(values []driver.Value) error) error {
+	if ctx.Err() != nil {
+		return contextError(ctx)
+	}
+
+	select {
+	case querySemaphore <- struct{}{}:
+	default:
+		newlog.Error("too many concurrent queries, reject: %s", sql)
+		return ErrTooManyQueries
+	}
+
 	conn, err := connPool.GetConn()
 	if err != nil {
+		<-querySemaphore
 		errMsg := "get TSDB conn from pool failed: " + err.Error()
 		newlog.Error(errMsg)
-		return nil, errors.New(errMsg)
+		return errors.New(errMsg)
 	}
 
-	defer connPool.PutConn(conn)
+	resultChan := make(chan queryResult, 1)
+	go func() {
+		rows, e := conn.Query(sql)
+		resultChan <- queryResult{rows: rows, err: e}
+	}()
 
-	rows, err := conn.Query(sql)
-	if err != nil {
-		errMsg := "query TSDB failed: " + err.Error()
+	var result queryResult
+	select {
+	case result = <-resultChan:
+	case <-ctx.Done():
+		newlog.Warn("%v: %s", contextError(ctx), sql)
+		go func() {
+			abandoned := <-resultChan
+			if abandoned.rows != nil {
+				_ = abandoned.rows.Close()
+			}
+			connPool.PutConn(conn)
+			<-querySemaphore
+		}()
+		return contextError(ctx)
+	}
+
+	defer func() {
+		connPool.PutConn(conn)
+		<-querySemaphore
+	}()
+
+	if result.err != nil {
+		errMsg := "query TSDB failed: " + result.err.Error()
 		newlog.Error(errMsg)
-		return nil, errors.New(errMsg)
+		return errors.New(errMsg)
 	}
 
+	rows := result.rows
 	defer rows.Close()
 
-	err = nil
-	var result [][]driver.Value
 	for {
+		if ctx.Err() != nil {
+			err = contextError(ctx)
+			newlog.Warn("%v when fetching rows: %s", err, sql)
+			break
+		}
+
 		values := make([]driver.Value, totalColumn)
 		err = rows.Next(values)
 		if err != nil {
@@ -50,7 +189,10 @@ func QueryTSDB(sql string, totalColumn int) ([][]driver.Value, error) {
 			break
 		}
 
-		result = append(result, values)
+		err = handleRow(values)
+		if err != nil {
+			break
+		}
 	}
-	return result, err
+	return err
 }
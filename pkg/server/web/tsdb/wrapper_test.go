@@ -1,7 +1,9 @@
 package tsdb
 
 import (
+	"context"
 	"fmt"
+	"github.com/sentrycloud/sentry/pkg/protocol"
 	"github.com/sentrycloud/sentry/pkg/server/config"
 	"testing"
 )
@@ -18,7 +20,7 @@ func TestWrapper(t *testing.T) {
 	Init(taosServer)
 
 	// query metric
-	result, err := QueryTSDB("show stables", 1)
+	result, err := QueryTSDB(context.Background(), "show stables", 1)
 	if err != nil {
 		fmt.Println("Query failed: " + err.Error())
 		return
@@ -29,3 +31,29 @@ func TestWrapper(t *testing.T) {
 		fmt.Println(row[0].(string))
 	}
 }
+
+func TestQueryLimit(t *testing.T) {
+	// restore the previous limit, so other tests are not affected
+	previousTimeout, previousSemaphore := queryTimeout, querySemaphore
+	defer func() { queryTimeout, querySemaphore = previousTimeout, previousSemaphore }()
+	InitQueryLimit(config.QueryConfig{Timeout: 1, MaxConcurrent: 1})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := QueryTSDB(ctx, "show stables", 1); queryErrorCode(err) != protocol.CodeQueryCanceledError {
+		t.Errorf("expect canceled, got %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	if _, err := QueryTSDB(ctx, "show stables", 1); queryErrorCode(err) != protocol.CodeQueryTimeoutError {
+		t.Errorf("expect timeout, got %v", err)
+	}
+
+	// the only slot is in use, so the query is rejected
+	querySemaphore <- struct{}{}
+	if _, err := QueryTSDB(context.Background(), "show stables", 1); queryErrorCode(err) != protocol.CodeTooManyQueriesError {
+		t.Errorf("expect too many queries, got %v", err)
+	}
+	<-querySemaphore
+}